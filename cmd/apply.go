@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"github.com/Lord-Y/synker/logger"
+	"github.com/Lord-Y/synker/processing"
+	"github.com/urfave/cli/v2"
+)
+
+// Apply command options
+func Apply(c *cli.Context) (z *cli.Command) {
+	return &cli.Command{
+		Name:  "apply",
+		Usage: "Apply the differences between schemas and elasticsearch / kafka / cockroachdb",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "config-dir",
+				Aliases:     []string{"c"},
+				Usage:       "Config dir name holding files",
+				Required:    true,
+				Destination: &cmdValidate.ConfigDir,
+			},
+			&cli.BoolFlag{
+				Name:  "allow-destructive",
+				Usage: "Apply changes that may lose data like recreating an index or a changefeed",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			cmdValidate.Logger = logger.NewLogger()
+			requireInfrastructure()
+
+			cmdValidate.ParseAndValidateConfig()
+			cmdValidate.Apply(processing.ApplyOptions{
+				AllowDestructive: c.Bool("allow-destructive"),
+			})
+			return nil
+		},
+	}
+}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Lord-Y/synker/logger"
+	"github.com/Lord-Y/synker/processing"
+	"github.com/urfave/cli/v2"
+)
+
+// Backfill command options
+func Backfill(c *cli.Context) (z *cli.Command) {
+	return &cli.Command{
+		Name:  "backfill",
+		Usage: "Index rows already present in the table of the schema",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "config-dir",
+				Aliases:     []string{"c"},
+				Usage:       "Config dir name holding files",
+				Required:    true,
+				Destination: &cmdValidate.ConfigDir,
+			},
+			&cli.StringFlag{
+				Name:     "schema",
+				Aliases:  []string{"s"},
+				Usage:    "Schema name to backfill",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:  "checkpoint",
+				Usage: "File holding the progress of the backfill. Default to synker-backfill-<schema>.json",
+			},
+			&cli.IntFlag{
+				Name:  "rows-per-second",
+				Usage: "Maximum number of rows read per second. 0 means unlimited",
+			},
+			&cli.IntFlag{
+				Name:  "page-size",
+				Usage: "Number of rows read per query",
+				Value: 1000,
+			},
+			&cli.BoolFlag{
+				Name:  "restart",
+				Usage: "Ignore the existing checkpoint and start from the first row",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			cmdValidate.Logger = logger.NewLogger()
+
+			if strings.TrimSpace(os.Getenv("SYNKER_PG_URI")) == "" {
+				msg := "SYNKER_PG_URI environment variable must be set"
+				cmdValidate.Logger.Fatal().Err(fmt.Errorf("%s", msg)).Msg(msg)
+			}
+
+			cmdValidate.ParseAndValidateConfig()
+			cmdValidate.Backfill(processing.BackfillOptions{
+				Schema:        c.String("schema"),
+				Checkpoint:    c.String("checkpoint"),
+				RowsPerSecond: c.Int("rows-per-second"),
+				PageSize:      c.Int("page-size"),
+				Restart:       c.Bool("restart"),
+			})
+			return nil
+		},
+	}
+}
@@ -0,0 +1,62 @@
+// Package cmd manage all commands required to launch cypress-parallel-cli
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Lord-Y/synker/logger"
+	"github.com/Lord-Y/synker/processing"
+	"github.com/urfave/cli/v2"
+)
+
+// DLQ commands
+func DLQ(c *cli.Context) (z *cli.Command) {
+	return &cli.Command{
+		Name:  "dlq",
+		Usage: "options related to dead letter topics",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "replay",
+				Usage: "Push messages of the dead letter topic back into the topic of the schema",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:        "config-dir",
+						Aliases:     []string{"c"},
+						Usage:       "Config dir name holding files",
+						Required:    true,
+						Destination: &cmdValidate.ConfigDir,
+					},
+					&cli.StringFlag{
+						Name:     "schema",
+						Aliases:  []string{"s"},
+						Usage:    "Schema name of the dead letter topic to replay",
+						Required: true,
+					},
+					&cli.DurationFlag{
+						Name:  "idle-timeout",
+						Usage: "Maximum time to wait for a message before failing while the end offsets are not reached",
+						Value: time.Minute,
+					},
+				},
+				Action: func(c *cli.Context) error {
+					cmdValidate.Logger = logger.NewLogger()
+
+					if strings.TrimSpace(os.Getenv("SYNKER_KAFKA_URI")) == "" {
+						msg := "SYNKER_KAFKA_URI environment variable must be set"
+						cmdValidate.Logger.Fatal().Err(fmt.Errorf("%s", msg)).Msg(msg)
+					}
+
+					cmdValidate.ParseAndValidateConfig()
+					cmdValidate.ReplayDeadLetter(processing.ReplayOptions{
+						Schema:      c.String("schema"),
+						IdleTimeout: c.Duration("idle-timeout"),
+					})
+					return nil
+				},
+			},
+		},
+	}
+}
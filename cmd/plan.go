@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Lord-Y/synker/logger"
+	"github.com/urfave/cli/v2"
+)
+
+// requireInfrastructure permit to check that all environment variables
+// required to reach elasticsearch / kafka / cockroachdb are set
+func requireInfrastructure() {
+	for _, name := range []string{"SYNKER_PG_URI", "SYNKER_ELASTICSEARCH_URI", "SYNKER_KAFKA_URI"} {
+		if strings.TrimSpace(os.Getenv(name)) == "" {
+			msg := fmt.Sprintf("%s environment variable must be set", name)
+			cmdValidate.Logger.Fatal().Err(fmt.Errorf("%s", msg)).Msg(msg)
+		}
+	}
+}
+
+// Plan command options
+func Plan(c *cli.Context) (z *cli.Command) {
+	return &cli.Command{
+		Name:  "plan",
+		Usage: "Show the differences between schemas and elasticsearch / kafka / cockroachdb",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "config-dir",
+				Aliases:     []string{"c"},
+				Usage:       "Config dir name holding files",
+				Required:    true,
+				Destination: &cmdValidate.ConfigDir,
+			},
+			&cli.StringFlag{
+				Name:    "output",
+				Aliases: []string{"o"},
+				Usage:   "Output format, one of text or json",
+				Value:   "text",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			cmdValidate.Logger = logger.NewLogger()
+
+			output := c.String("output")
+			if output != "text" && output != "json" {
+				msg := fmt.Sprintf("Output %s is not supported, use text or json", output)
+				cmdValidate.Logger.Fatal().Err(fmt.Errorf("%s", msg)).Msg(msg)
+			}
+			requireInfrastructure()
+
+			cmdValidate.ParseAndValidateConfig()
+			cmdValidate.Plan(output)
+			return nil
+		},
+	}
+}
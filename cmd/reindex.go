@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Lord-Y/synker/logger"
+	"github.com/Lord-Y/synker/processing"
+	"github.com/urfave/cli/v2"
+)
+
+// Reindex command options
+func Reindex(c *cli.Context) (z *cli.Command) {
+	return &cli.Command{
+		Name:  "reindex",
+		Usage: "Rebuild the index of the schema with its mapping and move the alias without downtime",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "config-dir",
+				Aliases:     []string{"c"},
+				Usage:       "Config dir name holding files",
+				Required:    true,
+				Destination: &cmdValidate.ConfigDir,
+			},
+			&cli.StringFlag{
+				Name:     "schema",
+				Aliases:  []string{"s"},
+				Usage:    "Schema name to reindex",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:  "checkpoint",
+				Usage: "File holding the progress of the backfill of the new index. Default to synker-reindex-<index>.json",
+			},
+			&cli.IntFlag{
+				Name:  "rows-per-second",
+				Usage: "Maximum number of rows read per second. 0 means unlimited",
+			},
+			&cli.IntFlag{
+				Name:  "page-size",
+				Usage: "Number of rows read per query",
+				Value: 1000,
+			},
+			&cli.Int64Flag{
+				Name:  "max-count-diff",
+				Usage: "Maximum difference of documents tolerated between both indexes before moving the alias",
+			},
+			&cli.DurationFlag{
+				Name:  "delete-after",
+				Usage: "Time to wait before deleting the old index once the alias is moved like 24h. 0 keeps the old index",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			cmdValidate.Logger = logger.NewLogger()
+
+			if strings.TrimSpace(os.Getenv("SYNKER_PG_URI")) == "" {
+				msg := "SYNKER_PG_URI environment variable must be set"
+				cmdValidate.Logger.Fatal().Err(fmt.Errorf("%s", msg)).Msg(msg)
+			}
+
+			cmdValidate.ParseAndValidateConfig()
+			cmdValidate.Reindex(processing.ReindexOptions{
+				Schema:        c.String("schema"),
+				Checkpoint:    c.String("checkpoint"),
+				RowsPerSecond: c.Int("rows-per-second"),
+				PageSize:      c.Int("page-size"),
+				MaxCountDiff:  c.Int64("max-count-diff"),
+				DeleteAfter:   c.Duration("delete-after"),
+			})
+			return nil
+		},
+	}
+}
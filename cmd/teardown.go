@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"time"
+
+	"github.com/Lord-Y/synker/logger"
+	"github.com/Lord-Y/synker/processing"
+	"github.com/urfave/cli/v2"
+)
+
+// Teardown command options
+func Teardown(c *cli.Context) (z *cli.Command) {
+	return &cli.Command{
+		Name:  "teardown",
+		Usage: "Remove the changefeed, consumer groups, topics and index of the schema",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "config-dir",
+				Aliases:     []string{"c"},
+				Usage:       "Config dir name holding files",
+				Required:    true,
+				Destination: &cmdValidate.ConfigDir,
+			},
+			&cli.StringFlag{
+				Name:     "schema",
+				Aliases:  []string{"s"},
+				Usage:    "Schema name to tear down",
+				Required: true,
+			},
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "Only print the resources that would be removed",
+			},
+			&cli.BoolFlag{
+				Name:  "detach",
+				Usage: "Remove the indexes from the alias of the schema instead of deleting them",
+			},
+			&cli.BoolFlag{
+				Name:    "yes",
+				Aliases: []string{"y"},
+				Usage:   "Skip the confirmation",
+			},
+			&cli.DurationFlag{
+				Name:  "timeout",
+				Usage: "Maximum time to wait for changefeed jobs to stop",
+				Value: time.Minute,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			cmdValidate.Logger = logger.NewLogger()
+			requireInfrastructure()
+
+			cmdValidate.ParseAndValidateConfig()
+			cmdValidate.Teardown(processing.TeardownOptions{
+				Schema:  c.String("schema"),
+				DryRun:  c.Bool("dry-run"),
+				Detach:  c.Bool("detach"),
+				Yes:     c.Bool("yes"),
+				Timeout: c.Duration("timeout"),
+			})
+			return nil
+		},
+	}
+}
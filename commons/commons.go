@@ -2,8 +2,11 @@
 package commons
 
 import (
+	"fmt"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // GetPGURI permit to retrieve string url to connect to the sql instance
@@ -16,11 +19,31 @@ func GetElasticsearchURI() string {
 	return strings.TrimSpace(os.Getenv("SYNKER_ELASTICSEARCH_URI"))
 }
 
+// GetOpensearchURI permit to retrieve OS env variable
+func GetOpensearchURI() string {
+	return strings.TrimSpace(os.Getenv("SYNKER_OPENSEARCH_URI"))
+}
+
+// GetSchemaRegistryURI permit to retrieve OS env variable
+func GetSchemaRegistryURI() string {
+	return strings.TrimSpace(os.Getenv("SYNKER_SCHEMA_REGISTRY_URI"))
+}
+
 // GetKafkaURI permit to retrieve OS env variable
 func GetKafkaURI() string {
 	return strings.TrimSpace(os.Getenv("SYNKER_KAFKA_URI"))
 }
 
+// GetChangeFeedSinkURI permit to retrieve OS env variable
+// defining the kafka uri used by cockroach changefeeds.
+// Default to kafka:// followed by SYNKER_KAFKA_URI
+func GetChangeFeedSinkURI() string {
+	if z := strings.TrimSpace(os.Getenv("SYNKER_CHANGEFEED_SINK_URI")); z != "" {
+		return z
+	}
+	return fmt.Sprintf("kafka://%s", GetKafkaURI())
+}
+
 // GetKafkaScram permit to retrieve OS env variable
 func GetKafkaScram() string {
 	return strings.TrimSpace(os.Getenv("SYNKER_KAFKA_SCRAM"))
@@ -50,3 +73,143 @@ func GetKafkaCert() string {
 func GetKafkaKey() string {
 	return strings.TrimSpace(os.Getenv("SYNKER_KAFKA_KEY"))
 }
+
+// GetConsumerMaxFailures permit to retrieve OS env variable
+// defining the number of consecutive failures before a consumer is unhealthy
+func GetConsumerMaxFailures() int {
+	return getInt("SYNKER_CONSUMER_MAX_FAILURES", 5)
+}
+
+// GetConsumerMinBackoff permit to retrieve OS env variable
+// defining the minimum time to wait before restarting a consumer
+func GetConsumerMinBackoff() time.Duration {
+	return getDuration("SYNKER_CONSUMER_MIN_BACKOFF", time.Second)
+}
+
+// GetConsumerMaxBackoff permit to retrieve OS env variable
+// defining the maximum time to wait before restarting a consumer
+func GetConsumerMaxBackoff() time.Duration {
+	return getDuration("SYNKER_CONSUMER_MAX_BACKOFF", time.Minute)
+}
+
+// getInt permit to retrieve OS env variable as integer
+// or the default value when not set or invalid
+func getInt(name string, value int) int {
+	z, err := strconv.Atoi(strings.TrimSpace(os.Getenv(name)))
+	if err != nil || z <= 0 {
+		return value
+	}
+	return z
+}
+
+// getDuration permit to retrieve OS env variable as duration
+// or the default value when not set or invalid
+func getDuration(name string, value time.Duration) time.Duration {
+	z, err := time.ParseDuration(strings.TrimSpace(os.Getenv(name)))
+	if err != nil || z <= 0 {
+		return value
+	}
+	return z
+}
+
+// GetDrainTimeout permit to retrieve OS env variable
+// defining the maximum time to wait for in-flight messages on shutdown
+func GetDrainTimeout() time.Duration {
+	return getDuration("SYNKER_DRAIN_TIMEOUT", 30*time.Second)
+}
+
+// GetPGMaxConns permit to retrieve OS env variable
+// defining the maximum number of connections of the cockroach pool
+func GetPGMaxConns() int {
+	return getInt("SYNKER_PG_MAX_CONNS", 10)
+}
+
+// GetPGMinConns permit to retrieve OS env variable
+// defining the minimum number of connections of the cockroach pool
+func GetPGMinConns() int {
+	return getInt("SYNKER_PG_MIN_CONNS", 1)
+}
+
+// GetPGConnectTimeout permit to retrieve OS env variable
+// defining the maximum time to wait for a cockroach connection
+func GetPGConnectTimeout() time.Duration {
+	return getDuration("SYNKER_PG_CONNECT_TIMEOUT", 10*time.Second)
+}
+
+// GetPGHealthCheckPeriod permit to retrieve OS env variable
+// defining the time between health checks of idle cockroach connections
+func GetPGHealthCheckPeriod() time.Duration {
+	return getDuration("SYNKER_PG_HEALTH_CHECK_PERIOD", time.Minute)
+}
+
+// GetElasticsearchMaxIdleConns permit to retrieve OS env variable
+// defining the maximum number of idle connections kept to elasticsearch
+func GetElasticsearchMaxIdleConns() int {
+	return getInt("SYNKER_ELASTICSEARCH_MAX_IDLE_CONNS", 32)
+}
+
+// GetElasticsearchConnectTimeout permit to retrieve OS env variable
+// defining the maximum time to wait for an elasticsearch connection
+func GetElasticsearchConnectTimeout() time.Duration {
+	return getDuration("SYNKER_ELASTICSEARCH_CONNECT_TIMEOUT", 10*time.Second)
+}
+
+// GetElasticsearchHealthCheckInterval permit to retrieve OS env variable
+// defining the time between health checks of elasticsearch nodes
+func GetElasticsearchHealthCheckInterval() time.Duration {
+	return getDuration("SYNKER_ELASTICSEARCH_HEALTH_CHECK_INTERVAL", time.Minute)
+}
+
+// GetKafkaConnectTimeout permit to retrieve OS env variable
+// defining the maximum time to wait for a kafka connection
+func GetKafkaConnectTimeout() time.Duration {
+	return getDuration("SYNKER_KAFKA_CONNECT_TIMEOUT", 10*time.Second)
+}
+
+// GetKafkaIdleTimeout permit to retrieve OS env variable
+// defining the time after which idle kafka connections are closed
+func GetKafkaIdleTimeout() time.Duration {
+	return getDuration("SYNKER_KAFKA_IDLE_TIMEOUT", 30*time.Second)
+}
+
+// GetSchemaRegistryTimeout permit to retrieve OS env variable
+// defining the maximum time to wait for the schema registry
+func GetSchemaRegistryTimeout() time.Duration {
+	return getDuration("SYNKER_SCHEMA_REGISTRY_TIMEOUT", 10*time.Second)
+}
+
+// GetReindexRefreshInterval permit to retrieve OS env variable
+// defining how often consumers look for indexes being rebuilt by a reindex
+func GetReindexRefreshInterval() time.Duration {
+	return getDuration("SYNKER_REINDEX_REFRESH_INTERVAL", 10*time.Second)
+}
+
+// GetChangeFeedPollInterval permit to retrieve OS env variable
+// defining how often the status of changefeed jobs is checked
+func GetChangeFeedPollInterval() time.Duration {
+	return getDuration("SYNKER_CHANGEFEED_POLL_INTERVAL", 30*time.Second)
+}
+
+// GetChangeFeedResumePolicy permit to retrieve OS env variable
+// defining which changefeed jobs are automatically resumed.
+// One of never, paused or failed. Default to paused
+func GetChangeFeedResumePolicy() string {
+	switch z := strings.ToLower(strings.TrimSpace(os.Getenv("SYNKER_CHANGEFEED_RESUME_POLICY"))); z {
+	case "never", "failed":
+		return z
+	default:
+		return "paused"
+	}
+}
+
+// GetChangeFeedResumeAfter permit to retrieve OS env variable
+// defining the time a changefeed job must be paused or failed before being resumed
+func GetChangeFeedResumeAfter() time.Duration {
+	return getDuration("SYNKER_CHANGEFEED_RESUME_AFTER", time.Minute)
+}
+
+// GetChangeFeedResumeMaxAttempts permit to retrieve OS env variable
+// defining the number of resumes of a changefeed job before giving up
+func GetChangeFeedResumeMaxAttempts() int {
+	return getInt("SYNKER_CHANGEFEED_RESUME_MAX_ATTEMPTS", 3)
+}
@@ -15,6 +15,12 @@ var (
 	CmdValidate    *cli.Command
 	CmdAPI         *cli.Command
 	CmdInit        *cli.Command
+	CmdDLQ         *cli.Command
+	CmdBackfill    *cli.Command
+	CmdReindex     *cli.Command
+	CmdPlan        *cli.Command
+	CmdApply       *cli.Command
+	CmdTeardown    *cli.Command
 )
 
 func init() {
@@ -22,6 +28,12 @@ func init() {
 	VersionDetails = cmd.VersionDetails(&cli.Context{})
 	CmdAPI = cmd.API(&cli.Context{})
 	CmdInit = cmd.Init(&cli.Context{})
+	CmdDLQ = cmd.DLQ(&cli.Context{})
+	CmdBackfill = cmd.Backfill(&cli.Context{})
+	CmdReindex = cmd.Reindex(&cli.Context{})
+	CmdPlan = cmd.Plan(&cli.Context{})
+	CmdApply = cmd.Apply(&cli.Context{})
+	CmdTeardown = cmd.Teardown(&cli.Context{})
 }
 
 func main() {
@@ -37,6 +49,12 @@ func main() {
 		CmdValidate,
 		CmdInit,
 		CmdAPI,
+		CmdDLQ,
+		CmdBackfill,
+		CmdReindex,
+		CmdPlan,
+		CmdApply,
+		CmdTeardown,
 	}
 
 	if err := app.Run(os.Args); err != nil {
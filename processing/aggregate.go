@@ -0,0 +1,157 @@
+// Package processing provide all requirements to process change data capture
+package processing
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// validateAggregate permit to check aggregate requirements
+func validateAggregate(aggregate sqlAggregate) (err error) {
+	if len(aggregate.GroupBy) == 0 {
+		if len(aggregate.Collections) > 0 || len(aggregate.OrderBy) > 0 {
+			return fmt.Errorf("Aggregate groupBy is required with collections and orderBy")
+		}
+		return
+	}
+	if len(aggregate.Collections) == 0 {
+		return fmt.Errorf("Aggregate collections are required with groupBy")
+	}
+
+	groupBy := make(map[string]bool)
+	for _, column := range aggregate.GroupBy {
+		groupBy[column] = true
+	}
+	names := make(map[string]bool)
+	columns := make(map[string]string)
+	for _, collection := range aggregate.Collections {
+		name := strings.TrimSpace(collection.Name)
+		if name == "" {
+			return fmt.Errorf("Aggregate collection name is required")
+		}
+		if names[name] {
+			return fmt.Errorf("Aggregate collection %s is defined twice", name)
+		}
+		names[name] = true
+		if len(collection.Columns) == 0 {
+			return fmt.Errorf("Aggregate collection %s must contain at least one column", name)
+		}
+		if collection.Limit < 0 {
+			return fmt.Errorf("Aggregate collection %s limit must be positive", name)
+		}
+		for _, column := range collection.Columns {
+			if groupBy[column] {
+				return fmt.Errorf("Column %s of aggregate collection %s cannot be used in groupBy", column, name)
+			}
+			if other, ok := columns[column]; ok {
+				return fmt.Errorf("Column %s is used by aggregate collections %s and %s", column, other, name)
+			}
+			columns[column] = name
+		}
+	}
+	for _, expression := range aggregate.OrderBy {
+		if strings.TrimSpace(expression) == "" {
+			return fmt.Errorf("Aggregate orderBy cannot be empty")
+		}
+		if err = validateChangeFeedSQL(expression); err != nil {
+			return fmt.Errorf("Aggregate orderBy `%s` %s", expression, err.Error())
+		}
+	}
+	return
+}
+
+// aggregateRows permit to fold rows sharing the groupBy columns of the first row
+// into one document where collection columns are moved into nested arrays.
+// No document is returned when there is no row
+func aggregateRows(rows []map[string]interface{}, aggregate sqlAggregate) (z map[string]interface{}, err error) {
+	if len(rows) == 0 {
+		return
+	}
+	z = make(map[string]interface{})
+
+	collectionColumns := make(map[string]bool)
+	for _, collection := range aggregate.Collections {
+		for _, column := range collection.Columns {
+			collectionColumns[column] = true
+		}
+	}
+
+	group, err := aggregateKey(rows[0], aggregate.GroupBy)
+	if err != nil {
+		return
+	}
+	for column, v := range rows[0] {
+		if !collectionColumns[column] {
+			z[column] = v
+		}
+	}
+
+	for _, collection := range aggregate.Collections {
+		items := []interface{}{}
+		seen := make(map[string]bool)
+		for _, row := range rows {
+			key, err := aggregateKey(row, aggregate.GroupBy)
+			if err != nil {
+				return nil, err
+			}
+			if key != group {
+				continue
+			}
+
+			item := make(map[string]interface{}, len(collection.Columns))
+			empty := true
+			for _, column := range collection.Columns {
+				if row[column] != nil {
+					empty = false
+				}
+				item[strings.TrimPrefix(column, collection.Name+".")] = row[column]
+			}
+			// rows of a left join without any match
+			if empty {
+				continue
+			}
+
+			// rows are duplicated when several collections are joined
+			b, err := json.Marshal(item)
+			if err != nil {
+				return nil, err
+			}
+			if seen[string(b)] {
+				continue
+			}
+			seen[string(b)] = true
+
+			if collection.Limit > 0 && len(items) >= collection.Limit {
+				break
+			}
+			items = append(items, item)
+		}
+		z[collection.Name] = items
+	}
+	return
+}
+
+// aggregateLimit return the maximum number of rows to fetch for the aggregate.
+// Only an aggregate with one limited collection can be limited in SQL
+// as rows of several collections are the product of their joins
+func aggregateLimit(aggregate sqlAggregate) int {
+	if len(aggregate.Collections) != 1 {
+		return 0
+	}
+	return aggregate.Collections[0].Limit
+}
+
+// aggregateKey return the key identifying the group of the row
+func aggregateKey(row map[string]interface{}, groupBy []string) (string, error) {
+	var values []interface{}
+	for _, column := range groupBy {
+		v, ok := row[column]
+		if !ok {
+			return "", fmt.Errorf("Column %s of aggregate groupBy is missing from SQL query", column)
+		}
+		values = append(values, v)
+	}
+	b, err := json.Marshal(values)
+	return string(b), err
+}
@@ -0,0 +1,197 @@
+// Package processing provide all requirements to process change data capture
+package processing
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateAggregate(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		aggregate sqlAggregate
+		fail      bool
+	}{
+		{
+			aggregate: sqlAggregate{},
+		},
+		{
+			aggregate: sqlAggregate{
+				GroupBy: []string{"id"},
+				Collections: []sqlCollection{
+					{Name: "promo_codes", Columns: []string{"code"}},
+				},
+			},
+		},
+		{
+			aggregate: sqlAggregate{
+				GroupBy: []string{"id"},
+			},
+			fail: true,
+		},
+		{
+			aggregate: sqlAggregate{
+				Collections: []sqlCollection{
+					{Name: "promo_codes", Columns: []string{"code"}},
+				},
+			},
+			fail: true,
+		},
+		{
+			aggregate: sqlAggregate{
+				GroupBy: []string{"id"},
+				Collections: []sqlCollection{
+					{Name: "promo_codes", Columns: []string{"code"}},
+					{Name: "promo_codes", Columns: []string{"usage_count"}},
+				},
+			},
+			fail: true,
+		},
+		{
+			aggregate: sqlAggregate{
+				GroupBy: []string{"id"},
+				Collections: []sqlCollection{
+					{Name: "promo_codes", Columns: []string{"id"}},
+				},
+			},
+			fail: true,
+		},
+		{
+			aggregate: sqlAggregate{
+				GroupBy: []string{"id"},
+				Collections: []sqlCollection{
+					{Name: "promo_codes", Columns: []string{"code"}},
+					{Name: "rides", Columns: []string{"code"}},
+				},
+			},
+			fail: true,
+		},
+		{
+			aggregate: sqlAggregate{
+				GroupBy: []string{"id"},
+				Collections: []sqlCollection{
+					{Name: "rides", Columns: []string{"ride_id"}, Limit: 10},
+				},
+				OrderBy: []string{"rides.start_time DESC", "rides.id"},
+			},
+		},
+		{
+			aggregate: sqlAggregate{
+				OrderBy: []string{"rides.start_time DESC"},
+			},
+			fail: true,
+		},
+		{
+			aggregate: sqlAggregate{
+				GroupBy: []string{"id"},
+				Collections: []sqlCollection{
+					{Name: "rides", Columns: []string{"ride_id"}},
+				},
+				OrderBy: []string{"rides.start_time; DROP TABLE rides"},
+			},
+			fail: true,
+		},
+	}
+
+	for _, tc := range tests {
+		err := validateAggregate(tc.aggregate)
+		if tc.fail {
+			assert.Error(err)
+		} else {
+			assert.Nil(err)
+		}
+	}
+}
+
+func TestAggregateRows(t *testing.T) {
+	assert := assert.New(t)
+
+	aggregate := sqlAggregate{
+		GroupBy: []string{"id"},
+		Collections: []sqlCollection{
+			{Name: "promo_codes", Columns: []string{"promo_codes.code", "promo_codes.usage_count"}},
+			{Name: "rides", Columns: []string{"ride_id"}, Limit: 1},
+		},
+	}
+	rows := []map[string]interface{}{
+		{"id": "u1", "name": "alice", "promo_codes.code": "a", "promo_codes.usage_count": int64(1), "ride_id": "r1"},
+		{"id": "u1", "name": "alice", "promo_codes.code": "a", "promo_codes.usage_count": int64(1), "ride_id": "r2"},
+		{"id": "u1", "name": "alice", "promo_codes.code": "b", "promo_codes.usage_count": int64(2), "ride_id": "r1"},
+		{"id": "u2", "name": "bob", "promo_codes.code": "c", "promo_codes.usage_count": int64(3), "ride_id": "r3"},
+	}
+
+	z, err := aggregateRows(rows, aggregate)
+	assert.Nil(err)
+	assert.Equal(
+		map[string]interface{}{
+			"id":   "u1",
+			"name": "alice",
+			"promo_codes": []interface{}{
+				map[string]interface{}{"code": "a", "usage_count": int64(1)},
+				map[string]interface{}{"code": "b", "usage_count": int64(2)},
+			},
+			"rides": []interface{}{
+				map[string]interface{}{"ride_id": "r1"},
+			},
+		},
+		z,
+	)
+
+	z, err = aggregateRows(
+		[]map[string]interface{}{
+			{"id": "u3", "name": "carol", "promo_codes.code": nil, "promo_codes.usage_count": nil, "ride_id": nil},
+		},
+		aggregate,
+	)
+	assert.Nil(err)
+	assert.Equal([]interface{}{}, z["promo_codes"])
+	assert.Equal([]interface{}{}, z["rides"])
+
+	_, err = aggregateRows(rows, sqlAggregate{GroupBy: []string{"missing"}})
+	assert.Error(err)
+
+	// no document is returned without rows
+	z, err = aggregateRows(nil, aggregate)
+	assert.Nil(err)
+	assert.Nil(z)
+}
+
+func TestAdvancedQuery(t *testing.T) {
+	assert := assert.New(t)
+
+	filters := []string{`"users"."id" = $1`}
+	args := []interface{}{"u1"}
+	query := "SELECT users.id,rides.id AS ride_id FROM users LEFT JOIN rides ON rides.rider_id = users.id"
+	z, zargs := advancedQuery(query, filters, args, sqlAggregate{})
+	assert.Equal(query+` WHERE "users"."id" = $1 LIMIT 1`, z)
+	assert.Equal(args, zargs)
+
+	// the last rides are kept by the limit of the collection
+	// with one more row fetched to detect truncation
+	aggregate := sqlAggregate{
+		GroupBy:     []string{"id"},
+		Collections: []sqlCollection{{Name: "rides", Columns: []string{"ride_id"}, Limit: 10}},
+		OrderBy:     []string{"rides.start_time DESC"},
+	}
+	z, zargs = advancedQuery(query, filters, args, aggregate)
+	assert.Equal(query+` WHERE "users"."id" = $1 ORDER BY rides.start_time DESC LIMIT $2`, z)
+	assert.Equal([]interface{}{"u1", 11}, zargs)
+
+	aggregate.OrderBy = nil
+	z, zargs = advancedQuery(query+" WHERE rides.revenue > 0", []string{`"users"."id" = $1`, `"users"."city" IS NULL`}, args, aggregate)
+	assert.Equal(query+` WHERE rides.revenue > 0 AND "users"."id" = $1 AND "users"."city" IS NULL LIMIT $2`, z)
+	assert.Equal([]interface{}{"u1", 11}, zargs)
+
+	// rows of several collections are not limited
+	aggregate.Collections = append(aggregate.Collections, sqlCollection{Name: "promo_codes", Columns: []string{"code"}, Limit: 5})
+	z, zargs = advancedQuery(query, filters, args, aggregate)
+	assert.Equal(query+` WHERE "users"."id" = $1`, z)
+	assert.Equal(args, zargs)
+
+	// unlimited collection
+	aggregate.Collections = []sqlCollection{{Name: "rides", Columns: []string{"ride_id"}}}
+	z, _ = advancedQuery(query, filters, args, aggregate)
+	assert.Equal(query+` WHERE "users"."id" = $1`, z)
+}
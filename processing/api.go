@@ -10,6 +10,8 @@ import (
 	"strings"
 	"syscall"
 	"time"
+
+	"github.com/Lord-Y/synker/commons"
 )
 
 // RunAPI will start the api server
@@ -28,6 +30,9 @@ func (c *Validate) RunAPI() {
 		}
 	}
 
+	c.supervisor = newSupervisor()
+	c.changeFeeds = newChangeFeedMonitor()
+	defer c.closeClients()
 	router := c.setupRouter()
 	srv := &http.Server{
 		Addr:    appPort,
@@ -35,13 +40,24 @@ func (c *Validate) RunAPI() {
 	}
 	c.Logger.Info().Msgf("Starting api server on port %s", appPort)
 
+	errs := make(chan error, 2)
 	go func() {
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			c.Logger.Fatal().Err(err).Msg("Startup api server failed")
+			errs <- fmt.Errorf("Startup api server failed: %w", err)
 		}
 	}()
 
-	go c.runPrerequisitesAndStartProcessing()
+	// root context cancelled on shutdown so consumers can drain
+	// their in-flight messages
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := c.runPrerequisitesAndStartProcessing(ctx); err != nil {
+			errs <- err
+		}
+	}()
 
 	// Wait for interrupt signal to gracefully shutdown the server with
 	// a timeout of 5 minutes.
@@ -50,49 +66,75 @@ func (c *Validate) RunAPI() {
 	// kill -2 is syscall.SIGINT
 	// kill -9 is syscall. SIGKILL but can"t be catch, so don't need add it
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
-	c.Logger.Info().Msg("Shutting down api server")
+	var fatal error
+	select {
+	case <-quit:
+	case fatal = <-errs:
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
-	defer cancel()
-	if err := srv.Shutdown(ctx); err != nil {
+	c.Logger.Info().Msg("Stopping consumers")
+	cancel()
+	select {
+	case <-done:
+		c.Logger.Info().Msg("Consumers stopped successfully")
+	case <-time.After(commons.GetDrainTimeout()):
+		c.Logger.Warn().Msgf("Consumers did not stop within %s", commons.GetDrainTimeout())
+	}
+
+	c.Logger.Info().Msg("Shutting down api server")
+	sctx, scancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer scancel()
+	if err := srv.Shutdown(sctx); err != nil {
 		c.Logger.Fatal().Err(err).Msg("API server shutted down abruptly")
 	}
+	if fatal != nil {
+		c.Logger.Fatal().Err(fatal).Msg("API server stopped")
+	}
 	c.Logger.Info().Msg("API server exited successfully")
 }
 
 // RunPrerequisitesOnly permit to run all functions
 // related to Kafka, elasticsearch and cockroach feeds
 func (c *Validate) RunPrerequisitesOnly() {
+	defer c.closeClients()
+	if err := c.prerequisites(context.Background()); err != nil {
+		c.Logger.Fatal().Err(err).Msg("Fail to run prerequisites")
+	}
+}
+
+// prerequisites permit to create topics, elasticsearch indexes
+// and cockroach feeds
+func (c *Validate) prerequisites(ctx context.Context) (err error) {
 	os.Setenv("SYNKER_CONFIG_DIR", c.ConfigDir)
 	defer os.Unsetenv("SYNKER_CONFIG_DIR")
 
-	err := c.manageTopics()
+	err = c.manageTopics(ctx)
 	if err != nil {
-		c.Logger.Fatal().Err(err).Msg("Fail to manage topics")
-		return
+		return fmt.Errorf("Fail to manage topics: %w", err)
 	}
-	err = c.manageElasticsearchIndex()
+	err = c.manageElasticsearchIndex(ctx)
 	if err != nil {
-		c.Logger.Fatal().Err(err).Msg("Fail to manage elasticsearch indexes")
-		return
+		return fmt.Errorf("Fail to manage elasticsearch indexes: %w", err)
 	}
-	err = c.manageChangeFeed()
+	err = c.manageChangeFeed(ctx)
 	if err != nil {
-		c.Logger.Fatal().Err(err).Msg("Fail to manage changefeed")
-		return
+		return fmt.Errorf("Fail to manage changefeed: %w", err)
 	}
+	return
 }
 
 // runPrerequisitesAndStartProcessing permit to run all functions
 // related to Kafka, elasticsearch and cockroach feeds
-// and then start processing all kafka messages
-func (c *Validate) runPrerequisitesAndStartProcessing() {
+// and then start processing all kafka messages until the context is cancelled
+func (c *Validate) runPrerequisitesAndStartProcessing(ctx context.Context) (err error) {
 	os.Setenv("SYNKER_CONFIG_DIR", c.ConfigDir)
 	defer os.Unsetenv("SYNKER_CONFIG_DIR")
 
 	if c.Init {
-		c.RunPrerequisitesOnly()
+		if err = c.prerequisites(ctx); err != nil {
+			return
+		}
 	}
-	c.processing()
+	c.processing(ctx)
+	return
 }
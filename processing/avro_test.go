@@ -0,0 +1,218 @@
+// Package processing provide all requirements to process change data capture
+package processing
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/linkedin/goavro/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testAvroKeySchema = `{
+  "type": "record",
+  "name": "rides_key",
+  "fields": [
+    {"name": "city", "type": "string"},
+    {"name": "id", "type": {"type": "string", "logicalType": "uuid"}}
+  ]
+}`
+	testAvroValueSchema = `{
+  "type": "record",
+  "name": "rides_envelope",
+  "fields": [
+    {"name": "before", "type": ["null", {
+      "type": "record",
+      "name": "rides",
+      "namespace": "movr",
+      "fields": [
+        {"name": "id", "type": ["null", {"type": "string", "logicalType": "uuid"}]},
+        {"name": "city", "type": ["null", "string"]},
+        {"name": "revenue", "type": ["null", {"type": "bytes", "logicalType": "decimal", "precision": 10, "scale": 2}]},
+        {"name": "start_time", "type": ["null", {"type": "long", "logicalType": "timestamp-micros"}]},
+        {"name": "day", "type": ["null", {"type": "int", "logicalType": "date"}]},
+        {"name": "tags", "type": ["null", {"type": "array", "items": "string"}]}
+      ]
+    }]},
+    {"name": "after", "type": ["null", "movr.rides"]},
+    {"name": "updated", "type": ["null", "string"]}
+  ]
+}`
+)
+
+// testAvroMessage return the avro message framed with the schema id
+func testAvroMessage(t *testing.T, id uint32, schema string, native interface{}) []byte {
+	codec, err := goavro.NewCodec(schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z := make([]byte, avroHeaderSize)
+	binary.BigEndian.PutUint32(z[1:], id)
+	z, err = codec.BinaryFromNative(z, native)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return z
+}
+
+// testSchemaRegistry return a local schema registry stub
+func testSchemaRegistry(calls *int32) *httptest.Server {
+	schemas := map[string]string{
+		"/schemas/ids/1": testAvroKeySchema,
+		"/schemas/ids/2": testAvroValueSchema,
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(calls, 1)
+		schema, ok := schemas[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error_code":40403,"message":"Schema not found"}`)
+			return
+		}
+		data, _ := json.Marshal(map[string]string{"schema": schema})
+		w.Write(data) //nolint
+	}))
+}
+
+func TestDecodeAvro(t *testing.T) {
+	assert := assert.New(t)
+
+	var calls int32
+	server := testSchemaRegistry(&calls)
+	defer server.Close()
+
+	registry, err := newSchemaRegistry(server.URL)
+	assert.Nil(err)
+	decoder := decodeAvro(context.Background(), registry)
+
+	start := time.Date(2024, 5, 1, 10, 30, 0, 123456000, time.UTC)
+	row := map[string]interface{}{
+		"id":         goavro.Union("string", "ab020c49-ba5e-4800-8000-00000000014e"),
+		"city":       goavro.Union("string", "amsterdam"),
+		"revenue":    goavro.Union("bytes.decimal", big.NewRat(1234, 100)),
+		"start_time": goavro.Union("long.timestamp-micros", start),
+		"day":        goavro.Union("int.date", start),
+		"tags":       goavro.Union("array", []interface{}{"a", "b"}),
+	}
+	key := testAvroMessage(t, 1, testAvroKeySchema, map[string]interface{}{
+		"city": "amsterdam",
+		"id":   "ab020c49-ba5e-4800-8000-00000000014e",
+	})
+	value := testAvroMessage(t, 2, testAvroValueSchema, map[string]interface{}{
+		"before":  nil,
+		"after":   goavro.Union("movr.rides", row),
+		"updated": goavro.Union("string", "1532377312562986715.0000000001"),
+	})
+
+	z, skip, err := decoder(key, value)
+	assert.Nil(err)
+	assert.Equal(false, skip)
+	assert.Equal([]interface{}{"amsterdam", "ab020c49-ba5e-4800-8000-00000000014e"}, z.Key)
+	assert.Nil(z.Before)
+	assert.Equal("1532377312562986715.0000000001", z.Updated)
+	assert.Equal("ab020c49-ba5e-4800-8000-00000000014e", z.After["id"])
+	assert.Equal("amsterdam", z.After["city"])
+	assert.Equal("12.34", z.After["revenue"])
+	assert.Equal("2024-05-01T10:30:00.123456Z", z.After["start_time"])
+	assert.Equal("2024-05-01", z.After["day"])
+	assert.Equal([]interface{}{"a", "b"}, z.After["tags"])
+
+	// deletes reference the row type by its name
+	value = testAvroMessage(t, 2, testAvroValueSchema, map[string]interface{}{
+		"before":  goavro.Union("movr.rides", row),
+		"after":   nil,
+		"updated": nil,
+	})
+	z, _, err = decoder(key, value)
+	assert.Nil(err)
+	assert.Nil(z.After)
+	assert.Equal("12.34", z.Before["revenue"])
+
+	// schemas are fetched once
+	assert.Equal(int32(2), atomic.LoadInt32(&calls))
+
+	_, _, err = decoder([]byte(`["amsterdam"]`), value)
+	assert.Error(err)
+
+	unknown := testAvroMessage(t, 3, testAvroKeySchema, map[string]interface{}{"city": "amsterdam", "id": "x"})
+	_, _, err = decoder(unknown, value)
+	assert.Error(err)
+	assert.True(strings.Contains(err.Error(), "404"))
+
+	// fetches are cancelled with the context of the consumer
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err = decodeAvro(ctx, registry)(unknown, value)
+	assert.ErrorIs(err, context.Canceled)
+	assert.Equal(int32(3), atomic.LoadInt32(&calls))
+}
+
+func TestSchemaRegistry_slowFetch(t *testing.T) {
+	assert := assert.New(t)
+
+	var calls int32
+	stub := testSchemaRegistry(&calls)
+	defer stub.Close()
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/schemas/ids/3" {
+			<-release
+		}
+		stub.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	defer close(release)
+
+	registry, err := newSchemaRegistry(server.URL)
+	assert.Nil(err)
+	_, err = registry.schema(context.Background(), 1)
+	assert.Nil(err)
+
+	go registry.schema(context.Background(), 3) //nolint
+	time.Sleep(50 * time.Millisecond)
+
+	// cached schemas are returned while another schema is being fetched
+	done := make(chan error, 1)
+	go func() {
+		_, err := registry.schema(context.Background(), 1)
+		done <- err
+	}()
+	select {
+	case err = <-done:
+		assert.Nil(err)
+	case <-time.After(time.Second):
+		t.Fatal("cached schema blocked by a pending fetch")
+	}
+	assert.Equal(int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestChangeFeedOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	z, err := changeFeedOptions(changeFeed{Options: []string{"updated"}}, formatCockroach)
+	assert.Nil(err)
+	assert.Equal([]string{"updated", "diff"}, z)
+
+	t.Setenv("SYNKER_SCHEMA_REGISTRY_URI", "http://127.0.0.1:8081")
+	z, err = changeFeedOptions(changeFeed{Options: []string{"updated", "diff"}}, formatCockroachAvro)
+	assert.Nil(err)
+	assert.Equal([]string{"updated", "diff", "format = avro", "confluent_schema_registry = 'http://127.0.0.1:8081'"}, z)
+
+	z, err = changeFeedOptions(changeFeed{Options: []string{"format=avro", "confluent_schema_registry='http://registry:8081'"}}, formatCockroachAvro)
+	assert.Nil(err)
+	assert.Equal([]string{"format=avro", "confluent_schema_registry='http://registry:8081'", "diff"}, z)
+
+	t.Setenv("SYNKER_SCHEMA_REGISTRY_URI", "")
+	_, err = changeFeedOptions(changeFeed{}, formatCockroachAvro)
+	assert.Error(err)
+}
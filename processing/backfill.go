@@ -0,0 +1,370 @@
+// Package processing provide all requirements to process change data capture
+package processing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	kafkago "github.com/segmentio/kafka-go"
+)
+
+const (
+	// defaultBackfillPageSize is the number of rows read per query
+	defaultBackfillPageSize int = 1000
+)
+
+// BackfillOptions hold the requirements to backfill the index of a schema
+type BackfillOptions struct {
+	// Schema name to backfill
+	Schema string
+	// Checkpoint is the file holding the progress of the backfill.
+	// Default to synker-backfill-<schema>.json
+	Checkpoint string
+	// RowsPerSecond is the maximum number of rows read per second. 0 means unlimited
+	RowsPerSecond int
+	// PageSize is the number of rows read per query. Default to 1000
+	PageSize int
+	// Restart ignore the existing checkpoint
+	Restart bool
+	// index overrides the index written by the backfill
+	index string
+}
+
+// backfillCheckpoint is the progress of the backfill saved after each page
+type backfillCheckpoint struct {
+	// Schema name
+	Schema string `json:"schema"`
+	// Cursor hold the primary key values of the last row written in text format
+	Cursor []string `json:"cursor"`
+	// Rows is the number of rows read
+	Rows int `json:"rows"`
+	// Skipped is the number of rows already overwritten by newer changes
+	Skipped int `json:"skipped"`
+	// Done is true once all rows have been read
+	Done bool `json:"done"`
+	// UpdatedAt is the last time the checkpoint has been saved
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Backfill permit to index the rows already present in the table of the schema.
+// Rows are read by primary key order and the progress is saved in the checkpoint file
+// so the backfill can be resumed after an interruption
+func (c *Validate) Backfill(options BackfillOptions) {
+	defer c.closeClients()
+
+	index := -1
+	for k, v := range c.validatedSchemas.Schemas {
+		if v.Name == options.Schema {
+			index = k
+			break
+		}
+	}
+	if index == -1 {
+		c.Logger.Fatal().Err(fmt.Errorf("Schema %s not found", options.Schema)).Msgf("Fail to backfill schema %s", options.Schema)
+		return
+	}
+
+	// the backfill stops between two pages on interruption so it can be resumed
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	checkpoint, err := c.backfill(ctx, index, options)
+	if err != nil {
+		c.Logger.Fatal().Err(err).Msgf("Fail to backfill schema %s", options.Schema)
+		return
+	}
+	c.Logger.Info().Msgf("Backfill of schema %s completed with %d rows read and %d rows skipped as already overwritten by newer changes", options.Schema, checkpoint.Rows, checkpoint.Skipped)
+}
+
+// backfillCheckpointFile return the checkpoint file of the backfill
+func backfillCheckpointFile(options BackfillOptions) string {
+	if strings.TrimSpace(options.Checkpoint) != "" {
+		return strings.TrimSpace(options.Checkpoint)
+	}
+	return fmt.Sprintf("synker-backfill-%s.json", strings.TrimSpace(options.Schema))
+}
+
+// loadBackfillCheckpoint return the checkpoint saved in the file.
+// An empty checkpoint is returned when the file does not exist
+func loadBackfillCheckpoint(file, schema string) (z backfillCheckpoint, err error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return backfillCheckpoint{Schema: schema}, nil
+		}
+		return
+	}
+	if err = json.Unmarshal(data, &z); err != nil {
+		return z, fmt.Errorf("Fail to decode checkpoint file %s: %w", file, err)
+	}
+	if z.Schema != schema {
+		return z, fmt.Errorf("Checkpoint file %s belongs to schema %s", file, z.Schema)
+	}
+	return
+}
+
+// saveBackfillCheckpoint permit to save the checkpoint into the file.
+// The file is replaced atomically so an interruption never corrupts it
+func saveBackfillCheckpoint(file string, checkpoint backfillCheckpoint) (err error) {
+	checkpoint.UpdatedAt = time.Now().UTC()
+	data, err := json.MarshalIndent(checkpoint, "", "  ")
+	if err != nil {
+		return
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".*")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return
+	}
+	if err = tmp.Close(); err != nil {
+		return
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// backfillQuery return the query reading the next page of rows by primary key order.
+// With a cdc query, its projection and predicate are applied and the primary key columns
+// are read under their backfillKeyColumn alias
+func backfillQuery(fullTableName string, primaryKey []string, selection changeFeedSelect, resume bool, limit int) string {
+	columns := make([]string, 0, len(primaryKey))
+	placeholders := make([]string, 0, len(primaryKey))
+	for k, column := range primaryKey {
+		columns = append(columns, pgx.Identifier{column}.Sanitize())
+		placeholders = append(placeholders, fmt.Sprintf("$%d", k+1))
+	}
+
+	// projected columns may be named like primary key columns so rows are ordered by the aliases
+	projection, order := "*", columns
+	if len(selection.Columns) > 0 {
+		projection, order = strings.Join(selection.columns(), ", "), nil
+		for k, column := range columns {
+			alias := pgx.Identifier{backfillKeyColumn(primaryKey, selection, k)}.Sanitize()
+			projection += fmt.Sprintf(", %s AS %s", column, alias)
+			order = append(order, alias)
+		}
+	}
+
+	var filters []string
+	if where := strings.TrimSpace(selection.Where); where != "" {
+		filters = append(filters, fmt.Sprintf("(%s)", where))
+	}
+	if resume {
+		filters = append(filters, fmt.Sprintf("(%s) > (%s)", strings.Join(columns, ", "), strings.Join(placeholders, ", ")))
+	}
+
+	q := fmt.Sprintf("SELECT %s FROM %s", projection, tableIdentifier(fullTableName))
+	if len(filters) > 0 {
+		q += " WHERE " + strings.Join(filters, " AND ")
+	}
+	return q + fmt.Sprintf(" ORDER BY %s LIMIT %d", strings.Join(order, ", "), limit)
+}
+
+// backfillKeyColumn return the name under which the primary key column is read.
+// Projected rows may not hold the primary key so it is read under an alias
+func backfillKeyColumn(primaryKey []string, selection changeFeedSelect, index int) string {
+	if len(selection.Columns) == 0 {
+		return primaryKey[index]
+	}
+	return fmt.Sprintf("__synker_key_%d", index)
+}
+
+// backfillDelay return the time to wait so no more than rowsPerSecond rows
+// are read since the start of the backfill
+func backfillDelay(started time.Time, rows, rowsPerSecond int) time.Duration {
+	if rowsPerSecond <= 0 {
+		return 0
+	}
+	delay := time.Until(started.Add(time.Duration(rows) * time.Second / time.Duration(rowsPerSecond)))
+	if delay < 0 {
+		return 0
+	}
+	return delay
+}
+
+// backfillPage return the rows of the next page with the hybrid logical clock
+// timestamp at which they have been read
+func (c *Validate) backfillPage(ctx context.Context, changefeed changeFeed, primaryKey []string, cursor []string, limit int) (rows []map[string]interface{}, keys [][]interface{}, next []string, timestamp string, err error) {
+	db, err := c.pgPool(ctx)
+	if err != nil {
+		return
+	}
+	tx, err := db.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return
+	}
+	//golangci-lint fail on this check while the transaction error is checked
+	defer tx.Rollback(ctx) //nolint
+
+	// changes committed after this timestamp have a greater external version
+	if err = tx.QueryRow(ctx, "SELECT cluster_logical_timestamp()::STRING").Scan(&timestamp); err != nil {
+		return
+	}
+
+	var args []interface{}
+	for _, v := range cursor {
+		args = append(args, v)
+	}
+	q := backfillQuery(changefeed.FullTableName, primaryKey, changefeed.Select, len(cursor) > 0, limit)
+	c.Logger.Debug().Msgf("Executing SQL query `%s` with args %v", q, args)
+	result, err := tx.Query(ctx, q, args...)
+	if err != nil {
+		return
+	}
+	defer result.Close()
+
+	fields := result.FieldDescriptions()
+	typeMap := tx.Conn().TypeMap()
+	for result.Next() {
+		values, err := result.Values()
+		if err != nil {
+			return nil, nil, nil, "", err
+		}
+		row := make(map[string]interface{}, len(values))
+		raw := make(map[string]interface{}, len(values))
+		oids := make(map[string]uint32, len(values))
+		for k, v := range values {
+			name := fields[k].Name
+			raw[name], oids[name] = v, fields[k].DataTypeOID
+			if row[name], err = queryValue(v); err != nil {
+				return nil, nil, nil, "", err
+			}
+		}
+
+		var key []interface{}
+		next = nil
+		for k, column := range primaryKey {
+			name := backfillKeyColumn(primaryKey, changefeed.Select, k)
+			key = append(key, row[name])
+			// the cursor is kept in text format so it can be saved and sent back as is
+			text, err := typeMap.Encode(oids[name], pgtype.TextFormatCode, raw[name], nil)
+			if err != nil {
+				return nil, nil, nil, "", fmt.Errorf("Fail to encode primary key column %s: %w", column, err)
+			}
+			next = append(next, string(text))
+			if name != column {
+				delete(row, name)
+			}
+		}
+		rows = append(rows, row)
+		keys = append(keys, key)
+	}
+	if err = result.Err(); err != nil {
+		return
+	}
+	err = tx.Commit(ctx)
+	return
+}
+
+// backfill permit to read all rows of the table of the schema page by page
+// and index them with the hybrid logical clock timestamp of the page as external version
+// so rows already overwritten by newer changes are skipped
+func (c *Validate) backfill(ctx context.Context, index int, options BackfillOptions) (checkpoint backfillCheckpoint, err error) {
+	s := c.validatedSchemas.Schemas[index]
+	if !cockroachFormat(s.Format) {
+		return checkpoint, fmt.Errorf("Backfill is only supported with cockroach formats")
+	}
+	if c.documentIdStrategy(index) == documentIdUUID {
+		return checkpoint, fmt.Errorf("Backfill requires a documentId strategy other than uuid so rows overwritten by newer changes are skipped")
+	}
+
+	pageSize := options.PageSize
+	if pageSize <= 0 {
+		pageSize = defaultBackfillPageSize
+	}
+	if options.RowsPerSecond > 0 && options.RowsPerSecond < pageSize {
+		pageSize = options.RowsPerSecond
+	}
+
+	file := backfillCheckpointFile(options)
+	checkpoint = backfillCheckpoint{Schema: s.Name}
+	if !options.Restart {
+		if checkpoint, err = loadBackfillCheckpoint(file, s.Name); err != nil {
+			return
+		}
+		if checkpoint.Done {
+			c.Logger.Info().Msgf("Backfill of schema %s already completed according to checkpoint file %s", s.Name, file)
+			return
+		}
+		if len(checkpoint.Cursor) > 0 {
+			c.Logger.Info().Msgf("Resuming backfill of schema %s after %d rows", s.Name, checkpoint.Rows)
+		}
+	}
+
+	primaryKey, err := c.primaryKey(ctx, s.ChangeFeed.FullTableName)
+	if err != nil {
+		return
+	}
+	if len(checkpoint.Cursor) > 0 && len(checkpoint.Cursor) != len(primaryKey) {
+		return checkpoint, fmt.Errorf("Checkpoint file %s does not match the primary key of table %s", file, s.ChangeFeed.FullTableName)
+	}
+
+	topic := s.Topic.Name
+	m := kafkago.Message{Topic: topic}
+	batch := newBulkBatch(false)
+	batch.target = options.index
+	started := time.Now()
+	read := 0
+	for {
+		rows, keys, next, timestamp, err := c.backfillPage(ctx, s.ChangeFeed, primaryKey, checkpoint.Cursor, pageSize)
+		if err != nil {
+			return checkpoint, fmt.Errorf("Fail to read rows of table %s: %w", s.ChangeFeed.FullTableName, err)
+		}
+
+		for k, row := range rows {
+			event := changeEvent{Key: keys[k], After: row, Updated: timestamp}
+			if err = c.batchMessage(ctx, index, batch, m, event); err != nil {
+				return checkpoint, fmt.Errorf("Fail to index row with primary key %v: %w", keys[k], err)
+			}
+		}
+
+		if len(batch.requests) > 0 {
+			var rejected []error
+			stale, err := c.sendBatch(ctx, batch, topic, func(k int, reason error) error {
+				rejected = append(rejected, reason)
+				return nil
+			})
+			batch.reset()
+			if err != nil {
+				return checkpoint, err
+			}
+			if len(rejected) > 0 {
+				return checkpoint, fmt.Errorf("%d documents rejected, first error: %w", len(rejected), rejected[0])
+			}
+			checkpoint.Skipped += stale
+		}
+
+		checkpoint.Rows += len(rows)
+		if len(rows) > 0 {
+			checkpoint.Cursor = next
+		}
+		checkpoint.Done = len(rows) < pageSize
+		if err = saveBackfillCheckpoint(file, checkpoint); err != nil {
+			return checkpoint, fmt.Errorf("Fail to save checkpoint file %s: %w", file, err)
+		}
+		c.Logger.Info().Msgf("Backfill of schema %s: %d rows read, %d rows skipped", s.Name, checkpoint.Rows, checkpoint.Skipped)
+		if checkpoint.Done {
+			return checkpoint, nil
+		}
+
+		read += len(rows)
+		select {
+		case <-ctx.Done():
+			return checkpoint, ctx.Err()
+		case <-time.After(backfillDelay(started, read, options.RowsPerSecond)):
+		}
+	}
+}
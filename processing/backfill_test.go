@@ -0,0 +1,105 @@
+// Package processing provide all requirements to process change data capture
+package processing
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Lord-Y/synker/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackfillQuery(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(
+		`SELECT * FROM "movr"."public"."rides" ORDER BY "city", "id" LIMIT 100`,
+		backfillQuery("movr.public.rides", []string{"city", "id"}, changeFeedSelect{}, false, 100),
+	)
+	assert.Equal(
+		`SELECT * FROM "movr"."public"."rides" WHERE ("city", "id") > ($1, $2) ORDER BY "city", "id" LIMIT 100`,
+		backfillQuery("movr.public.rides", []string{"city", "id"}, changeFeedSelect{}, true, 100),
+	)
+
+	selection := changeFeedSelect{Columns: []string{"id", "lower(vehicle_city) AS vehicle_city"}, Where: "revenue > 10"}
+	assert.Equal(
+		`SELECT * FROM "movr"."public"."rides" WHERE (revenue > 10) ORDER BY "city", "id" LIMIT 100`,
+		backfillQuery("movr.public.rides", []string{"city", "id"}, changeFeedSelect{Where: "revenue > 10"}, false, 100),
+	)
+	assert.Equal(
+		`SELECT id, lower(vehicle_city) AS vehicle_city, "city" AS "__synker_key_0", "id" AS "__synker_key_1" FROM "movr"."public"."rides" WHERE (revenue > 10) AND ("city", "id") > ($1, $2) ORDER BY "__synker_key_0", "__synker_key_1" LIMIT 100`,
+		backfillQuery("movr.public.rides", []string{"city", "id"}, selection, true, 100),
+	)
+}
+
+func TestBackfillDelay(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(time.Duration(0), backfillDelay(time.Now(), 1000, 0))
+	assert.Equal(time.Duration(0), backfillDelay(time.Now().Add(-time.Minute), 1000, 100))
+
+	delay := backfillDelay(time.Now(), 1000, 100)
+	assert.Greater(delay, 9*time.Second)
+	assert.LessOrEqual(delay, 10*time.Second)
+}
+
+func TestBackfillCheckpoint(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("synker-backfill-rides.json", backfillCheckpointFile(BackfillOptions{Schema: "rides"}))
+	assert.Equal("/tmp/rides.json", backfillCheckpointFile(BackfillOptions{Schema: "rides", Checkpoint: "/tmp/rides.json"}))
+
+	file := filepath.Join(t.TempDir(), "rides.json")
+	z, err := loadBackfillCheckpoint(file, "rides")
+	assert.Nil(err)
+	assert.Equal(backfillCheckpoint{Schema: "rides"}, z)
+
+	checkpoint := backfillCheckpoint{
+		Schema:  "rides",
+		Cursor:  []string{"amsterdam", "ab020c49-ba5e-4800-8000-00000000014e"},
+		Rows:    1000,
+		Skipped: 3,
+	}
+	assert.Nil(saveBackfillCheckpoint(file, checkpoint))
+
+	z, err = loadBackfillCheckpoint(file, "rides")
+	assert.Nil(err)
+	assert.Equal(checkpoint.Cursor, z.Cursor)
+	assert.Equal(checkpoint.Rows, z.Rows)
+	assert.Equal(checkpoint.Skipped, z.Skipped)
+	assert.Equal(false, z.UpdatedAt.IsZero())
+
+	_, err = loadBackfillCheckpoint(file, "users")
+	assert.Error(err)
+
+	assert.Nil(os.WriteFile(file, []byte("fake"), 0600))
+	_, err = loadBackfillCheckpoint(file, "rides")
+	assert.Error(err)
+}
+
+func TestBackfill_unsupported(t *testing.T) {
+	assert := assert.New(t)
+
+	var c Validate
+	c.Logger = logger.NewLogger()
+	c.validatedSchemas.Schemas = []configSchema{
+		{
+			Name: "rides",
+		},
+		{
+			Name:   "customers",
+			Format: formatDebeziumJSON,
+			Elasticsearch: elasticsearchSchema{
+				DocumentId: documentIdSchema{Strategy: documentIdPrimaryKey},
+			},
+		},
+	}
+
+	_, err := c.backfill(context.Background(), 0, BackfillOptions{Schema: "rides"})
+	assert.Error(err)
+	_, err = c.backfill(context.Background(), 1, BackfillOptions{Schema: "customers"})
+	assert.Error(err)
+}
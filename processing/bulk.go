@@ -0,0 +1,299 @@
+// Package processing provide all requirements to process change data capture
+package processing
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nqd/flat"
+	kafkago "github.com/segmentio/kafka-go"
+)
+
+const (
+	// defaultBulkActions is the maximum number of requests in a batch
+	defaultBulkActions int = 1000
+	// defaultBulkSize is the maximum size in bytes of a batch
+	defaultBulkSize int = 5 << 20
+	// defaultBulkFlushInterval is the maximum time to wait before sending a batch
+	defaultBulkFlushInterval time.Duration = time.Second
+)
+
+// bulkSettings hold the batch limits of a schema
+type bulkSettings struct {
+	actions       int
+	size          int
+	flushInterval time.Duration
+}
+
+// bulkBatch hold sink requests waiting to be sent
+// and the kafka messages that produced them
+type bulkBatch struct {
+	requests []sinkRequest
+	// size is the estimated size in bytes of requests
+	size int
+	// refresh wait for documents to be searchable once sent
+	refresh  bool
+	messages []kafkago.Message
+	// origins hold the kafka message of each request of the batch
+	origins []kafkago.Message
+	pending []pendingDocument
+	started time.Time
+	// target overrides the index of every request.
+	// It is used to write into the index being rebuilt by a reindex
+	target string
+}
+
+// pendingDocument is a document added to the batch
+// but not yet indexed into elasticsearch
+type pendingDocument struct {
+	id      string
+	content map[string]interface{}
+}
+
+// newBulkSettings return the batch limits of the schema
+// with default values when not provided
+func newBulkSettings(b bulkSchema) (z bulkSettings, err error) {
+	z = bulkSettings{
+		actions:       defaultBulkActions,
+		size:          defaultBulkSize,
+		flushInterval: defaultBulkFlushInterval,
+	}
+	if b.Actions > 0 {
+		z.actions = b.Actions
+	}
+	if b.Size > 0 {
+		z.size = b.Size
+	}
+	if strings.TrimSpace(b.FlushInterval) != "" {
+		z.flushInterval, err = time.ParseDuration(strings.TrimSpace(b.FlushInterval))
+		if err != nil {
+			return z, fmt.Errorf("Bulk flushInterval `%s` is not a valid duration", b.FlushInterval)
+		}
+		if z.flushInterval <= 0 {
+			return z, fmt.Errorf("Bulk flushInterval `%s` must be greater than 0", b.FlushInterval)
+		}
+	}
+	return
+}
+
+// newBulkBatch return an empty batch.
+// When documents must be searched before being updated,
+// the batch waits for the refresh of the index
+func newBulkBatch(refresh bool) *bulkBatch {
+	return &bulkBatch{
+		refresh: refresh,
+	}
+}
+
+// addRequest permit to add a request to the batch
+func (b *bulkBatch) addRequest(r sinkRequest) {
+	if b.target != "" {
+		r.Index = b.target
+	}
+	b.requests = append(b.requests, r)
+	b.size += r.size()
+}
+
+// scratch return an empty batch sharing the settings and the pending documents of the batch
+// so the requests of one kafka message can be built before being merged
+func (b *bulkBatch) scratch() *bulkBatch {
+	return &bulkBatch{
+		refresh: b.refresh,
+		target:  b.target,
+		pending: append([]pendingDocument(nil), b.pending...),
+	}
+}
+
+// merge permit to add the requests and the pending documents of the scratch batch
+func (b *bulkBatch) merge(scratch *bulkBatch) {
+	b.requests = append(b.requests, scratch.requests...)
+	b.size += scratch.size
+	b.pending = scratch.pending
+}
+
+// add permit to add kafka message to the batch
+// with the requests it produced
+func (b *bulkBatch) add(m kafkago.Message) {
+	if len(b.messages) == 0 {
+		b.started = time.Now()
+	}
+	b.messages = append(b.messages, m)
+	for len(b.origins) < len(b.requests) {
+		b.origins = append(b.origins, m)
+	}
+}
+
+// full return true when the batch reached one of its limits
+func (b *bulkBatch) full(settings bulkSettings) bool {
+	return len(b.requests) >= settings.actions ||
+		b.size >= settings.size
+}
+
+// fetchContext return the context to use when fetching the next kafka message
+// so the batch is flushed once the flush interval is reached
+func (b *bulkBatch) fetchContext(ctx context.Context, settings bulkSettings) (context.Context, context.CancelFunc) {
+	if len(b.messages) == 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithDeadline(ctx, b.started.Add(settings.flushInterval))
+}
+
+// reset permit to empty the batch
+func (b *bulkBatch) reset() {
+	b.requests = nil
+	b.size = 0
+	b.messages = nil
+	b.origins = nil
+	b.pending = nil
+}
+
+// upsert permit to keep track of the document indexed or updated in the batch
+func (b *bulkBatch) upsert(id string, content map[string]interface{}) {
+	b.remove(id)
+	b.pending = append(b.pending, pendingDocument{id: id, content: content})
+}
+
+// remove permit to forget the document deleted in the batch
+func (b *bulkBatch) remove(id string) {
+	for k := len(b.pending) - 1; k >= 0; k-- {
+		if b.pending[k].id == id {
+			b.pending = append(b.pending[:k], b.pending[k+1:]...)
+		}
+	}
+}
+
+// find permit to search the document matching all provided filters
+// among documents not yet indexed into elasticsearch
+func (b *bulkBatch) find(filters map[string]interface{}) (found bool, id string) {
+	if len(filters) == 0 {
+		return
+	}
+	for k := len(b.pending) - 1; k >= 0; k-- {
+		flatten, err := flat.Flatten(b.pending[k].content, nil)
+		if err != nil {
+			continue
+		}
+		match := true
+		for fk, fv := range filters {
+			v, ok := flatten[fk]
+			if !ok || fmt.Sprint(v) != fmt.Sprint(fv) {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true, b.pending[k].id
+		}
+	}
+	return
+}
+
+// sendBatch permit to send all requests of the batch to their sinks.
+// Stale writes are counted and ignored while rejected is called
+// with the position in the batch of every other failed request
+func (c *Validate) sendBatch(ctx context.Context, batch *bulkBatch, topic string, rejected func(k int, reason error) error) (stale int, err error) {
+	all, origins, err := c.dualWriteRequests(ctx, batch)
+	if err != nil {
+		c.increaseMetrics("elasticsearch", topic, "client")
+		return
+	}
+
+	// requests of dependent schemas may target another kind of sink
+	var kinds []string
+	groups := make(map[string][]int)
+	for k, request := range all {
+		kind := c.sinkKind(request.schema)
+		if _, ok := groups[kind]; !ok {
+			kinds = append(kinds, kind)
+		}
+		groups[kind] = append(groups[kind], k)
+	}
+
+	for _, kind := range kinds {
+		positions := groups[kind]
+		sink, err := c.sink(all[positions[0]].schema)
+		if err != nil {
+			c.increaseMetrics("elasticsearch", topic, "client")
+			return stale, err
+		}
+		requests := make([]sinkRequest, 0, len(positions))
+		for _, k := range positions {
+			requests = append(requests, all[k])
+		}
+		results, err := sink.Bulk(ctx, requests, batch.refresh)
+		if err != nil {
+			c.increaseMetrics("elasticsearch", topic, "bulk")
+			return stale, err
+		}
+
+		for k, result := range results {
+			if !result.failed() || k >= len(positions) {
+				continue
+			}
+			// documents missing from the index being rebuilt are written by the backfill
+			if origins[positions[k]] == -1 {
+				if result.ErrorType != versionConflict {
+					c.increaseMetrics("elasticsearch", topic, "dual_write")
+					c.Logger.Debug().Msgf("Dual write %s of %s document with id %s in index %s ignored: %s %s", result.Action, kind, result.Id, result.Index, result.ErrorType, result.ErrorReason)
+				}
+				continue
+			}
+			// a newer version of the document has already been written
+			if result.ErrorType == versionConflict {
+				stale++
+				c.increaseMetrics("stale", topic, result.Action)
+				c.Logger.Debug().Msgf("Stale %s of %s document with id %s in index %s ignored", result.Action, kind, result.Id, result.Index)
+				continue
+			}
+			c.increaseMetrics("elasticsearch", topic, "bulk")
+			reason := fmt.Errorf("Fail to %s %s document with id %s in index %s: %s %s", result.Action, kind, result.Id, result.Index, result.ErrorType, result.ErrorReason)
+			if err := rejected(origins[positions[k]], reason); err != nil {
+				return stale, err
+			}
+		}
+	}
+	return
+}
+
+// flushBatch permit to send all requests of the batch to their sinks
+// and commit kafka messages once every request has been acknowledged.
+// Messages of failed requests are sent into the dead letter topic
+func (c *Validate) flushBatch(ctx context.Context, r *kafkago.Reader, w *kafkago.Writer, index int, batch *bulkBatch, topic string) (err error) {
+	if len(batch.messages) == 0 {
+		return
+	}
+	defer batch.reset()
+
+	actions := len(batch.requests)
+	if actions > 0 {
+		sent := make(map[string]bool)
+		_, err = c.sendBatch(ctx, batch, topic, func(k int, reason error) error {
+			if k >= len(batch.origins) {
+				return nil
+			}
+			m := batch.origins[k]
+			key := fmt.Sprintf("%d/%d", m.Partition, m.Offset)
+			if sent[key] {
+				return nil
+			}
+			sent[key] = true
+			return c.deadLetter(ctx, w, index, m, reason)
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	last := batch.messages[len(batch.messages)-1]
+	if err = r.CommitMessages(ctx, batch.messages...); err != nil {
+		c.increaseMetrics("kafka", topic, "commit")
+		return err
+	}
+	if c.supervisor != nil {
+		c.supervisor.succeeded(c.validatedSchemas.Schemas[index].Name)
+	}
+	c.Logger.Debug().Msgf("%d sink requests sent and %d kafka messages commited in topic %s up to partition %d and offset %d", actions, len(batch.messages), topic, last.Partition, last.Offset)
+	return
+}
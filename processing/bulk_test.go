@@ -0,0 +1,124 @@
+// Package processing provide all requirements to process change data capture
+package processing
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Lord-Y/synker/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewBulkSettings(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		bulk     bulkSchema
+		expected bulkSettings
+		fail     bool
+	}{
+		{
+			bulk: bulkSchema{},
+			expected: bulkSettings{
+				actions:       defaultBulkActions,
+				size:          defaultBulkSize,
+				flushInterval: defaultBulkFlushInterval,
+			},
+		},
+		{
+			bulk: bulkSchema{
+				Actions:       500,
+				Size:          1024,
+				FlushInterval: "250ms",
+			},
+			expected: bulkSettings{
+				actions:       500,
+				size:          1024,
+				flushInterval: 250 * time.Millisecond,
+			},
+		},
+		{
+			bulk: bulkSchema{
+				FlushInterval: "fake",
+			},
+			fail: true,
+		},
+		{
+			bulk: bulkSchema{
+				FlushInterval: "-1s",
+			},
+			fail: true,
+		},
+	}
+
+	for _, tc := range tests {
+		z, err := newBulkSettings(tc.bulk)
+		if tc.fail {
+			assert.Error(err)
+		} else {
+			assert.Nil(err)
+			assert.Equal(tc.expected, z)
+		}
+	}
+}
+
+func TestBulkBatch_find(t *testing.T) {
+	assert := assert.New(t)
+
+	batch := newBulkBatch(true)
+	batch.upsert("a", map[string]interface{}{"code": "x", "rules": map[string]interface{}{"type": "percent"}})
+	batch.upsert("b", map[string]interface{}{"code": "y"})
+
+	found, id := batch.find(map[string]interface{}{"code": "x", "rules.type": "percent"})
+	assert.Equal(true, found)
+	assert.Equal("a", id)
+
+	found, _ = batch.find(map[string]interface{}{"code": "z"})
+	assert.Equal(false, found)
+
+	found, _ = batch.find(map[string]interface{}{})
+	assert.Equal(false, found)
+
+	batch.remove("a")
+	found, _ = batch.find(map[string]interface{}{"code": "x"})
+	assert.Equal(false, found)
+
+	batch.reset()
+	assert.Equal(0, len(batch.pending))
+}
+
+func TestSendBatch(t *testing.T) {
+	assert := assert.New(t)
+
+	var c Validate
+	c.Logger = logger.NewLogger()
+	c.validatedSchemas.Schemas = []configSchema{{Name: "users"}}
+	memory := newMemorySink()
+	c.clients.sinks = map[string]sink{sinkElasticsearch: memory}
+
+	batch := newBulkBatch(false)
+	c.indexDocument(batch, 0, "users", map[string]interface{}{"name": "a"}, "1", 10)
+	c.indexDocument(batch, 0, "users", map[string]interface{}{"name": "b"}, "2", 10)
+	stale, err := c.sendBatch(context.Background(), batch, "users", func(k int, reason error) error {
+		return reason
+	})
+	assert.Nil(err)
+	assert.Equal(0, stale)
+	batch.reset()
+
+	// older versions are ignored and other failures are rejected
+	c.indexDocument(batch, 0, "users", map[string]interface{}{"name": "c"}, "1", 5)
+	c.indexNewContent(batch, 0, map[string]interface{}{"name": "d"}, "3")
+	var rejected []int
+	stale, err = c.sendBatch(context.Background(), batch, "users", func(k int, reason error) error {
+		rejected = append(rejected, k)
+		return nil
+	})
+	assert.Nil(err)
+	assert.Equal(1, stale)
+	assert.Equal([]int{1}, rejected)
+
+	document, _ := memory.Document("users", "1")
+	assert.Equal("a", document["name"])
+}
@@ -0,0 +1,359 @@
+// Package processing provide all requirements to process change data capture
+package processing
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Lord-Y/synker/commons"
+)
+
+const (
+	// changeFeedResumeNever never resume changefeed jobs
+	changeFeedResumeNever string = "never"
+	// changeFeedResumePaused resume paused changefeed jobs
+	changeFeedResumePaused string = "paused"
+	// changeFeedResumeFailed resume paused changefeed jobs and recreate failed ones
+	changeFeedResumeFailed string = "failed"
+	// changeFeedMissing is the status of a schema without changefeed job
+	changeFeedMissing string = "missing"
+	// changeFeedActionCreate create the changefeed of a table without job
+	changeFeedActionCreate string = "create"
+	// changeFeedActionResume resume the paused job
+	changeFeedActionResume string = "resume"
+	// changeFeedActionRecreate create a new changefeed from the high-water timestamp of the failed job
+	changeFeedActionRecreate string = "recreate"
+	// changeFeedActionGiveUp stop resuming the job as it failed too many times
+	changeFeedActionGiveUp string = "give_up"
+)
+
+// changeFeedStatuses are the statuses of changefeed jobs exported as metrics
+var changeFeedStatuses = []string{"running", "paused", "failed", changeFeedMissing}
+
+// changeFeedState hold the monitoring state of the changefeed of a schema
+type changeFeedState struct {
+	// Schema name
+	Schema string `json:"schema"`
+	// Table of the changefeed
+	Table string `json:"table"`
+	// JobId is the id of the changefeed job tracked
+	JobId int64 `json:"jobId,omitempty"`
+	// Status of the job tracked
+	Status string `json:"status"`
+	// HighWater is the hybrid logical clock timestamp up to which changes have been emitted
+	HighWater string `json:"highWater,omitempty"`
+	// Duplicates are the ids of the other running or paused jobs of the table
+	Duplicates []int64 `json:"duplicates,omitempty"`
+	// Attempts is the number of resumes since the job was last running
+	Attempts int `json:"attempts"`
+	// since is the time the job got its current status or was last resumed
+	since time.Time
+	// gaveUp is true once the job failed after all attempts
+	gaveUp bool
+}
+
+// changeFeedMonitor keep track of the changefeed jobs of all schemas
+type changeFeedMonitor struct {
+	mu          sync.RWMutex
+	changefeeds map[string]*changeFeedState
+	policy      string
+	after       time.Duration
+	maxAttempts int
+}
+
+// newChangeFeedMonitor return a changefeed monitor configured from OS env variables
+func newChangeFeedMonitor() *changeFeedMonitor {
+	return &changeFeedMonitor{
+		changefeeds: make(map[string]*changeFeedState),
+		policy:      commons.GetChangeFeedResumePolicy(),
+		after:       commons.GetChangeFeedResumeAfter(),
+		maxAttempts: commons.GetChangeFeedResumeMaxAttempts(),
+	}
+}
+
+// track permit to record the job of the changefeed of the schema
+func (m *changeFeedMonitor) track(schema, table string, job changeFeedJob) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	state, ok := m.changefeeds[schema]
+	if !ok {
+		state = &changeFeedState{Schema: schema, Table: table}
+		m.changefeeds[schema] = state
+	}
+	if state.JobId != job.Id || state.Status != job.Status {
+		state.since = time.Now()
+	}
+	state.JobId = job.Id
+	state.Status = job.Status
+}
+
+// observe permit to update the state of the changefeed of the schema
+// from the jobs of its table and return the action to take.
+// The tracked job is kept unless it failed while another job is running or paused
+func (m *changeFeedMonitor) observe(schema, table string, jobs []changeFeedJob, now time.Time) (z changeFeedState, action string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	state, ok := m.changefeeds[schema]
+	if !ok {
+		state = &changeFeedState{Schema: schema, Table: table, since: now}
+		m.changefeeds[schema] = state
+	}
+
+	var job *changeFeedJob
+	active := activeChangeFeedJobs(jobs)
+	for k := range jobs {
+		if jobs[k].Id == state.JobId {
+			job = &jobs[k]
+		}
+	}
+	if job == nil || (job.Status == "failed" && len(active) > 0) {
+		job = adoptChangeFeedJob(jobs, active)
+	}
+
+	if job == nil {
+		if state.Status != changeFeedMissing {
+			state.since = now
+		}
+		state.JobId, state.Status, state.HighWater, state.Duplicates = 0, changeFeedMissing, "", nil
+		return state.copy(), ""
+	}
+
+	if job.Id != state.JobId {
+		state.Attempts, state.gaveUp = 0, false
+	}
+	if job.Id != state.JobId || job.Status != state.Status {
+		state.since = now
+	}
+	state.JobId, state.Status, state.HighWater = job.Id, job.Status, job.HighWater
+	state.Duplicates = nil
+	for _, v := range active {
+		if v.Id != job.Id {
+			state.Duplicates = append(state.Duplicates, v.Id)
+		}
+	}
+
+	if now.Sub(state.since) < m.after {
+		return state.copy(), ""
+	}
+	switch {
+	case job.Status == "running":
+		state.Attempts, state.gaveUp = 0, false
+	case job.Status == "paused" && m.policy != changeFeedResumeNever:
+		action = m.attempt(state, changeFeedActionResume, now)
+	case job.Status == "failed" && m.policy == changeFeedResumeFailed:
+		action = m.attempt(state, changeFeedActionRecreate, now)
+	}
+	return state.copy(), action
+}
+
+// attempt return the action when attempts are left, give_up once all attempts failed
+// and nothing afterwards
+func (m *changeFeedMonitor) attempt(state *changeFeedState, action string, now time.Time) string {
+	if state.gaveUp {
+		return ""
+	}
+	if state.Attempts >= m.maxAttempts {
+		state.gaveUp = true
+		return changeFeedActionGiveUp
+	}
+	state.Attempts++
+	// next attempt waits again for the resume delay
+	state.since = now
+	return action
+}
+
+// states return a copy of the state of all changefeeds sorted by schema name
+func (m *changeFeedMonitor) states() (z []changeFeedState) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, state := range m.changefeeds {
+		z = append(z, state.copy())
+	}
+	sort.Slice(z, func(i, j int) bool {
+		return z[i].Schema < z[j].Schema
+	})
+	return
+}
+
+// copy return a copy of the state
+func (s *changeFeedState) copy() changeFeedState {
+	z := *s
+	z.Duplicates = append([]int64(nil), s.Duplicates...)
+	return z
+}
+
+// activeChangeFeedJobs return the running or paused jobs among the jobs of a table
+func activeChangeFeedJobs(jobs []changeFeedJob) (active []changeFeedJob) {
+	for _, v := range jobs {
+		if v.Status == "running" || v.Status == "paused" {
+			active = append(active, v)
+		}
+	}
+	return
+}
+
+// adoptChangeFeedJob return the job to track among the jobs of a table.
+// The oldest running job is preferred, then the oldest paused job
+// and finally the most recent failed job
+func adoptChangeFeedJob(jobs, active []changeFeedJob) *changeFeedJob {
+	for k := range active {
+		if active[k].Status == "running" {
+			return &active[k]
+		}
+	}
+	if len(active) > 0 {
+		return &active[0]
+	}
+	if len(jobs) > 0 {
+		return &jobs[len(jobs)-1]
+	}
+	return nil
+}
+
+// startChangeFeedAction return the job to track among the jobs of a table at startup
+// and the action to take. Failed jobs are only recreated when the resume policy allows it
+func startChangeFeedAction(policy string, jobs []changeFeedJob) (*changeFeedJob, string) {
+	job := adoptChangeFeedJob(jobs, activeChangeFeedJobs(jobs))
+	switch {
+	case job == nil:
+		return nil, changeFeedActionCreate
+	case job.Status == "failed" && policy == changeFeedResumeFailed:
+		return job, changeFeedActionRecreate
+	}
+	return job, ""
+}
+
+// cursorTooOld return true when the changefeed has been refused
+// as its cursor is older than the garbage collection of the table
+func cursorTooOld(err error) bool {
+	return err != nil && strings.Contains(strings.ToLower(err.Error()), "gc threshold")
+}
+
+// highWaterTime return the wall time of the hybrid logical clock timestamp
+// like 1700000000000000000.0000000000
+func highWaterTime(highWater string) (time.Time, bool) {
+	wall, _, _ := strings.Cut(strings.TrimSpace(highWater), ".")
+	z, err := strconv.ParseInt(wall, 10, 64)
+	if err != nil || z <= 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(0, z).UTC(), true
+}
+
+// changeFeedFrom return the changefeed starting from the provided high-water timestamp
+// unless a cursor is already provided
+func changeFeedFrom(changefeed changeFeed, highWater string) changeFeed {
+	if highWater != "" && !changeFeedOption(changefeed.Options, "cursor") {
+		changefeed.Options = append(append([]string{}, changefeed.Options...), fmt.Sprintf("cursor = '%s'", highWater))
+	}
+	return changefeed
+}
+
+// recreateChangeFeed permit to create a new changefeed for the schema starting from the high-water timestamp
+// of its failed job. A high-water timestamp older than the gc.ttlseconds of the table is refused by cockroach
+// so the changefeed is then created without cursor
+func (c *Validate) recreateChangeFeed(ctx context.Context, index int, highWater string) (id int64, err error) {
+	v := c.validatedSchemas.Schemas[index]
+	id, err = c.createChangeFeed(ctx, changeFeedFrom(v.ChangeFeed, highWater), v.Format)
+	if !cursorTooOld(err) {
+		return
+	}
+	c.Logger.Warn().Err(err).Msgf("High-water timestamp %s of table %s on schema %s has been garbage collected, the changefeed is created without cursor and changes since then are only recovered by its initial scan", highWater, v.ChangeFeed.FullTableName, v.Name)
+	return c.createChangeFeed(ctx, v.ChangeFeed, v.Format)
+}
+
+// changeFeedResumePolicy return the resume policy of the changefeed monitor
+// or the configured one when changefeeds are not monitored
+func (c *Validate) changeFeedResumePolicy() string {
+	if c.changeFeeds != nil {
+		return c.changeFeeds.policy
+	}
+	return commons.GetChangeFeedResumePolicy()
+}
+
+// trackChangeFeed permit to record the job of the changefeed of the schema
+// when changefeeds are monitored
+func (c *Validate) trackChangeFeed(schema, table string, job changeFeedJob) {
+	if c.changeFeeds != nil {
+		c.changeFeeds.track(schema, table, job)
+	}
+}
+
+// monitorChangeFeeds permit to check the changefeed jobs of all schemas
+// until the provided context is cancelled
+func (c *Validate) monitorChangeFeeds(ctx context.Context) {
+	ticker := time.NewTicker(commons.GetChangeFeedPollInterval())
+	defer ticker.Stop()
+	for {
+		c.checkChangeFeeds(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkChangeFeeds permit to update the state of the changefeed of each schema,
+// report duplicated jobs and resume paused or failed jobs according to the policy.
+// A table shared by several schemas is only acted upon once
+func (c *Validate) checkChangeFeeds(ctx context.Context) {
+	tables := make(map[string]bool)
+	for k, v := range c.validatedSchemas.Schemas {
+		if !cockroachFormat(c.messageFormat(k)) {
+			continue
+		}
+		table := v.ChangeFeed.FullTableName
+		jobs, err := c.changeFeedJobs(ctx, v.ChangeFeed, "running", "paused", "failed")
+		if err != nil {
+			if ctx.Err() == nil {
+				c.Logger.Error().Err(err).Msgf("Fail to retrieve changefeed jobs of table %s on schema %s", table, v.Name)
+			}
+			continue
+		}
+
+		state, action := c.changeFeeds.observe(v.Name, table, jobs, time.Now())
+		c.setChangeFeedMetrics(state)
+		if len(state.Duplicates) > 0 {
+			c.Logger.Warn().Msgf("Changefeed job %d of table %s on schema %s has duplicated jobs %v", state.JobId, table, v.Name, state.Duplicates)
+		}
+		if tables[table] {
+			continue
+		}
+		tables[table] = true
+		c.changeFeedAction(ctx, k, state, action)
+	}
+}
+
+// changeFeedAction permit to resume or recreate the changefeed job of the schema
+func (c *Validate) changeFeedAction(ctx context.Context, index int, state changeFeedState, action string) {
+	v := c.validatedSchemas.Schemas[index]
+	switch action {
+	case changeFeedActionResume:
+		if err := c.resumeChangeFeed(ctx, state.JobId); err != nil {
+			c.Logger.Error().Err(err).Msgf("Fail to resume changefeed job %d on schema %s", state.JobId, v.Name)
+			return
+		}
+		c.increaseMetrics("changefeed", v.Name, action)
+		c.Logger.Info().Msgf("Changefeed job %d on schema %s resumed, attempt %d/%d", state.JobId, v.Name, state.Attempts, c.changeFeeds.maxAttempts)
+
+	case changeFeedActionRecreate:
+		id, err := c.recreateChangeFeed(ctx, index, state.HighWater)
+		if err != nil {
+			c.Logger.Error().Err(err).Msgf("Fail to recreate failed changefeed job %d on schema %s", state.JobId, v.Name)
+			return
+		}
+		c.trackChangeFeed(v.Name, state.Table, changeFeedJob{Id: id, Status: "running"})
+		c.increaseMetrics("changefeed", v.Name, action)
+		c.Logger.Info().Msgf("Changefeed job %d on schema %s failed and has been recreated as job %d from %s, attempt %d/%d", state.JobId, v.Name, id, state.HighWater, state.Attempts, c.changeFeeds.maxAttempts)
+
+	case changeFeedActionGiveUp:
+		c.increaseMetrics("changefeed", v.Name, action)
+		c.Logger.Error().Msgf("Changefeed job %d on schema %s is still %s after %d attempts and must be fixed manually", state.JobId, v.Name, state.Status, state.Attempts)
+	}
+}
@@ -0,0 +1,116 @@
+// Package processing provide all requirements to process change data capture
+package processing
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// changeFeedEventOp is the column of cdc queries holding the operation of the change
+	// like insert, update, upsert or delete
+	changeFeedEventOp string = "__event_op"
+	// changeFeedPrev is the column of cdc queries holding the previous image of the row
+	changeFeedPrev string = "__cdc_prev"
+	// changeFeedMetadata is the column of the bare envelope holding the metadata of the change
+	changeFeedMetadata string = "__crdb__"
+)
+
+// changeFeedSelect is the cdc query projecting and filtering rows in the changefeed
+type changeFeedSelect struct {
+	// Columns is the projection list like id or lower(email) AS email. Default to all columns
+	Columns []string `json:"columns" yaml:"columns"`
+	// Where is the predicate rows must match like status = 'active'.
+	// It is also applied by backfill so it must only use columns of the table
+	Where string `json:"where" yaml:"where"`
+}
+
+// enabled return true when the changefeed is created with a cdc query
+func (s changeFeedSelect) enabled() bool {
+	return len(s.Columns) > 0 || strings.TrimSpace(s.Where) != ""
+}
+
+// columns return the trimmed projection list
+func (s changeFeedSelect) columns() (z []string) {
+	for _, column := range s.Columns {
+		z = append(z, strings.TrimSpace(column))
+	}
+	return
+}
+
+// changeFeedQuery return the cdc query of the changefeed.
+// The operation and the previous image of the row are added so deletes and diffs are still decoded,
+// and deletes are always emitted as deleted rows no longer match the predicate
+func changeFeedQuery(changefeed changeFeed) string {
+	projection := "*"
+	if len(changefeed.Select.Columns) > 0 {
+		projection = strings.Join(changefeed.Select.columns(), ", ")
+	}
+	q := fmt.Sprintf(
+		"SELECT %s, event_op() AS %s, cdc_prev AS %s FROM %s",
+		projection,
+		changeFeedEventOp,
+		changeFeedPrev,
+		changefeed.FullTableName,
+	)
+	if where := strings.TrimSpace(changefeed.Select.Where); where != "" {
+		q += fmt.Sprintf(" WHERE (%s) OR event_op() = 'delete'", where)
+	}
+	return q
+}
+
+// validateChangeFeedSelect permit to check the cdc query of the changefeed of the schema
+func validateChangeFeedSelect(s configSchema) error {
+	selection := s.ChangeFeed.Select
+	if !selection.enabled() {
+		return nil
+	}
+	if strings.TrimSpace(s.Format) != "" && strings.TrimSpace(s.Format) != formatCockroach {
+		return fmt.Errorf("ChangeFeed select is only supported with format %s", formatCockroach)
+	}
+	for _, column := range selection.columns() {
+		if column == "" {
+			return fmt.Errorf("ChangeFeed select columns cannot be empty")
+		}
+		if err := validateChangeFeedSQL(column); err != nil {
+			return fmt.Errorf("ChangeFeed select column `%s` %s", column, err.Error())
+		}
+		for _, reserved := range []string{changeFeedEventOp, changeFeedPrev, changeFeedMetadata} {
+			if strings.Contains(column, reserved) {
+				return fmt.Errorf("ChangeFeed select column `%s` cannot use reserved name %s", column, reserved)
+			}
+		}
+	}
+	if err := validateChangeFeedSQL(selection.Where); err != nil {
+		return fmt.Errorf("ChangeFeed select where `%s` %s", selection.Where, err.Error())
+	}
+	for _, option := range s.ChangeFeed.Options {
+		k, v, _ := strings.Cut(option, "=")
+		switch strings.ToLower(strings.TrimSpace(k)) {
+		// the previous image of the row is already projected
+		case "diff":
+			return fmt.Errorf("ChangeFeed option diff cannot be used with select")
+		case "envelope":
+			if strings.Trim(strings.TrimSpace(v), "'") != "bare" {
+				return fmt.Errorf("ChangeFeed option envelope must be bare with select")
+			}
+		}
+	}
+	return nil
+}
+
+// validateChangeFeedSQL return an error when the sql fragment could end the statement
+// or comment out the rest of the cdc query
+func validateChangeFeedSQL(fragment string) error {
+	switch {
+	case strings.Contains(fragment, ";"):
+		return fmt.Errorf("must not contain ;")
+	case strings.Contains(fragment, "--"), strings.Contains(fragment, "/*"):
+		return fmt.Errorf("must not contain comments")
+	case strings.Count(fragment, "'")%2 != 0:
+		return fmt.Errorf("has unbalanced quotes")
+	case strings.Count(fragment, "(") != strings.Count(fragment, ")"):
+		return fmt.Errorf("has unbalanced parentheses")
+	}
+	return nil
+}
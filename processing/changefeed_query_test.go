@@ -0,0 +1,81 @@
+// Package processing provide all requirements to process change data capture
+package processing
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChangeFeedQuery(t *testing.T) {
+	assert := assert.New(t)
+
+	changefeed := changeFeed{
+		FullTableName: "movr.public.users",
+		Select:        changeFeedSelect{Columns: []string{" id ", "lower(email) AS email"}, Where: "status = 'active'"},
+	}
+	assert.Equal(
+		"SELECT id, lower(email) AS email, event_op() AS __event_op, cdc_prev AS __cdc_prev FROM movr.public.users WHERE (status = 'active') OR event_op() = 'delete'",
+		changeFeedQuery(changefeed),
+	)
+
+	changefeed.Select = changeFeedSelect{Where: "status = 'active'"}
+	assert.Equal(
+		"SELECT *, event_op() AS __event_op, cdc_prev AS __cdc_prev FROM movr.public.users WHERE (status = 'active') OR event_op() = 'delete'",
+		changeFeedQuery(changefeed),
+	)
+
+	// the previous image is projected instead of using diff
+	changefeed.Options = []string{"updated"}
+	options, err := changeFeedOptions(changefeed, formatCockroach)
+	assert.Nil(err)
+	assert.Equal([]string{"updated"}, options)
+
+	assert.Equal(
+		[]string{"updated", "on_error = 'pause'"},
+		changeFeedDescriptionOptions("CREATE CHANGEFEED INTO 'kafka://redpanda:29092' WITH OPTIONS (updated, on_error = 'pause') AS SELECT id, event_op() AS __event_op FROM movr.public.users WHERE (status = 'active') OR (event_op() = 'delete')"),
+	)
+}
+
+func TestValidateChangeFeedSelect(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		format  string
+		options []string
+		columns []string
+		where   string
+		fail    bool
+	}{
+		{},
+		{columns: []string{"id", "name"}, where: "status = 'active'"},
+		{format: formatCockroach, where: "revenue > (10 * 2)"},
+		{format: formatCockroachAvro, where: "revenue > 10", fail: true},
+		{options: []string{"diff"}, where: "revenue > 10", fail: true},
+		{options: []string{"envelope = 'bare'"}, where: "revenue > 10"},
+		{options: []string{"envelope = wrapped"}, where: "revenue > 10", fail: true},
+		{columns: []string{""}, fail: true},
+		{columns: []string{"id; DROP TABLE users"}, fail: true},
+		{columns: []string{"id AS __event_op"}, fail: true},
+		{where: "status = 'active' -- comment", fail: true},
+		{where: "status = 'active", fail: true},
+		{where: "(status = 'active'", fail: true},
+	}
+
+	for _, tc := range tests {
+		s := configSchema{
+			Format: tc.format,
+			ChangeFeed: changeFeed{
+				FullTableName: "movr.public.users",
+				Options:       append([]string{"updated"}, tc.options...),
+				Select:        changeFeedSelect{Columns: tc.columns, Where: tc.where},
+			},
+		}
+		err := validateChangeFeedSelect(s)
+		if tc.fail {
+			assert.Error(err, "%+v", tc)
+		} else {
+			assert.Nil(err, "%+v", tc)
+		}
+	}
+}
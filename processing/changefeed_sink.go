@@ -0,0 +1,304 @@
+// Package processing provide all requirements to process change data capture
+package processing
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/Lord-Y/synker/commons"
+)
+
+// sinkRedacted replace secrets of sink uris
+const sinkRedacted string = "redacted"
+
+// kafkaTopicName match the topic names written as is by cockroach.
+// Other characters are escaped by cockroach
+var kafkaTopicName = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+
+// sinkSecretParams are the parameters of sink uris holding secrets or certificates.
+// CockroachDB redacts them in SHOW CHANGEFEED JOBS
+var sinkSecretParams = []string{"ca_cert", "client_cert", "client_key", "sasl_password", "sasl_client_secret"}
+
+// changeFeedSink is the kafka sink of the changefeed
+type changeFeedSink struct {
+	// URI of kafka as reached by cockroach like kafka://redpanda:29092.
+	// Default to SYNKER_CHANGEFEED_SINK_URI
+	URI string `json:"uri" yaml:"uri"`
+	// TopicPrefix is added by cockroach to the name of the topic
+	TopicPrefix string `json:"topicPrefix" yaml:"topicPrefix"`
+	// TopicName is the topic_name replacing the name of the table in the name of the topic
+	TopicName string `json:"topicName" yaml:"topicName"`
+	// KafkaSinkConfig is the kafka_sink_config option like {"Flush": {"Messages": 100}}
+	KafkaSinkConfig map[string]interface{} `json:"kafkaSinkConfig" yaml:"kafkaSinkConfig"`
+	// TLS is the requirement to connect to kafka with tls
+	TLS changeFeedSinkTLS `json:"tls" yaml:"tls"`
+	// SASL is the requirement to authenticate to kafka
+	SASL changeFeedSinkSASL `json:"sasl" yaml:"sasl"`
+}
+
+// changeFeedSinkTLS is the requirement to connect to kafka with tls
+type changeFeedSinkTLS struct {
+	// Enabled set tls_enabled
+	Enabled bool `json:"enabled" yaml:"enabled"`
+	// CACert is the path of the PEM certificate authority sent as ca_cert
+	CACert string `json:"caCert" yaml:"caCert"`
+	// ClientCert is the path of the PEM client certificate sent as client_cert
+	ClientCert string `json:"clientCert" yaml:"clientCert"`
+	// ClientKey is the path of the PEM client key sent as client_key
+	ClientKey string `json:"clientKey" yaml:"clientKey"`
+	// InsecureSkipVerify set insecure_tls_skip_verify
+	InsecureSkipVerify bool `json:"insecureSkipVerify" yaml:"insecureSkipVerify"`
+}
+
+// changeFeedSinkSASL is the requirement to authenticate to kafka
+type changeFeedSinkSASL struct {
+	// Enabled set sasl_enabled
+	Enabled bool `json:"enabled" yaml:"enabled"`
+	// Mechanism is the sasl_mechanism. Default to PLAIN
+	Mechanism string `json:"mechanism" yaml:"mechanism" validate:"omitempty,oneof=PLAIN SCRAM-SHA-256 SCRAM-SHA-512"`
+	// User is the sasl_user
+	User string `json:"user" yaml:"user"`
+	// Password is the sasl_password.
+	// Environment variables like ${KAFKA_PASSWORD} are expanded so it does not have to be written in schemas
+	Password string `json:"password" yaml:"password"`
+}
+
+// validateChangeFeedSink permit to check the sink of the changefeed of the schema
+func validateChangeFeedSink(s configSchema) error {
+	sink := s.ChangeFeed.Sink
+	if !cockroachFormat(s.Format) {
+		if !sinkEmpty(sink) {
+			return fmt.Errorf("ChangeFeed sink is only supported with cockroach formats")
+		}
+		return nil
+	}
+	if uri := strings.TrimSpace(sink.URI); uri != "" {
+		u, err := url.Parse(uri)
+		if err != nil {
+			return fmt.Errorf("ChangeFeed sink uri %s is malformed", redactSinkURI(uri))
+		}
+		if u.Scheme != "kafka" || u.Host == "" {
+			return fmt.Errorf("ChangeFeed sink uri %s must be like kafka://host:port", redactSinkURI(uri))
+		}
+	}
+	if (sink.TLS.ClientCert == "") != (sink.TLS.ClientKey == "") {
+		return fmt.Errorf("ChangeFeed sink tls clientCert and clientKey must be provided together")
+	}
+	if (sink.TLS.CACert != "" || sink.TLS.ClientCert != "" || sink.TLS.InsecureSkipVerify) && !sink.TLS.Enabled {
+		return fmt.Errorf("ChangeFeed sink tls must be enabled to use certificates")
+	}
+	if sink.SASL.Enabled && (strings.TrimSpace(sink.SASL.User) == "" || strings.TrimSpace(sink.SASL.Password) == "") {
+		return fmt.Errorf("ChangeFeed sink sasl user and password are required when sasl is enabled")
+	}
+	if len(sink.KafkaSinkConfig) > 0 {
+		if changeFeedOption(s.ChangeFeed.Options, "kafka_sink_config") {
+			return fmt.Errorf("ChangeFeed sink kafkaSinkConfig cannot be used with the kafka_sink_config option")
+		}
+		if _, err := json.Marshal(sink.KafkaSinkConfig); err != nil {
+			return fmt.Errorf("ChangeFeed sink kafkaSinkConfig is malformed: %w", err)
+		}
+	}
+	return nil
+}
+
+// sinkEmpty return true when no sink requirement is provided
+func sinkEmpty(sink changeFeedSink) bool {
+	return strings.TrimSpace(sink.URI) == "" &&
+		strings.TrimSpace(sink.TopicPrefix) == "" &&
+		strings.TrimSpace(sink.TopicName) == "" &&
+		len(sink.KafkaSinkConfig) == 0 &&
+		sink.TLS == changeFeedSinkTLS{} &&
+		sink.SASL == changeFeedSinkSASL{}
+}
+
+// changeFeedSinkURI return the uri of the sink of the changefeed
+// with its tls, sasl and topic parameters
+func changeFeedSinkURI(sink changeFeedSink) (string, error) {
+	uri := strings.TrimSpace(sink.URI)
+	if uri == "" {
+		uri = commons.GetChangeFeedSinkURI()
+	}
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", fmt.Errorf("Fail to parse changefeed sink uri %s", redactSinkURI(uri))
+	}
+
+	q := u.Query()
+	if sink.TLS.Enabled {
+		q.Set("tls_enabled", "true")
+		for param, file := range map[string]string{
+			"ca_cert":     sink.TLS.CACert,
+			"client_cert": sink.TLS.ClientCert,
+			"client_key":  sink.TLS.ClientKey,
+		} {
+			if strings.TrimSpace(file) == "" {
+				continue
+			}
+			b, err := os.ReadFile(file)
+			if err != nil {
+				return "", fmt.Errorf("Fail to read changefeed sink %s: %w", param, err)
+			}
+			q.Set(param, base64.StdEncoding.EncodeToString(b))
+		}
+		if sink.TLS.InsecureSkipVerify {
+			q.Set("insecure_tls_skip_verify", "true")
+		}
+	}
+	if sink.SASL.Enabled {
+		q.Set("sasl_enabled", "true")
+		if mechanism := strings.TrimSpace(sink.SASL.Mechanism); mechanism != "" {
+			q.Set("sasl_mechanism", mechanism)
+		}
+		q.Set("sasl_user", os.ExpandEnv(strings.TrimSpace(sink.SASL.User)))
+		q.Set("sasl_password", os.ExpandEnv(strings.TrimSpace(sink.SASL.Password)))
+	}
+	if prefix := strings.TrimSpace(sink.TopicPrefix); prefix != "" {
+		q.Set("topic_prefix", prefix)
+	}
+	if name := strings.TrimSpace(sink.TopicName); name != "" {
+		q.Set("topic_name", name)
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// redactSinkURI return the uri with its password and secret parameters redacted
+// so it can be logged
+func redactSinkURI(uri string) string {
+	u, err := url.Parse(strings.TrimSpace(uri))
+	if err != nil {
+		// the uri cannot be inspected so nothing is kept
+		return sinkRedacted
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), sinkRedacted)
+	}
+	q := u.Query()
+	for _, param := range sinkSecretParams {
+		if q.Has(param) {
+			q.Set(param, sinkRedacted)
+		}
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// normalizeSinkURI return the uri with lowercase scheme and host, sorted parameters
+// and redacted secrets so the uri of an existing job can be compared
+func normalizeSinkURI(uri string) string {
+	u, err := url.Parse(redactSinkURI(uri))
+	if err != nil {
+		return strings.TrimSpace(uri)
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	return u.String()
+}
+
+// sameSinkURI return true when both uris target the same sink with the same parameters
+func sameSinkURI(a, b string) bool {
+	return normalizeSinkURI(a) == normalizeSinkURI(b)
+}
+
+// redactSinkError return the error with the secrets of the uri redacted
+func redactSinkError(err error, uri string) error {
+	if err == nil || uri == "" {
+		return err
+	}
+	msg := err.Error()
+	if !strings.Contains(msg, uri) && !slices.ContainsFunc(sinkSecretValues(uri), func(v string) bool { return strings.Contains(msg, v) }) {
+		return err
+	}
+	msg = strings.ReplaceAll(msg, uri, redactSinkURI(uri))
+	for _, v := range sinkSecretValues(uri) {
+		msg = strings.ReplaceAll(msg, v, sinkRedacted)
+	}
+	return errors.New(msg)
+}
+
+// sinkSecretValues return the secrets of the uri
+func sinkSecretValues(uri string) (z []string) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return
+	}
+	if password, ok := u.User.Password(); ok && password != "" {
+		z = append(z, password)
+	}
+	q := u.Query()
+	for _, param := range sinkSecretParams {
+		if v := q.Get(param); v != "" {
+			z = append(z, v)
+		}
+	}
+	return
+}
+
+// changeFeedTopic return the topic written by the changefeed.
+// Cockroach names it after the table, or its full name with the full_table_name option,
+// unless topic_name is provided, and prepends topic_prefix.
+// exact is false when the name cannot be derived like with escaped characters
+func changeFeedTopic(changefeed changeFeed) (topic string, exact bool) {
+	uri := strings.TrimSpace(changefeed.Sink.URI)
+	if uri == "" {
+		uri = commons.GetChangeFeedSinkURI()
+	}
+	var prefix, name string
+	if u, err := url.Parse(uri); err == nil {
+		prefix, name = u.Query().Get("topic_prefix"), u.Query().Get("topic_name")
+	}
+	if z := strings.TrimSpace(changefeed.Sink.TopicPrefix); z != "" {
+		prefix = z
+	}
+	if z := strings.TrimSpace(changefeed.Sink.TopicName); z != "" {
+		name = z
+	}
+
+	if name == "" {
+		parts := strings.Split(strings.TrimSpace(changefeed.FullTableName), ".")
+		for k := range parts {
+			parts[k] = strings.Trim(strings.TrimSpace(parts[k]), `"`)
+		}
+		if changeFeedOption(changefeed.Options, "full_table_name") {
+			// the database and schema of the table are required
+			if len(parts) != 3 {
+				return "", false
+			}
+			name = strings.Join(parts, ".")
+		} else {
+			name = parts[len(parts)-1]
+		}
+	}
+	topic = prefix + name
+	return topic, kafkaTopicName.MatchString(topic)
+}
+
+// validateTopic permit to check that the topic of the schema is the one written by its changefeed.
+// When the topic name is omitted, it is derived from the changefeed
+func validateTopic(s *configSchema) error {
+	name := strings.TrimSpace(s.Topic.Name)
+	if !cockroachFormat(s.Format) {
+		if name == "" {
+			return fmt.Errorf("Topic name is required with format %s", s.Format)
+		}
+		return nil
+	}
+
+	expected, exact := changeFeedTopic(s.ChangeFeed)
+	switch {
+	case name == "" && !exact:
+		return fmt.Errorf("Topic name cannot be derived from table %s and is required", s.ChangeFeed.FullTableName)
+	case name == "":
+		s.Topic.Name = expected
+	case exact && name != expected:
+		return fmt.Errorf("Topic name %s does not match topic %s written by the changefeed of table %s", name, expected, s.ChangeFeed.FullTableName)
+	}
+	return nil
+}
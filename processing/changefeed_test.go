@@ -0,0 +1,183 @@
+// Package processing provide all requirements to process change data capture
+package processing
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChangeFeedMonitorObserve(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &changeFeedMonitor{
+		changefeeds: make(map[string]*changeFeedState),
+		policy:      changeFeedResumePaused,
+		after:       time.Minute,
+		maxAttempts: 2,
+	}
+	now := time.Now()
+	table := "movr.public.users"
+
+	state, action := m.observe("users", table, nil, now)
+	assert.Equal(changeFeedMissing, state.Status)
+	assert.Equal("", action)
+
+	// the oldest running job is tracked and the other ones are duplicates
+	jobs := []changeFeedJob{
+		{Id: 1, Status: "paused"},
+		{Id: 2, Status: "running", HighWater: "1700000000000000000.0000000000"},
+		{Id: 3, Status: "running"},
+	}
+	state, action = m.observe("users", table, jobs, now)
+	assert.Equal(int64(2), state.JobId)
+	assert.Equal("running", state.Status)
+	assert.Equal([]int64{1, 3}, state.Duplicates)
+	assert.Equal("", action)
+
+	// paused jobs are resumed once paused for long enough
+	jobs = []changeFeedJob{{Id: 2, Status: "paused"}}
+	state, action = m.observe("users", table, jobs, now)
+	assert.Equal("paused", state.Status)
+	assert.Equal(0, len(state.Duplicates))
+	assert.Equal("", action)
+	state, action = m.observe("users", table, jobs, now.Add(time.Minute))
+	assert.Equal(changeFeedActionResume, action)
+	assert.Equal(1, state.Attempts)
+
+	// each attempt waits again before the next one
+	_, action = m.observe("users", table, jobs, now.Add(90*time.Second))
+	assert.Equal("", action)
+	_, action = m.observe("users", table, jobs, now.Add(2*time.Minute))
+	assert.Equal(changeFeedActionResume, action)
+	_, action = m.observe("users", table, jobs, now.Add(3*time.Minute))
+	assert.Equal(changeFeedActionGiveUp, action)
+	_, action = m.observe("users", table, jobs, now.Add(4*time.Minute))
+	assert.Equal("", action)
+
+	// attempts are reset once the job has been running long enough
+	jobs = []changeFeedJob{{Id: 2, Status: "running"}}
+	state, _ = m.observe("users", table, jobs, now.Add(5*time.Minute))
+	assert.Equal(2, state.Attempts)
+	state, _ = m.observe("users", table, jobs, now.Add(6*time.Minute))
+	assert.Equal(0, state.Attempts)
+
+	// failed jobs are only recreated with the failed policy
+	jobs = []changeFeedJob{{Id: 2, Status: "failed", HighWater: "1.0"}}
+	m.observe("users", table, jobs, now.Add(7*time.Minute))
+	_, action = m.observe("users", table, jobs, now.Add(8*time.Minute))
+	assert.Equal("", action)
+	m.policy = changeFeedResumeFailed
+	state, action = m.observe("users", table, jobs, now.Add(9*time.Minute))
+	assert.Equal(changeFeedActionRecreate, action)
+	assert.Equal("1.0", state.HighWater)
+
+	// the recreated job replaces the failed one
+	m.track("users", table, changeFeedJob{Id: 4, Status: "running"})
+	jobs = append(jobs, changeFeedJob{Id: 4, Status: "running"})
+	state, _ = m.observe("users", table, jobs, now.Add(10*time.Minute))
+	assert.Equal(int64(4), state.JobId)
+	assert.Equal(0, len(state.Duplicates))
+
+	m.policy = changeFeedResumeNever
+	jobs = []changeFeedJob{{Id: 4, Status: "paused"}}
+	m.observe("users", table, jobs, now.Add(11*time.Minute))
+	_, action = m.observe("users", table, jobs, now.Add(20*time.Minute))
+	assert.Equal("", action)
+
+	assert.Equal(1, len(m.states()))
+}
+
+func TestAdoptChangeFeedJob(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(adoptChangeFeedJob(nil, nil))
+
+	jobs := []changeFeedJob{{Id: 1, Status: "failed"}, {Id: 2, Status: "failed"}}
+	assert.Equal(int64(2), adoptChangeFeedJob(jobs, nil).Id)
+
+	active := []changeFeedJob{{Id: 3, Status: "paused"}, {Id: 4, Status: "paused"}}
+	assert.Equal(int64(3), adoptChangeFeedJob(jobs, active).Id)
+
+	active = append(active, changeFeedJob{Id: 5, Status: "running"})
+	assert.Equal(int64(5), adoptChangeFeedJob(jobs, active).Id)
+}
+
+func TestActiveChangeFeedJobs(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(activeChangeFeedJobs(nil))
+	assert.Nil(activeChangeFeedJobs([]changeFeedJob{{Id: 1, Status: "failed"}}))
+	assert.Equal(
+		[]changeFeedJob{{Id: 2, Status: "paused"}, {Id: 3, Status: "running"}},
+		activeChangeFeedJobs([]changeFeedJob{{Id: 1, Status: "failed"}, {Id: 2, Status: "paused"}, {Id: 3, Status: "running"}}),
+	)
+}
+
+func TestStartChangeFeedAction(t *testing.T) {
+	assert := assert.New(t)
+
+	failed := []changeFeedJob{{Id: 1, Status: "failed", HighWater: "1.0"}, {Id: 2, Status: "failed", HighWater: "2.0"}}
+	active := append(append([]changeFeedJob{}, failed...), changeFeedJob{Id: 3, Status: "paused"})
+
+	tests := []struct {
+		policy string
+		jobs   []changeFeedJob
+		id     int64
+		action string
+	}{
+		{policy: changeFeedResumeNever, action: changeFeedActionCreate},
+		{policy: changeFeedResumeFailed, action: changeFeedActionCreate},
+		{policy: changeFeedResumeNever, jobs: failed, id: 2},
+		{policy: changeFeedResumePaused, jobs: failed, id: 2},
+		{policy: changeFeedResumeFailed, jobs: failed, id: 2, action: changeFeedActionRecreate},
+		{policy: changeFeedResumeNever, jobs: active, id: 3},
+		{policy: changeFeedResumePaused, jobs: active, id: 3},
+		{policy: changeFeedResumeFailed, jobs: active, id: 3},
+	}
+
+	for _, tc := range tests {
+		job, action := startChangeFeedAction(tc.policy, tc.jobs)
+		assert.Equal(tc.action, action, tc.policy)
+		if tc.id == 0 {
+			assert.Nil(job)
+			continue
+		}
+		assert.Equal(tc.id, job.Id, tc.policy)
+	}
+}
+
+func TestCursorTooOld(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.False(cursorTooOld(nil))
+	assert.False(cursorTooOld(errors.New("connection refused")))
+	assert.True(cursorTooOld(errors.New("ERROR: batch timestamp 1700000000.000000000,0 must be after replica GC threshold 1700000600.000000000,0 (SQLSTATE XXUUU)")))
+}
+
+func TestHighWaterTime(t *testing.T) {
+	assert := assert.New(t)
+
+	z, ok := highWaterTime("1700000000123456789.0000000001")
+	assert.True(ok)
+	assert.Equal(time.Unix(1700000000, 123456789).UTC(), z)
+
+	_, ok = highWaterTime("")
+	assert.False(ok)
+	_, ok = highWaterTime("abc")
+	assert.False(ok)
+}
+
+func TestChangeFeedFrom(t *testing.T) {
+	assert := assert.New(t)
+
+	changefeed := changeFeed{FullTableName: "movr.public.users", Options: []string{"updated"}}
+	assert.Equal([]string{"updated", "cursor = '1.0'"}, changeFeedFrom(changefeed, "1.0").Options)
+	assert.Equal([]string{"updated"}, changefeed.Options)
+	assert.Equal([]string{"updated"}, changeFeedFrom(changefeed, "").Options)
+
+	changefeed.Options = []string{"cursor = '2.0'"}
+	assert.Equal([]string{"cursor = '2.0'"}, changeFeedFrom(changefeed, "1.0").Options)
+}
@@ -0,0 +1,205 @@
+// Package processing provide all requirements to process change data capture
+package processing
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Lord-Y/synker/commons"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/olivere/elastic/v7"
+	"github.com/segmentio/kafka-go"
+)
+
+// clients hold the long-lived connections to cockroach, elasticsearch
+// and kafka shared by all consumers for the life of the process
+type clients struct {
+	mu            sync.Mutex
+	db            *pgxpool.Pool
+	elasticsearch *elastic.Client
+	dialer        *kafka.Dialer
+	transport     *kafka.Transport
+	// admin is the client describing and altering kafka topics and groups
+	admin *kafka.Client
+	// controller is the connection to the kafka controller creating and deleting topics
+	controller *kafka.Conn
+	// brokers are the addresses of the kafka brokers
+	brokers []string
+	// kafkaConns and elasticsearchConns count the connections opened to each of them
+	kafkaConns         connCounter
+	elasticsearchConns connCounter
+	// sinks hold the sink of each kind
+	sinks map[string]sink
+	// registry cache avro schemas of changefeeds
+	registry *schemaRegistry
+}
+
+// pgPool return the cockroach connection pool
+// created on first call
+func (c *Validate) pgPool(ctx context.Context) (db *pgxpool.Pool, err error) {
+	c.clients.mu.Lock()
+	defer c.clients.mu.Unlock()
+	if c.clients.db != nil {
+		return c.clients.db, nil
+	}
+
+	cfg, err := pgxpool.ParseConfig(commons.GetPGURI())
+	if err != nil {
+		return
+	}
+	cfg.MaxConns = int32(commons.GetPGMaxConns())
+	cfg.MinConns = int32(commons.GetPGMinConns())
+	if cfg.MinConns > cfg.MaxConns {
+		cfg.MinConns = cfg.MaxConns
+	}
+	cfg.HealthCheckPeriod = commons.GetPGHealthCheckPeriod()
+	cfg.ConnConfig.ConnectTimeout = commons.GetPGConnectTimeout()
+
+	// the pool must not be closed when the context of the caller is cancelled
+	db, err = pgxpool.NewWithConfig(context.WithoutCancel(ctx), cfg)
+	if err != nil {
+		return
+	}
+	c.clients.db = db
+	return
+}
+
+// eClient return the elasticsearch client
+// created on first call
+func (c *Validate) eClient() (client *elastic.Client, err error) {
+	c.clients.mu.Lock()
+	defer c.clients.mu.Unlock()
+	if c.clients.elasticsearch != nil {
+		return c.clients.elasticsearch, nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = c.clients.elasticsearchConns.dial((&net.Dialer{
+		Timeout: commons.GetElasticsearchConnectTimeout(),
+	}).DialContext)
+	transport.MaxIdleConns = commons.GetElasticsearchMaxIdleConns()
+	transport.MaxIdleConnsPerHost = commons.GetElasticsearchMaxIdleConns()
+
+	client, err = elastic.NewClient(
+		elastic.SetSniff(false),
+		elastic.SetURL(commons.GetElasticsearchURI()),
+		elastic.SetGzip(true),
+		elastic.SetHttpClient(&http.Client{Transport: transport}),
+		elastic.SetHealthcheckTimeoutStartup(commons.GetElasticsearchConnectTimeout()),
+		elastic.SetHealthcheckTimeout(commons.GetElasticsearchConnectTimeout()),
+		elastic.SetHealthcheckInterval(commons.GetElasticsearchHealthCheckInterval()),
+	)
+	if err != nil {
+		return
+	}
+	c.clients.elasticsearch = client
+	return
+}
+
+// kDialer return the dialer used to connect to kafka brokers
+// created on first call
+func (c *Validate) kDialer() (dialer *kafka.Dialer, err error) {
+	c.clients.mu.Lock()
+	defer c.clients.mu.Unlock()
+	if c.clients.dialer != nil {
+		return c.clients.dialer, nil
+	}
+
+	dialer, err = c.newKDialer()
+	if err != nil {
+		return
+	}
+	dialer.DialFunc = c.clients.kafkaConns.dial((&net.Dialer{}).DialContext)
+	c.clients.dialer = dialer
+	return
+}
+
+// kTransport return the transport shared by all kafka writers
+// so connections to brokers are reused
+func (c *Validate) kTransport() (transport *kafka.Transport, err error) {
+	dialer, err := c.kDialer()
+	if err != nil {
+		return
+	}
+
+	c.clients.mu.Lock()
+	defer c.clients.mu.Unlock()
+	if c.clients.transport != nil {
+		return c.clients.transport, nil
+	}
+
+	transport = &kafka.Transport{
+		Dial:        c.clients.kafkaConns.dial((&net.Dialer{}).DialContext),
+		DialTimeout: commons.GetKafkaConnectTimeout(),
+		IdleTimeout: commons.GetKafkaIdleTimeout(),
+		TLS:         dialer.TLS,
+		SASL:        dialer.SASLMechanism,
+	}
+	c.clients.transport = transport
+	return
+}
+
+// closeClients permit to close all long-lived connections
+func (c *Validate) closeClients() {
+	c.clients.mu.Lock()
+	defer c.clients.mu.Unlock()
+	if c.clients.db != nil {
+		c.clients.db.Close()
+		c.clients.db = nil
+	}
+	if c.clients.elasticsearch != nil {
+		c.clients.elasticsearch.Stop()
+		c.clients.elasticsearch = nil
+	}
+	if c.clients.controller != nil {
+		c.clients.controller.Close()
+		c.clients.controller = nil
+	}
+	if c.clients.transport != nil {
+		c.clients.transport.CloseIdleConnections()
+		c.clients.transport = nil
+	}
+	c.clients.admin = nil
+	c.clients.brokers = nil
+	c.clients.dialer = nil
+	c.clients.sinks = nil
+	c.clients.registry = nil
+}
+
+// connCounter count the connections opened by a dial function
+// as http and kafka transports do not expose the statistics of their pools
+type connCounter struct {
+	open  atomic.Int64
+	dials atomic.Int64
+}
+
+// dial return the dial function counting the connections it opens
+func (n *connCounter) dial(dial func(ctx context.Context, network, address string) (net.Conn, error)) func(ctx context.Context, network, address string) (net.Conn, error) {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		conn, err := dial(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		n.dials.Add(1)
+		n.open.Add(1)
+		return &countedConn{Conn: conn, counter: n}, nil
+	}
+}
+
+// countedConn is a connection released from its counter once closed
+type countedConn struct {
+	net.Conn
+	counter *connCounter
+	once    sync.Once
+}
+
+// Close implements net.Conn
+func (c *countedConn) Close() error {
+	c.once.Do(func() {
+		c.counter.open.Add(-1)
+	})
+	return c.Conn.Close()
+}
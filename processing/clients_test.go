@@ -0,0 +1,89 @@
+// Package processing provide all requirements to process change data capture
+package processing
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/Lord-Y/synker/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKafkaClients_shared(t *testing.T) {
+	assert := assert.New(t)
+	var c Validate
+	c.Logger = logger.NewLogger()
+
+	dialer, err := c.kDialer()
+	assert.Nil(err)
+	again, err := c.kDialer()
+	assert.Nil(err)
+	assert.Same(dialer, again)
+
+	transport, err := c.kTransport()
+	assert.Nil(err)
+	w, err := c.kWriter([]string{"127.0.0.1:9092"}, "synker")
+	assert.Nil(err)
+	assert.Same(transport, w.Transport)
+
+	c.clients.admin = &kafka.Client{Transport: transport}
+	c.clients.brokers = []string{"127.0.0.1:9092"}
+	admin, err := c.kAdmin()
+	assert.Nil(err)
+	assert.Same(c.clients.admin, admin)
+
+	c.closeClients()
+	assert.Nil(c.clients.dialer)
+	assert.Nil(c.clients.transport)
+	assert.Nil(c.clients.admin)
+	assert.Nil(c.clients.brokers)
+}
+
+func TestConnCounter(t *testing.T) {
+	assert := assert.New(t)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(err)
+	defer listener.Close()
+
+	var n connCounter
+	dial := n.dial((&net.Dialer{}).DialContext)
+	first, err := dial(context.Background(), "tcp", listener.Addr().String())
+	assert.Nil(err)
+	second, err := dial(context.Background(), "tcp", listener.Addr().String())
+	assert.Nil(err)
+	assert.Equal(int64(2), n.open.Load())
+
+	assert.Nil(first.Close())
+	first.Close()
+	assert.Equal(int64(1), n.open.Load())
+	assert.Equal(int64(2), n.dials.Load())
+	assert.Nil(second.Close())
+	assert.Equal(int64(0), n.open.Load())
+
+	_, err = dial(context.Background(), "tcp", "127.0.0.1:1")
+	assert.Error(err)
+	assert.Equal(int64(2), n.dials.Load())
+}
+
+func TestPoolCollector_without_pool(t *testing.T) {
+	assert := assert.New(t)
+	var c Validate
+	c.Logger = logger.NewLogger()
+
+	ch := make(chan prometheus.Metric, 10)
+	c.newPoolCollector().Collect(ch)
+	close(ch)
+	assert.Equal(0, len(ch))
+
+	// kafka connections are exposed once kafka is used
+	_, err := c.kDialer()
+	assert.Nil(err)
+	ch = make(chan prometheus.Metric, 10)
+	c.newPoolCollector().Collect(ch)
+	close(ch)
+	assert.Equal(2, len(ch))
+}
@@ -3,58 +3,150 @@ package processing
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"math"
 	"slices"
-	"strconv"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/Lord-Y/synker/commons"
-	"github.com/jackc/pgtype"
+	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/pgtype"
 )
 
-// countChangeFeed permit to check if change feed exist or not
-func countChangeFeed(full_table_name, status string) (count int, err error) {
-	cfg, err := pgxpool.ParseConfig(commons.GetPGURI())
+// changeFeedJobs permit to retrieve the changefeed jobs of the table into the sink
+// of the changefeed with one of the provided statuses ordered by creation time.
+// Default to running or paused jobs
+func (c *Validate) changeFeedJobs(ctx context.Context, changefeed changeFeed, statuses ...string) (jobs []changeFeedJob, err error) {
+	uri, err := changeFeedSinkURI(changefeed.Sink)
 	if err != nil {
 		return
 	}
-
-	ctx := context.Background()
-	db, err := pgxpool.NewWithConfig(ctx, cfg)
+	db, err := c.pgPool(ctx)
 	if err != nil {
 		return
 	}
-	defer db.Close()
+	if len(statuses) == 0 {
+		statuses = []string{"running", "paused"}
+	}
 
-	err = db.QueryRow(
+	// secrets of sink uris are redacted by cockroach so they are compared once normalized
+	rows, err := db.Query(
 		ctx,
-		"SELECT COUNT(job_id) FROM [SHOW CHANGEFEED JOBS] WHERE full_table_names = $1 AND status = $2 and sink_uri = $3 LIMIT 1",
-		fmt.Sprintf("{%s}", full_table_name),
-		status,
-		fmt.Sprintf("kafka://%s", commons.GetKafkaURI()),
-	).Scan(&count)
-	if err != nil && err.Error() != pgx.ErrNoRows.Error() {
+		"SELECT job_id, status, description, COALESCE(high_water_timestamp::STRING, ''), sink_uri FROM [SHOW CHANGEFEED JOBS] WHERE full_table_names = $1 AND status = ANY($2) ORDER BY created",
+		fmt.Sprintf("{%s}", changefeed.FullTableName),
+		statuses,
+	)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var job changeFeedJob
+		if err = rows.Scan(&job.Id, &job.Status, &job.Description, &job.HighWater, &job.SinkURI); err != nil {
+			return
+		}
+		if sameSinkURI(job.SinkURI, uri) {
+			job.SinkURI = redactSinkURI(job.SinkURI)
+			jobs = append(jobs, job)
+		}
+	}
+	err = rows.Err()
+	return
+}
+
+// cancelChangeFeed permit to cancel the changefeed job
+func (c *Validate) cancelChangeFeed(ctx context.Context, id int64) (err error) {
+	db, err := c.pgPool(ctx)
+	if err != nil {
+		return
+	}
+	_, err = db.Exec(ctx, "CANCEL JOB $1", id)
+	return
+}
+
+// resumeChangeFeed permit to resume the paused changefeed job
+func (c *Validate) resumeChangeFeed(ctx context.Context, id int64) (err error) {
+	db, err := c.pgPool(ctx)
+	if err != nil {
+		return
+	}
+	_, err = db.Exec(ctx, "RESUME JOB $1", id)
+	return
+}
+
+// jobStatus permit to retrieve the status of the job
+func (c *Validate) jobStatus(ctx context.Context, id int64) (status string, err error) {
+	db, err := c.pgPool(ctx)
+	if err != nil {
 		return
 	}
+	err = db.QueryRow(ctx, "SELECT status FROM [SHOW JOBS] WHERE job_id = $1", id).Scan(&status)
+	return
+}
+
+// changeFeedOption return true when the option is already provided
+// like `resolved` or `format = avro`
+func changeFeedOption(options []string, name string) bool {
+	for _, option := range options {
+		k, _, _ := strings.Cut(option, "=")
+		if strings.EqualFold(strings.TrimSpace(k), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// changeFeedOptions return the options of the changefeed
+// with the ones required by synker and the format of the schema
+func changeFeedOptions(changefeed changeFeed, format string) (options []string, err error) {
+	options = append(options, changefeed.Options...)
+	// cdc queries project the previous image of the row instead
+	if !changefeed.Select.enabled() && !slices.Contains(options, "diff") {
+		options = append(options, "diff")
+	}
+	if strings.TrimSpace(format) == formatCockroachAvro {
+		if !changeFeedOption(options, "format") {
+			options = append(options, "format = avro")
+		}
+		if !changeFeedOption(options, "confluent_schema_registry") {
+			uri := commons.GetSchemaRegistryURI()
+			if uri == "" {
+				return nil, fmt.Errorf("SYNKER_SCHEMA_REGISTRY_URI is required to create avro changefeeds")
+			}
+			options = append(options, fmt.Sprintf("confluent_schema_registry = '%s'", strings.ReplaceAll(uri, "'", "''")))
+		}
+	}
+	if len(changefeed.Sink.KafkaSinkConfig) > 0 && !changeFeedOption(options, "kafka_sink_config") {
+		b, err := json.Marshal(changefeed.Sink.KafkaSinkConfig)
+		if err != nil {
+			return nil, fmt.Errorf("Fail to marshal kafka sink config: %w", err)
+		}
+		options = append(options, fmt.Sprintf("kafka_sink_config = '%s'", strings.ReplaceAll(string(b), "'", "''")))
+	}
 	return
 }
 
 // createChangeFeed with create the change feed in the database
-func createChangeFeed(changefeed changeFeed) (err error) {
-	cfg, err := pgxpool.ParseConfig(commons.GetPGURI())
+// and return the id of its job
+func (c *Validate) createChangeFeed(ctx context.Context, changefeed changeFeed, format string) (id int64, err error) {
+	options, err := changeFeedOptions(changefeed, format)
+	if err != nil {
+		return
+	}
+	uri, err := changeFeedSinkURI(changefeed.Sink)
 	if err != nil {
 		return
 	}
 
-	ctx := context.Background()
-	db, err := pgxpool.NewWithConfig(ctx, cfg)
+	db, err := c.pgPool(ctx)
 	if err != nil {
 		return
 	}
-	defer db.Close()
 
 	tx, err := db.Begin(ctx)
 	if err != nil {
@@ -63,19 +155,25 @@ func createChangeFeed(changefeed changeFeed) (err error) {
 	//golangci-lint fail on this check while the transaction error is checked
 	defer tx.Rollback(ctx) //nolint
 
-	if !slices.Contains(changefeed.Options, "diff") {
-		changefeed.Options = append(changefeed.Options, "diff")
-	}
 	q := fmt.Sprintf(
 		"CREATE CHANGEFEED FOR TABLE %s INTO '%s' WITH %s",
 		changefeed.FullTableName,
-		fmt.Sprintf("kafka://%s", commons.GetKafkaURI()),
-		strings.Join(changefeed.Options, ","),
+		strings.ReplaceAll(uri, "'", "''"),
+		strings.Join(options, ","),
 	)
+	if changefeed.Select.enabled() {
+		q = fmt.Sprintf(
+			"CREATE CHANGEFEED INTO '%s' WITH %s AS %s",
+			strings.ReplaceAll(uri, "'", "''"),
+			strings.Join(options, ","),
+			changeFeedQuery(changefeed),
+		)
+	}
 
-	_, err = tx.Exec(ctx, q)
+	// errors may contain the statement with the secrets of the sink
+	err = tx.QueryRow(ctx, q).Scan(&id)
 	if err != nil {
-		return
+		return 0, redactSinkError(err, uri)
 	}
 
 	if err = tx.Commit(ctx); err != nil {
@@ -84,43 +182,86 @@ func createChangeFeed(changefeed changeFeed) (err error) {
 	return
 }
 
-// query perform the advanced query on the database in order to retrieve data
-func (c *Validate) query(query string, table string, value map[string]interface{}) (z map[string]interface{}, fquery string, err error) {
-	var (
-		q []string
-	)
-	for k, v := range value {
-		switch c := v.(type) {
-		case string:
-			q = append(q, fmt.Sprintf("%s.%s = '%s'", table, k, c))
-		case int8, int16, int32, int64:
-			q = append(q, fmt.Sprintf("%s.%s = %d", table, k, c))
-		case float32, float64:
-			q = append(q, fmt.Sprintf("%s.%s = %f", table, k, c))
-		}
+// queryFilters return the filters of the advanced query built from the provided
+// value with their positional arguments so row data is never interpolated
+// into the SQL query
+func queryFilters(table string, value map[string]interface{}) (filters []string, args []interface{}) {
+	// columns are sorted so the same query is always generated for the same table
+	columns := make([]string, 0, len(value))
+	for k := range value {
+		columns = append(columns, k)
 	}
+	sort.Strings(columns)
 
-	cfg, err := pgxpool.ParseConfig(commons.GetPGURI())
-	if err != nil {
-		return
+	for _, k := range columns {
+		column := pgx.Identifier{table, k}.Sanitize()
+		switch v := value[k].(type) {
+		case nil:
+			filters = append(filters, fmt.Sprintf("%s IS NULL", column))
+		default:
+			args = append(args, queryArg(v))
+			filters = append(filters, fmt.Sprintf("%s = $%d", column, len(args)))
+		}
 	}
+	return
+}
 
-	ctx := context.Background()
-	db, err := pgxpool.NewWithConfig(ctx, cfg)
-	if err != nil {
-		return
+// queryArg return the value of the kafka message as query argument
+func queryArg(v interface{}) interface{} {
+	switch z := v.(type) {
+	// json numbers are kept as is by decoders so integers do not lose precision
+	case json.Number:
+		return numberValue(z)
+	case float64:
+		if z == math.Trunc(z) && math.Abs(z) < 1<<53 {
+			return int64(z)
+		}
 	}
-	defer db.Close()
+	return v
+}
 
+// advancedQuery return the advanced query with its filters and arguments.
+// With aggregate, rows are ordered by its orderBy expressions
+// and limited to one more row than its limit to detect truncation,
+// otherwise only one row is returned
+func advancedQuery(query string, filters []string, args []interface{}, aggregate sqlAggregate) (fquery string, fargs []interface{}) {
+	fargs = args
 	if strings.Contains(strings.ToLower(query), " where ") {
-		fquery = query + " AND " + strings.Join(q, " AND ") + " LIMIT 1"
+		fquery = query + " AND " + strings.Join(filters, " AND ")
 	} else {
-		fquery = query + " WHERE " + strings.Join(q, " AND ") + " LIMIT 1"
+		fquery = query + " WHERE " + strings.Join(filters, " AND ")
+	}
+	if len(aggregate.GroupBy) == 0 {
+		return fquery + " LIMIT 1", fargs
+	}
+	if len(aggregate.OrderBy) > 0 {
+		fquery = fquery + " ORDER BY " + strings.Join(aggregate.OrderBy, ", ")
+	}
+	if limit := aggregateLimit(aggregate); limit > 0 {
+		fargs = append(fargs, limit+1)
+		fquery = fmt.Sprintf("%s LIMIT $%d", fquery, len(fargs))
+	}
+	return
+}
+
+// query perform the advanced query on the database in order to retrieve data.
+// When aggregate is provided, all rows returned are folded into one document.
+// No document is returned when the query returns no row
+func (c *Validate) query(ctx context.Context, query string, table string, value map[string]interface{}, aggregate sqlAggregate) (z map[string]interface{}, fquery string, err error) {
+	q, args := queryFilters(table, value)
+
+	db, err := c.pgPool(ctx)
+	if err != nil {
+		return
 	}
 
+	fquery, args = advancedQuery(query, q, args, aggregate)
+
+	c.Logger.Debug().Msgf("Executing SQL query `%s` with args %v", fquery, args)
 	rows, err := db.Query(
 		ctx,
 		fquery,
+		args...,
 	)
 	if err != nil && err.Error() != pgx.ErrNoRows.Error() {
 		return
@@ -132,54 +273,61 @@ func (c *Validate) query(query string, table string, value map[string]interface{
 	for _, col := range fieldDescriptions {
 		columns = append(columns, string(col.Name))
 	}
-	result := make(map[string]interface{}, len(columns))
-	values := make([]interface{}, len(columns))
 
-	for i := range values {
-		values[i] = new(interface{})
-	}
+	var results []map[string]interface{}
 	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		for i := range values {
+			values[i] = new(interface{})
+		}
 		if err = rows.Scan(values...); err != nil {
 			return
 		}
-	}
-	for i, column := range columns {
-		val := *(values[i].(*interface{}))
-		switch c := val.(type) {
-		case string, time.Time, int8, int16, int32, int64, float32, float64:
-			result[column] = c
-		case pgtype.Numeric:
-			var (
-				x pgtype.Numeric
-				f float64
-			)
-			x = c
-			buf := make([]byte, 0, 32)
-
-			buf = append(buf, x.Int.String()...)
-			buf = append(buf, 'e')
-			buf = append(buf, strconv.FormatInt(int64(x.Exp), 10)...)
-
-			f, err = strconv.ParseFloat(string(buf), 64)
-			if err != nil {
-				return
-			}
-			result[column] = f
-		case [16]uint8:
-			var (
-				x pgtype.UUID
-				b []byte
-			)
-			x.Bytes = c
-			x.Status = 2
-			b, err = x.MarshalJSON()
+		result := make(map[string]interface{}, len(columns))
+		for i, column := range columns {
+			result[column], err = queryValue(*(values[i].(*interface{})))
 			if err != nil {
 				return
 			}
-			result[column] = string(b)
-		default:
-			result[column] = c
 		}
+		results = append(results, result)
+	}
+	if err = rows.Err(); err != nil {
+		return
+	}
+
+	if len(aggregate.GroupBy) > 0 {
+		if limit := aggregateLimit(aggregate); limit > 0 && len(results) > limit {
+			c.Logger.Debug().Msgf("SQL query `%s` with args %v returned more than %d rows, only the first %d are aggregated", fquery, args, limit, limit)
+			results = results[:limit]
+		}
+		z, err = aggregateRows(results, aggregate)
+		return z, fquery, err
+	}
+	if len(results) == 0 {
+		return nil, fquery, nil
+	}
+	return results[len(results)-1], fquery, nil
+}
+
+// queryValue return the value returned by the database
+// converted to a value that can be indexed
+func queryValue(val interface{}) (z interface{}, err error) {
+	switch c := val.(type) {
+	case string, time.Time, int8, int16, int32, int64, float32, float64:
+		return c, nil
+	case pgtype.Numeric:
+		f, err := c.Float64Value()
+		if err != nil {
+			return nil, err
+		}
+		if !f.Valid {
+			return nil, nil
+		}
+		return f.Float64, nil
+	case [16]uint8:
+		return uuid.UUID(c).String(), nil
+	default:
+		return c, nil
 	}
-	return result, fquery, nil
 }
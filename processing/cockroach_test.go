@@ -0,0 +1,56 @@
+// Package processing provide all requirements to process change data capture
+package processing
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryFilters(t *testing.T) {
+	assert := assert.New(t)
+
+	filters, args := queryFilters("promo_codes", map[string]interface{}{
+		"code":          "it's",
+		"expiration":    nil,
+		"enabled":       true,
+		"usage":         float64(3),
+		"id":            json.Number("946271346521194497"),
+		"amount":        json.Number("12.5"),
+		"balance":       json.Number("123456789012345678901234567890"),
+		"ratio":         1.5,
+		"rules":         map[string]interface{}{"type": "percent"},
+		"creation_time": "2024-01-01T00:00:00",
+	})
+
+	assert.Equal(
+		[]string{
+			`"promo_codes"."amount" = $1`,
+			`"promo_codes"."balance" = $2`,
+			`"promo_codes"."code" = $3`,
+			`"promo_codes"."creation_time" = $4`,
+			`"promo_codes"."enabled" = $5`,
+			`"promo_codes"."expiration" IS NULL`,
+			`"promo_codes"."id" = $6`,
+			`"promo_codes"."ratio" = $7`,
+			`"promo_codes"."rules" = $8`,
+			`"promo_codes"."usage" = $9`,
+		},
+		filters,
+	)
+	assert.Equal(
+		[]interface{}{
+			12.5,
+			"123456789012345678901234567890",
+			"it's",
+			"2024-01-01T00:00:00",
+			true,
+			int64(946271346521194497),
+			1.5,
+			map[string]interface{}{"type": "percent"},
+			int64(3),
+		},
+		args,
+	)
+}
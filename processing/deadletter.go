@@ -0,0 +1,301 @@
+// Package processing provide all requirements to process change data capture
+package processing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	kafkago "github.com/segmentio/kafka-go"
+)
+
+const (
+	// deadLetterSuffix is appended to the topic name when
+	// the dead letter topic name is not provided
+	deadLetterSuffix string = "_dlq"
+	// deadLetterHeaderPrefix is the prefix of all headers added by synker
+	// to messages sent into the dead letter topic
+	deadLetterHeaderPrefix string = "synker_"
+	// deadLetterReplayHeader is added to replayed messages so a message
+	// failing again is parked in the dead letter topic instead of being replayed forever
+	deadLetterReplayHeader string = deadLetterHeaderPrefix + "replay_attempt"
+	// defaultReplayIdleTimeout is the maximum time to wait for a message
+	// of the dead letter topic while its end offsets are not reached
+	defaultReplayIdleTimeout time.Duration = time.Minute
+)
+
+// ReplayOptions hold the requirements to replay the dead letter topic of a schema
+type ReplayOptions struct {
+	// Schema name of the dead letter topic to replay
+	Schema string
+	// IdleTimeout is the maximum time to wait for a message before failing
+	// while the end offsets are not reached. Default to 1m
+	IdleTimeout time.Duration
+}
+
+// deadLetterTopic return the dead letter topic name of the schema
+func (c *Validate) deadLetterTopic(index int) string {
+	name := strings.TrimSpace(c.validatedSchemas.Schemas[index].Topic.DeadLetter.Name)
+	if name != "" {
+		return name
+	}
+	return strings.TrimSpace(c.validatedSchemas.Schemas[index].Topic.Name) + deadLetterSuffix
+}
+
+// replayGroup return the kafka consumer group replaying the dead letter topic of the schema
+func replayGroup(schema string) string {
+	return consumerGroup(schema) + "_dlq_replay"
+}
+
+// deadLetterHeaders return the original headers of the message
+// with the details of the failure
+func deadLetterHeaders(schema string, m kafkago.Message, reason error) (headers []kafkago.Header) {
+	headers = append(headers, m.Headers...)
+	headers = append(
+		headers,
+		kafkago.Header{Key: deadLetterHeaderPrefix + "schema", Value: []byte(schema)},
+		kafkago.Header{Key: deadLetterHeaderPrefix + "error", Value: []byte(reason.Error())},
+		kafkago.Header{Key: deadLetterHeaderPrefix + "topic", Value: []byte(m.Topic)},
+		kafkago.Header{Key: deadLetterHeaderPrefix + "partition", Value: []byte(strconv.Itoa(m.Partition))},
+		kafkago.Header{Key: deadLetterHeaderPrefix + "offset", Value: []byte(strconv.FormatInt(m.Offset, 10))},
+		kafkago.Header{Key: deadLetterHeaderPrefix + "timestamp", Value: []byte(time.Now().UTC().Format(time.RFC3339Nano))},
+	)
+	return
+}
+
+// replayHeaders return the headers of the message without
+// the ones added by synker when sent into the dead letter topic
+func replayHeaders(headers []kafkago.Header) (z []kafkago.Header) {
+	for _, header := range headers {
+		if !strings.HasPrefix(header.Key, deadLetterHeaderPrefix) {
+			z = append(z, header)
+		}
+	}
+	return
+}
+
+// replayAttempted return true when the message has already been replayed
+// and failed again
+func replayAttempted(headers []kafkago.Header) bool {
+	for _, header := range headers {
+		if header.Key == deadLetterReplayHeader {
+			return true
+		}
+	}
+	return false
+}
+
+// pendingPartitions return the end offset of the partitions
+// that still have messages to replay from the offset committed by the replay group
+// or from the first offset when nothing has been committed yet
+func pendingPartitions(first, last, committed map[int]int64) map[int]int64 {
+	z := make(map[int]int64)
+	for partition, end := range last {
+		start := first[partition]
+		if offset, ok := committed[partition]; ok && offset > start {
+			start = offset
+		}
+		if start < end {
+			z[partition] = end
+		}
+	}
+	return z
+}
+
+// deadLetterPending permit to retrieve the end offset of the partitions of the dead letter topic
+// that have messages to replay. Messages written after are not replayed
+// so messages failing again during the replay are not fetched again
+func (c *Validate) deadLetterPending(ctx context.Context, topic, group string) (pending map[int]int64, err error) {
+	client, err := c.kAdmin()
+	if err != nil {
+		return
+	}
+
+	metadata, err := client.Metadata(ctx, &kafkago.MetadataRequest{Topics: []string{topic}})
+	if err != nil {
+		return
+	}
+	var (
+		partitions []int
+		requests   []kafkago.OffsetRequest
+	)
+	for _, t := range metadata.Topics {
+		if t.Error != nil {
+			return nil, fmt.Errorf("Fail to describe topic %s: %w", topic, t.Error)
+		}
+		for _, partition := range t.Partitions {
+			partitions = append(partitions, partition.ID)
+			requests = append(requests, kafkago.FirstOffsetOf(partition.ID), kafkago.LastOffsetOf(partition.ID))
+		}
+	}
+
+	offsets, err := client.ListOffsets(ctx, &kafkago.ListOffsetsRequest{Topics: map[string][]kafkago.OffsetRequest{topic: requests}})
+	if err != nil {
+		return
+	}
+	first, last := make(map[int]int64), make(map[int]int64)
+	for _, v := range offsets.Topics[topic] {
+		if v.Error != nil {
+			return nil, fmt.Errorf("Fail to list offsets of topic %s on partition %d: %w", topic, v.Partition, v.Error)
+		}
+		first[v.Partition], last[v.Partition] = v.FirstOffset, v.LastOffset
+	}
+
+	fetched, err := client.OffsetFetch(ctx, &kafkago.OffsetFetchRequest{GroupID: group, Topics: map[string][]int{topic: partitions}})
+	if err != nil {
+		return
+	}
+	if fetched.Error != nil {
+		return nil, fmt.Errorf("Fail to fetch offsets of consumer group %s: %w", group, fetched.Error)
+	}
+	committed := make(map[int]int64)
+	for _, v := range fetched.Topics[topic] {
+		if v.Error != nil {
+			return nil, fmt.Errorf("Fail to fetch offsets of consumer group %s on partition %d: %w", group, v.Partition, v.Error)
+		}
+		if v.CommittedOffset >= 0 {
+			committed[v.Partition] = v.CommittedOffset
+		}
+	}
+	return pendingPartitions(first, last, committed), nil
+}
+
+// deadLetter permit to send the message that cannot be processed
+// into the dead letter topic of the schema
+func (c *Validate) deadLetter(ctx context.Context, w *kafkago.Writer, index int, m kafkago.Message, reason error) (err error) {
+	c.increaseMetrics("kafka", m.Topic, "dead_letter")
+	err = w.WriteMessages(
+		ctx,
+		kafkago.Message{
+			Key:     m.Key,
+			Value:   m.Value,
+			Headers: deadLetterHeaders(c.validatedSchemas.Schemas[index].Name, m, reason),
+		},
+	)
+	if err != nil {
+		return
+	}
+	c.Logger.Warn().Err(reason).Msgf("Kafka message from topic %s on partition %d and offset %d has been sent into dead letter topic %s", m.Topic, m.Partition, m.Offset, w.Topic)
+	return
+}
+
+// ReplayDeadLetter permit to push back messages of the dead letter topic
+// into the topic of the provided schema so they are processed again.
+// Only the messages present when the replay starts are replayed
+// and messages that already failed after a replay are parked in the dead letter topic.
+// The replay fails when no message is received within the idle timeout before reaching the end offsets
+func (c *Validate) ReplayDeadLetter(options ReplayOptions) {
+	defer c.closeClients()
+
+	schema := options.Schema
+	idleTimeout := options.IdleTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = defaultReplayIdleTimeout
+	}
+
+	index := -1
+	for k, v := range c.validatedSchemas.Schemas {
+		if v.Name == schema {
+			index = k
+			break
+		}
+	}
+	if index == -1 {
+		c.Logger.Fatal().Err(fmt.Errorf("Schema %s not found", schema)).Msgf("Fail to replay dead letter topic of schema %s", schema)
+		return
+	}
+
+	topic := c.validatedSchemas.Schemas[index].Topic.Name
+	dlq := c.deadLetterTopic(index)
+
+	brokers, err := c.kBrokers()
+	if err != nil {
+		c.Logger.Fatal().Err(err).Msg("Fail to retrieve kafka brokers")
+		return
+	}
+
+	dialer, err := c.kDialer()
+	if err != nil {
+		c.Logger.Fatal().Err(err).Msg("Fail to create kafka dialer")
+		return
+	}
+
+	ctx := context.Background()
+	pending, err := c.deadLetterPending(ctx, dlq, replayGroup(schema))
+	if err != nil {
+		c.Logger.Fatal().Err(err).Msgf("Fail to retrieve end offsets of dead letter topic %s", dlq)
+		return
+	}
+
+	r := kafkago.NewReader(kafkago.ReaderConfig{
+		Brokers:  brokers,
+		Dialer:   dialer,
+		Topic:    dlq,
+		GroupID:  replayGroup(schema),
+		MinBytes: 1,
+		MaxBytes: 10e6,
+	})
+	defer r.Close()
+
+	w, err := c.kWriter(brokers, topic)
+	if err != nil {
+		c.Logger.Fatal().Err(err).Msgf("Fail to create kafka writer on topic %s", topic)
+		return
+	}
+	defer w.Close()
+
+	var count, parked int
+	for len(pending) > 0 {
+		fetchCtx, cancel := context.WithTimeout(ctx, idleTimeout)
+		m, err := r.FetchMessage(fetchCtx)
+		cancel()
+		if err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				err = fmt.Errorf("No message received within %s while the end offsets of partitions %v are not reached, %d messages replayed and %d parked", idleTimeout, pending, count, parked)
+			}
+			c.Logger.Fatal().Err(err).Msgf("Fail to fetch kafka message from dead letter topic %s", dlq)
+			return
+		}
+
+		// messages written after the replay started are left for the next replay
+		end, ok := pending[m.Partition]
+		if !ok || m.Offset >= end {
+			delete(pending, m.Partition)
+			continue
+		}
+
+		if replayAttempted(m.Headers) {
+			parked++
+			c.Logger.Warn().Msgf("Kafka message from dead letter topic %s on partition %d and offset %d already failed after a replay and is parked", dlq, m.Partition, m.Offset)
+		} else {
+			err = w.WriteMessages(
+				ctx,
+				kafkago.Message{
+					Key:   m.Key,
+					Value: m.Value,
+					Headers: append(
+						replayHeaders(m.Headers),
+						kafkago.Header{Key: deadLetterReplayHeader, Value: []byte(time.Now().UTC().Format(time.RFC3339Nano))},
+					),
+				},
+			)
+			if err != nil {
+				c.Logger.Fatal().Err(err).Msgf("Fail to replay kafka message from dead letter topic %s on partition %d and offset %d", dlq, m.Partition, m.Offset)
+				return
+			}
+			count++
+		}
+
+		if err = r.CommitMessages(ctx, m); err != nil {
+			c.Logger.Fatal().Err(err).Msgf("Fail to commit kafka message from dead letter topic %s on partition %d and offset %d", dlq, m.Partition, m.Offset)
+			return
+		}
+		if m.Offset+1 >= end {
+			delete(pending, m.Partition)
+		}
+	}
+	c.Logger.Info().Msgf("%d messages replayed from dead letter topic %s into topic %s, %d messages parked", count, dlq, topic, parked)
+}
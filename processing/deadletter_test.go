@@ -0,0 +1,76 @@
+// Package processing provide all requirements to process change data capture
+package processing
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/Lord-Y/synker/logger"
+	kafkago "github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeadLetterTopic(t *testing.T) {
+	assert := assert.New(t)
+	var c Validate
+	c.Logger = logger.NewLogger()
+	c.ConfigDir = "examples/schemas"
+	c.ParseAndValidateConfig()
+
+	assert.Equal("movr.public.promo_codes_dlq", c.deadLetterTopic(0))
+	c.validatedSchemas.Schemas[0].Topic.DeadLetter.Name = "promo_codes_errors"
+	assert.Equal("promo_codes_errors", c.deadLetterTopic(0))
+}
+
+func TestDeadLetterHeaders(t *testing.T) {
+	assert := assert.New(t)
+
+	m := kafkago.Message{
+		Topic:     "movr.public.promo_codes",
+		Partition: 2,
+		Offset:    42,
+		Headers: []kafkago.Header{
+			{
+				Key:   "origin",
+				Value: []byte("cockroach"),
+			},
+		},
+	}
+
+	headers := deadLetterHeaders("promo_codes", m, fmt.Errorf("unexpected end of JSON input"))
+	z := make(map[string]string)
+	for _, header := range headers {
+		z[header.Key] = string(header.Value)
+	}
+	assert.Equal("cockroach", z["origin"])
+	assert.Equal("promo_codes", z["synker_schema"])
+	assert.Equal("unexpected end of JSON input", z["synker_error"])
+	assert.Equal("movr.public.promo_codes", z["synker_topic"])
+	assert.Equal("2", z["synker_partition"])
+	assert.Equal("42", z["synker_offset"])
+
+	assert.Equal(m.Headers, replayHeaders(headers))
+}
+
+func TestReplayAttempted(t *testing.T) {
+	assert := assert.New(t)
+
+	m := kafkago.Message{Topic: "movr.public.promo_codes"}
+	headers := deadLetterHeaders("promo_codes", m, fmt.Errorf("unexpected end of JSON input"))
+	assert.False(replayAttempted(headers))
+
+	// the replay header is kept when the replayed message is sent again into the dead letter topic
+	m.Headers = append(replayHeaders(headers), kafkago.Header{Key: deadLetterReplayHeader, Value: []byte("2026-10-18T00:00:00Z")})
+	assert.True(replayAttempted(deadLetterHeaders("promo_codes", m, fmt.Errorf("unexpected end of JSON input"))))
+}
+
+func TestPendingPartitions(t *testing.T) {
+	assert := assert.New(t)
+
+	first := map[int]int64{0: 0, 1: 10, 2: 0, 3: 0}
+	last := map[int]int64{0: 5, 1: 20, 2: 0, 3: 8}
+	committed := map[int]int64{0: 2, 1: 4, 3: 8}
+
+	assert.Equal(map[int]int64{0: 5, 1: 20}, pendingPartitions(first, last, committed))
+	assert.Equal(map[int]int64{0: 5, 1: 20, 3: 8}, pendingPartitions(first, last, nil))
+}
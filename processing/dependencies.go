@@ -0,0 +1,229 @@
+// Package processing provide all requirements to process change data capture
+package processing
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	kafkago "github.com/segmentio/kafka-go"
+)
+
+const (
+	// defaultMaxFanOut is the maximum number of documents refreshed by a change
+	defaultMaxFanOut int = 1000
+)
+
+// dependent is a schema whose documents must be refreshed
+// when the table of another schema changes
+type dependent struct {
+	// index of the dependent schema
+	index int
+	// dependency declared by the dependent schema
+	dependency sqlDependency
+}
+
+// validateDependencies permit to check dependencies requirements
+func validateDependencies(s configSchema) (err error) {
+	if len(s.SQL.Dependencies) == 0 {
+		return
+	}
+	if strings.TrimSpace(s.SQL.Query) == "" {
+		return fmt.Errorf("Dependencies require an advanced SQL query")
+	}
+	for _, dependency := range s.SQL.Dependencies {
+		if strings.TrimSpace(dependency.Table) == "" || strings.TrimSpace(dependency.Column) == "" || strings.TrimSpace(dependency.JoinColumn) == "" {
+			return fmt.Errorf("Dependency table, column and joinColumn are required")
+		}
+		if dependency.MaxFanOut < 0 {
+			return fmt.Errorf("Dependency maxFanOut of table %s must be positive", dependency.Table)
+		}
+		if tableMatch(s.ChangeFeed.FullTableName, dependency.Table) {
+			return fmt.Errorf("Dependency table %s cannot be the table of the schema", dependency.Table)
+		}
+	}
+	return
+}
+
+// tableMatch return true when the table is the full table name
+// or its last part
+func tableMatch(fullTableName, table string) bool {
+	t := strings.Split(strings.TrimSpace(fullTableName), ".")
+	table = strings.TrimSpace(table)
+	return table == strings.TrimSpace(fullTableName) || table == t[len(t)-1]
+}
+
+// dependents return the schemas depending on the table of the schema
+func (c *Validate) dependents(index int) (z []dependent) {
+	fullTableName := c.validatedSchemas.Schemas[index].ChangeFeed.FullTableName
+	for k, v := range c.validatedSchemas.Schemas {
+		if k == index {
+			continue
+		}
+		for _, dependency := range v.SQL.Dependencies {
+			if tableMatch(fullTableName, dependency.Table) {
+				z = append(z, dependent{index: k, dependency: dependency})
+			}
+		}
+	}
+	return
+}
+
+// tableIdentifier return the sanitized identifier of the full table name
+func tableIdentifier(fullTableName string) string {
+	return pgx.Identifier(strings.Split(strings.TrimSpace(fullTableName), ".")).Sanitize()
+}
+
+// primaryKey return the primary key columns of the table in order
+func (c *Validate) primaryKey(ctx context.Context, fullTableName string) (columns []string, err error) {
+	if z, ok := c.primaryKeys.Load(fullTableName); ok {
+		return z.([]string), nil
+	}
+
+	t := strings.Split(strings.TrimSpace(fullTableName), ".")
+	catalog := "information_schema"
+	schemaName := "public"
+	switch len(t) {
+	case 3:
+		catalog = pgx.Identifier{t[0], "information_schema"}.Sanitize()
+		schemaName = t[1]
+	case 2:
+		schemaName = t[0]
+	}
+
+	db, err := c.pgPool(ctx)
+	if err != nil {
+		return
+	}
+
+	q := fmt.Sprintf(
+		"SELECT kcu.column_name FROM %[1]s.key_column_usage AS kcu JOIN %[1]s.table_constraints AS tc ON tc.constraint_name = kcu.constraint_name AND tc.table_schema = kcu.table_schema AND tc.table_name = kcu.table_name WHERE tc.constraint_type = 'PRIMARY KEY' AND kcu.table_schema = $1 AND kcu.table_name = $2 ORDER BY kcu.ordinal_position",
+		catalog,
+	)
+	rows, err := db.Query(ctx, q, schemaName, t[len(t)-1])
+	if err != nil {
+		return
+	}
+	columns, err = pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("Primary key of table %s not found", fullTableName)
+	}
+	c.primaryKeys.Store(fullTableName, columns)
+	return
+}
+
+// fanOut permit to refresh the documents of dependent schemas
+// referencing the row of the kafka message
+func (c *Validate) fanOut(ctx context.Context, index int, batch *bulkBatch, m kafkago.Message, event changeEvent) (err error) {
+	for _, d := range c.dependents(index) {
+		// deleted rows are referenced by their previous image
+		image := event.After
+		if image == nil {
+			image = event.Before
+		}
+		if image == nil {
+			continue
+		}
+		v, ok := image[d.dependency.Column]
+		if !ok || v == nil {
+			continue
+		}
+
+		if err = c.refreshDependent(ctx, d, batch, m, v, event.Updated); err != nil {
+			return fmt.Errorf("Fail to refresh documents of schema %s: %w", c.validatedSchemas.Schemas[d.index].Name, err)
+		}
+	}
+	return
+}
+
+// refreshDependent permit to re-run the advanced query of the dependent schema
+// for every row referencing the provided value and index the result
+// with the updated timestamp of the change so older refreshes never overwrite newer changes
+func (c *Validate) refreshDependent(ctx context.Context, d dependent, batch *bulkBatch, m kafkago.Message, v interface{}, updated interface{}) (err error) {
+	s := c.validatedSchemas.Schemas[d.index]
+	maxFanOut := d.dependency.MaxFanOut
+	if maxFanOut == 0 {
+		maxFanOut = defaultMaxFanOut
+	}
+
+	primaryKey, err := c.primaryKey(ctx, s.ChangeFeed.FullTableName)
+	if err != nil {
+		return
+	}
+
+	db, err := c.pgPool(ctx)
+	if err != nil {
+		return
+	}
+
+	values := []interface{}{queryArg(v)}
+	q := fmt.Sprintf(
+		"SELECT * FROM %s WHERE %s = $1 LIMIT %d",
+		tableIdentifier(s.ChangeFeed.FullTableName),
+		pgx.Identifier{d.dependency.JoinColumn}.Sanitize(),
+		maxFanOut+1,
+	)
+	c.Logger.Debug().Msgf("Executing SQL query `%s` with args %v", q, values)
+	rows, err := db.Query(ctx, q, values...)
+	if err != nil {
+		return
+	}
+	parents, err := pgx.CollectRows(rows, pgx.RowToMap)
+	if err != nil {
+		return
+	}
+
+	if len(parents) > maxFanOut {
+		c.increaseMetrics("fanout", s.Topic.Name, "truncated")
+		c.Logger.Warn().Msgf("More than %d documents of schema %s reference %s = %v, only the first %d are refreshed", maxFanOut, s.Name, d.dependency.JoinColumn, v, maxFanOut)
+		parents = parents[:maxFanOut]
+	}
+
+	for _, parent := range parents {
+		row := make(map[string]interface{}, len(parent))
+		for column, value := range parent {
+			row[column], err = queryValue(value)
+			if err != nil {
+				return
+			}
+		}
+
+		// documents of rows not matching the filter have been deleted by their own changes
+		match, err := c.matchFilter(d.index, row)
+		if err != nil {
+			return err
+		}
+		if !match {
+			continue
+		}
+
+		// the row is sent as if it was a changefeed message of the dependent schema
+		if err = c.batchMessage(ctx, d.index, batch, m, dependentEvent(row, primaryKey, updated)); err != nil {
+			return err
+		}
+		c.increaseMetrics("fanout", s.Topic.Name, "refreshed")
+	}
+	c.Logger.Debug().Msgf("%d documents of schema %s refreshed from topic %s on partition %d and offset %d", len(parents), s.Name, m.Topic, m.Partition, m.Offset)
+	return
+}
+
+// dependentEvent return the change event refreshing the row of a dependent schema.
+// Only the primary key identifies the row in searches and in the advanced query
+// as other columns read from the table may not compare equal once converted
+func dependentEvent(row map[string]interface{}, primaryKey []string, updated interface{}) changeEvent {
+	event := changeEvent{
+		After:   row,
+		Before:  make(map[string]interface{}, len(primaryKey)),
+		Updated: updated,
+	}
+	for _, column := range primaryKey {
+		event.Key = append(event.Key, row[column])
+		event.Before[column] = row[column]
+	}
+	event.Match = event.Before
+	return event
+}
@@ -0,0 +1,179 @@
+// Package processing provide all requirements to process change data capture
+package processing
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Lord-Y/synker/logger"
+	kafkago "github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateDependencies(t *testing.T) {
+	assert := assert.New(t)
+
+	rides := configSchema{
+		ChangeFeed: changeFeed{FullTableName: "movr.public.rides"},
+		SQL: sql{
+			Query: "SELECT rides.id,vehicles.type FROM rides LEFT JOIN vehicles ON vehicles.id = rides.vehicle_id",
+		},
+	}
+
+	tests := []struct {
+		dependencies []sqlDependency
+		query        string
+		fail         bool
+	}{
+		{},
+		{
+			dependencies: []sqlDependency{
+				{Table: "vehicles", Column: "id", JoinColumn: "vehicle_id"},
+			},
+			query: rides.SQL.Query,
+		},
+		{
+			dependencies: []sqlDependency{
+				{Table: "vehicles", Column: "id", JoinColumn: "vehicle_id"},
+			},
+			fail: true,
+		},
+		{
+			dependencies: []sqlDependency{
+				{Table: "vehicles", Column: "id"},
+			},
+			query: rides.SQL.Query,
+			fail:  true,
+		},
+		{
+			dependencies: []sqlDependency{
+				{Table: "movr.public.rides", Column: "id", JoinColumn: "id"},
+			},
+			query: rides.SQL.Query,
+			fail:  true,
+		},
+	}
+
+	for _, tc := range tests {
+		s := rides
+		s.SQL.Query = tc.query
+		s.SQL.Dependencies = tc.dependencies
+		err := validateDependencies(s)
+		if tc.fail {
+			assert.Error(err)
+		} else {
+			assert.Nil(err)
+		}
+	}
+}
+
+func TestDependents(t *testing.T) {
+	assert := assert.New(t)
+
+	var c Validate
+	c.Logger = logger.NewLogger()
+	c.validatedSchemas.Schemas = []configSchema{
+		{
+			Name:       "vehicles",
+			ChangeFeed: changeFeed{FullTableName: "movr.public.vehicles"},
+		},
+		{
+			Name:       "rides",
+			ChangeFeed: changeFeed{FullTableName: "movr.public.rides"},
+			SQL: sql{
+				Dependencies: []sqlDependency{
+					{Table: "vehicles", Column: "id", JoinColumn: "vehicle_id"},
+					{Table: "movr.public.users", Column: "id", JoinColumn: "rider_id"},
+				},
+			},
+		},
+	}
+
+	z := c.dependents(0)
+	assert.Len(z, 1)
+	assert.Equal(1, z[0].index)
+	assert.Equal("vehicle_id", z[0].dependency.JoinColumn)
+	assert.Len(c.dependents(1), 0)
+}
+
+func TestDependentEvent(t *testing.T) {
+	assert := assert.New(t)
+
+	row := map[string]interface{}{
+		"city":       "paris",
+		"id":         int64(946271346521194497),
+		"revenue":    12.34,
+		"start_time": time.Date(2024, 1, 1, 0, 0, 0, 123456000, time.UTC),
+		"vehicle_id": "v1",
+	}
+	event := dependentEvent(row, []string{"city", "id"}, "1700000000000000000.0000000001")
+	assert.Equal([]interface{}{"paris", int64(946271346521194497)}, event.Key)
+	assert.Equal(row, event.After)
+	assert.Equal(map[string]interface{}{"city": "paris", "id": int64(946271346521194497)}, event.Before)
+	assert.Equal(event.Before, event.Match)
+	assert.Equal("1700000000000000000.0000000001", event.Updated)
+
+	// decimal and time columns are not part of the advanced query filters
+	filters, args := queryFilters("rides", event.Match)
+	assert.Equal([]string{`"rides"."city" = $1`, `"rides"."id" = $2`}, filters)
+	assert.Equal([]interface{}{"paris", int64(946271346521194497)}, args)
+
+	version, ok, err := changeVersion(event.Updated)
+	assert.Nil(err)
+	assert.True(ok)
+	assert.Equal(int64(1700000000000000000), version)
+}
+
+func TestTableIdentifier(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(`"movr"."public"."rides"`, tableIdentifier("movr.public.rides"))
+	assert.Equal(`"rides"`, tableIdentifier("rides"))
+}
+
+func TestProcessMessage(t *testing.T) {
+	assert := assert.New(t)
+
+	// the dependent documents cannot be refreshed without database
+	t.Setenv("SYNKER_PG_URI", "fake://")
+
+	var c Validate
+	c.Logger = logger.NewLogger()
+	c.validatedSchemas.Schemas = []configSchema{
+		{
+			Name:          "vehicles",
+			ChangeFeed:    changeFeed{FullTableName: "movr.public.vehicles", Options: []string{"updated"}},
+			Elasticsearch: elasticsearchSchema{Index: elasticsearchIndex{Name: "vehicles"}},
+		},
+		{
+			Name:       "rides",
+			ChangeFeed: changeFeed{FullTableName: "movr.public.rides"},
+			SQL: sql{
+				Dependencies: []sqlDependency{
+					{Table: "vehicles", Column: "id", JoinColumn: "vehicle_id"},
+				},
+			},
+		},
+	}
+	c.clients.sinks = map[string]sink{sinkElasticsearch: newMemorySink()}
+	c.primaryKeys.Store("movr.public.rides", []string{"id"})
+
+	m := kafkago.Message{
+		Topic: "vehicles",
+		Key:   []byte(`["v1"]`),
+		Value: []byte(`{"after": {"id": "v1", "type": "bike"}, "updated": "1532377312562986715.0000000001"}`),
+	}
+
+	// a failed fan out leaves no request of the message in the batch
+	batch := newBulkBatch(false)
+	assert.Error(c.processMessage(context.Background(), 0, batch, m))
+	assert.Len(batch.requests, 0)
+	assert.Len(batch.pending, 0)
+	assert.Equal(0, batch.size)
+
+	c.validatedSchemas.Schemas = c.validatedSchemas.Schemas[:1]
+	assert.Nil(c.processMessage(context.Background(), 0, batch, m))
+	assert.Len(batch.requests, 1)
+	assert.NotEqual(0, batch.size)
+}
@@ -0,0 +1,102 @@
+// Package processing provide all requirements to process change data capture
+package processing
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+const (
+	// documentIdPrimaryKey use the primary key sent as kafka message key
+	documentIdPrimaryKey string = "primaryKey"
+	// documentIdTemplate use a template built with immutable columns
+	documentIdTemplate string = "template"
+	// documentIdHash use the sha256 of immutable columns
+	documentIdHash string = "hash"
+	// documentIdUUID use a random uuid and search the document on updates
+	documentIdUUID string = "uuid"
+	// documentIdSeparator join primary key values
+	documentIdSeparator string = "/"
+)
+
+// documentIdStrategy return the document id strategy of the schema
+func (c *Validate) documentIdStrategy(index int) string {
+	strategy := strings.TrimSpace(c.validatedSchemas.Schemas[index].Elasticsearch.DocumentId.Strategy)
+	if strategy == "" {
+		return documentIdUUID
+	}
+	return strategy
+}
+
+// validateDocumentId permit to check document id requirements
+func validateDocumentId(documentId documentIdSchema) (err error) {
+	switch strings.TrimSpace(documentId.Strategy) {
+	case documentIdTemplate:
+		if len(templateFields(documentId.Template)) == 0 {
+			return fmt.Errorf("Document id template `%s` must contain at least one column like {id}", documentId.Template)
+		}
+	default:
+		if strings.TrimSpace(documentId.Template) != "" {
+			return fmt.Errorf("Document id template can only be used with strategy %s", documentIdTemplate)
+		}
+	}
+	if len(documentId.Columns) > 0 && strings.TrimSpace(documentId.Strategy) != documentIdHash {
+		return fmt.Errorf("Document id columns can only be used with strategy %s", documentIdHash)
+	}
+	return
+}
+
+// documentId return the elasticsearch document id built from the provided image
+// of the row and the primary key sent as kafka message key
+func (c *Validate) documentId(index int, key []interface{}, image interface{}) (id string, err error) {
+	documentId := c.validatedSchemas.Schemas[index].Elasticsearch.DocumentId
+
+	var fields map[string]interface{}
+	if image != nil {
+		err = mapstructure.Decode(image, &fields)
+		if err != nil {
+			return
+		}
+	}
+
+	switch c.documentIdStrategy(index) {
+	case documentIdPrimaryKey:
+		if len(key) == 0 {
+			return "", fmt.Errorf("Kafka message key is empty")
+		}
+		var values []string
+		for _, v := range key {
+			values = append(values, templateValue(v))
+		}
+		return strings.Join(values, documentIdSeparator), nil
+	case documentIdTemplate:
+		return renderTemplate(documentId.Template, fields)
+	case documentIdHash:
+		values := key
+		if len(documentId.Columns) > 0 {
+			values = nil
+			for _, column := range documentId.Columns {
+				v, ok := fields[column]
+				if !ok {
+					return "", fmt.Errorf("Column %s of document id is missing", column)
+				}
+				values = append(values, v)
+			}
+		}
+		if len(values) == 0 {
+			return "", fmt.Errorf("Kafka message key is empty")
+		}
+		b, err := json.Marshal(values)
+		if err != nil {
+			return "", err
+		}
+		sum := sha256.Sum256(b)
+		return hex.EncodeToString(sum[:]), nil
+	}
+	return "", fmt.Errorf("Document id strategy %s cannot build id from data", c.documentIdStrategy(index))
+}
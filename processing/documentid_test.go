@@ -0,0 +1,130 @@
+// Package processing provide all requirements to process change data capture
+package processing
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Lord-Y/synker/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateDocumentId(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		documentId documentIdSchema
+		fail       bool
+	}{
+		{
+			documentId: documentIdSchema{},
+		},
+		{
+			documentId: documentIdSchema{Strategy: documentIdPrimaryKey},
+		},
+		{
+			documentId: documentIdSchema{Strategy: documentIdTemplate, Template: "{city}-{id}"},
+		},
+		{
+			documentId: documentIdSchema{Strategy: documentIdTemplate},
+			fail:       true,
+		},
+		{
+			documentId: documentIdSchema{Strategy: documentIdPrimaryKey, Template: "{id}"},
+			fail:       true,
+		},
+		{
+			documentId: documentIdSchema{Strategy: documentIdHash, Columns: []string{"city", "id"}},
+		},
+		{
+			documentId: documentIdSchema{Strategy: documentIdUUID, Columns: []string{"id"}},
+			fail:       true,
+		},
+	}
+
+	for _, tc := range tests {
+		err := validateDocumentId(tc.documentId)
+		if tc.fail {
+			assert.Error(err)
+		} else {
+			assert.Nil(err)
+		}
+	}
+}
+
+func TestDocumentId(t *testing.T) {
+	assert := assert.New(t)
+
+	var c Validate
+	c.Logger = logger.NewLogger()
+
+	key := []interface{}{"paris", json.Number("9223372036854775807")}
+	image := map[string]interface{}{
+		"city": "paris",
+		"id":   float64(42),
+	}
+
+	tests := []struct {
+		documentId documentIdSchema
+		key        []interface{}
+		expected   string
+		fail       bool
+	}{
+		{
+			documentId: documentIdSchema{Strategy: documentIdPrimaryKey},
+			key:        key,
+			expected:   "paris/9223372036854775807",
+		},
+		{
+			documentId: documentIdSchema{Strategy: documentIdPrimaryKey},
+			fail:       true,
+		},
+		{
+			documentId: documentIdSchema{Strategy: documentIdTemplate, Template: "{city}-{id}"},
+			key:        key,
+			expected:   "paris-42",
+		},
+		{
+			documentId: documentIdSchema{Strategy: documentIdTemplate, Template: "{city}-{name}"},
+			key:        key,
+			fail:       true,
+		},
+		{
+			documentId: documentIdSchema{Strategy: documentIdHash, Columns: []string{"missing"}},
+			key:        key,
+			fail:       true,
+		},
+		{
+			documentId: documentIdSchema{},
+			key:        key,
+			fail:       true,
+		},
+	}
+
+	for _, tc := range tests {
+		c.validatedSchemas.Schemas = []configSchema{
+			{
+				Elasticsearch: elasticsearchSchema{DocumentId: tc.documentId},
+			},
+		}
+		z, err := c.documentId(0, tc.key, image)
+		if tc.fail {
+			assert.Error(err)
+		} else {
+			assert.Nil(err)
+			assert.Equal(tc.expected, z)
+		}
+	}
+
+	c.validatedSchemas.Schemas = []configSchema{
+		{
+			Elasticsearch: elasticsearchSchema{DocumentId: documentIdSchema{Strategy: documentIdHash}},
+		},
+	}
+	first, err := c.documentId(0, key, image)
+	assert.Nil(err)
+	assert.Len(first, 64)
+	second, err := c.documentId(0, key, map[string]interface{}{"city": "lyon"})
+	assert.Nil(err)
+	assert.Equal(first, second)
+}
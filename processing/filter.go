@@ -0,0 +1,123 @@
+// Package processing provide all requirements to process change data capture
+package processing
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/Knetic/govaluate"
+	"github.com/nqd/flat"
+)
+
+// filterParameters expose the columns of the row to the filter expression.
+// Missing columns are null
+type filterParameters map[string]interface{}
+
+// Get implements govaluate.Parameters
+func (p filterParameters) Get(name string) (interface{}, error) {
+	return p[name], nil
+}
+
+// compileFilter permit to parse the filter expression
+func compileFilter(expression string) (*govaluate.EvaluableExpression, error) {
+	z, err := govaluate.NewEvaluableExpression(expression)
+	if err != nil {
+		return nil, fmt.Errorf("Fail to parse filter `%s`: %w", expression, err)
+	}
+	return z, nil
+}
+
+// validateFilter permit to check the filter expression
+func validateFilter(expression string) (err error) {
+	if strings.TrimSpace(expression) == "" {
+		return
+	}
+	_, err = compileFilter(expression)
+	return
+}
+
+// filterExpression return the compiled filter expression of the schema
+// or nil when the schema has no filter
+func (c *Validate) filterExpression(index int) (*govaluate.EvaluableExpression, error) {
+	expression := strings.TrimSpace(c.validatedSchemas.Schemas[index].Filter)
+	if expression == "" {
+		return nil, nil
+	}
+	if z, ok := c.filters.Load(index); ok {
+		return z.(*govaluate.EvaluableExpression), nil
+	}
+	z, err := compileFilter(expression)
+	if err != nil {
+		return nil, err
+	}
+	c.filters.Store(index, z)
+	return z, nil
+}
+
+// matchFilter return true when the provided image of the row
+// matches the filter of the schema
+func (c *Validate) matchFilter(index int, row map[string]interface{}) (bool, error) {
+	expression, err := c.filterExpression(index)
+	if err != nil {
+		return false, err
+	}
+	if expression == nil {
+		return true, nil
+	}
+	if row == nil {
+		return false, nil
+	}
+
+	// nested fields are available like [rules.type]
+	flatten, err := flat.Flatten(row, nil)
+	if err != nil {
+		return false, err
+	}
+	parameters := filterParameters(flatten)
+	for k, v := range row {
+		parameters[k] = v
+	}
+	// json numbers are compared as numbers
+	for k, v := range parameters {
+		if n, ok := v.(json.Number); ok {
+			parameters[k] = numberValue(n)
+		}
+	}
+
+	result, err := expression.Eval(parameters)
+	if err != nil {
+		return false, fmt.Errorf("Fail to evaluate filter `%s`: %w", expression.String(), err)
+	}
+	match, ok := result.(bool)
+	if !ok {
+		return false, fmt.Errorf("Filter `%s` must return a boolean, got %v", expression.String(), result)
+	}
+	return match, nil
+}
+
+// applyFilter return the change event to process according to the filter
+// of the schema. A row that stops matching the filter is turned into a delete
+// and skip is true when the row never matched
+func (c *Validate) applyFilter(index int, event changeEvent) (z changeEvent, skip bool, err error) {
+	if event.After == nil {
+		return event, false, nil
+	}
+
+	match, err := c.matchFilter(index, event.After)
+	if err != nil || match {
+		return event, false, err
+	}
+
+	matched, err := c.matchFilter(index, event.Before)
+	if err != nil {
+		return
+	}
+	if !matched {
+		return event, true, nil
+	}
+
+	z = event
+	z.After = nil
+	return z, false, nil
+}
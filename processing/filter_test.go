@@ -0,0 +1,83 @@
+// Package processing provide all requirements to process change data capture
+package processing
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Lord-Y/synker/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateFilter(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(validateFilter(""))
+	assert.Nil(validateFilter("status == 'active' && city IN ('paris', 'lyon')"))
+	assert.Nil(validateFilter("[rules.type] == 'percent'"))
+	assert.Error(validateFilter("status == "))
+	assert.Error(validateFilter("(status == 'active'"))
+}
+
+func TestApplyFilter(t *testing.T) {
+	assert := assert.New(t)
+
+	var c Validate
+	c.Logger = logger.NewLogger()
+	c.validatedSchemas.Schemas = []configSchema{
+		{
+			Filter: "status == 'active' && [rules.type] == 'percent'",
+		},
+	}
+
+	active := map[string]interface{}{"status": "active", "rules": map[string]interface{}{"type": "percent"}}
+	inactive := map[string]interface{}{"status": "inactive", "rules": map[string]interface{}{"type": "percent"}}
+
+	tests := []struct {
+		event    changeEvent
+		skip     bool
+		toDelete bool
+	}{
+		{
+			event: changeEvent{After: active},
+		},
+		{
+			event: changeEvent{After: inactive},
+			skip:  true,
+		},
+		{
+			event: changeEvent{After: inactive, Before: inactive},
+			skip:  true,
+		},
+		{
+			event:    changeEvent{After: inactive, Before: active, Updated: "1.0"},
+			toDelete: true,
+		},
+		{
+			event: changeEvent{Before: inactive},
+		},
+	}
+
+	for _, tc := range tests {
+		z, skip, err := c.applyFilter(0, tc.event)
+		assert.Nil(err)
+		assert.Equal(tc.skip, skip)
+		if tc.toDelete {
+			assert.Nil(z.After)
+			assert.Equal(tc.event.Before, z.Before)
+			assert.Equal(tc.event.Updated, z.Updated)
+		}
+	}
+
+	// json numbers are compared as numbers
+	c.validatedSchemas.Schemas[0].Filter = "amount > 10 && [rules.min] >= 1"
+	c.filters.Delete(0)
+	_, skip, err := c.applyFilter(0, changeEvent{After: map[string]interface{}{"amount": json.Number("12.5"), "rules": map[string]interface{}{"min": json.Number("1")}}})
+	assert.Nil(err)
+	assert.Equal(false, skip)
+
+	c.validatedSchemas.Schemas[0].Filter = "status"
+	c.filters.Delete(0)
+	_, _, err = c.applyFilter(0, changeEvent{After: active})
+	assert.Error(err)
+}
@@ -0,0 +1,283 @@
+// Package processing provide all requirements to process change data capture
+package processing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	// formatCockroach is the json format of cockroach changefeeds
+	formatCockroach string = "cockroach"
+	// formatCockroachAvro is the avro format of cockroach changefeeds
+	// created with the confluent_schema_registry option
+	formatCockroachAvro string = "cockroach-avro"
+	// formatDebeziumJSON is the debezium json format with schema and payload
+	formatDebeziumJSON string = "debezium-json"
+	// formatDebeziumJSONSchemaless is the debezium json format without schema
+	formatDebeziumJSONSchemaless string = "debezium-json-schemaless"
+)
+
+// changeEvent is the change of a row decoded from a kafka message
+// whatever the format of the source
+type changeEvent struct {
+	// Key hold the primary key values of the row
+	Key []interface{}
+	// Before is the previous image of the row when provided by the source
+	Before map[string]interface{}
+	// After is the new image of the row. It is nil when the row is deleted
+	After map[string]interface{}
+	// Updated is the hybrid logical clock timestamp of the change
+	// provided by cockroach changefeeds with the updated option
+	Updated interface{}
+	// Match hold the columns identifying the row in the advanced SQL query.
+	// Default to After
+	Match map[string]interface{}
+}
+
+// changeDecoder decode the key and the value of a kafka message into a change event.
+// skip is true when the message does not hold any change like tombstones
+type changeDecoder func(key, value []byte) (event changeEvent, skip bool, err error)
+
+// changeDecoders hold the decoder of each json format
+var changeDecoders = map[string]changeDecoder{
+	formatCockroach:              decodeCockroach,
+	formatDebeziumJSON:           decodeDebezium(true),
+	formatDebeziumJSONSchemaless: decodeDebezium(false),
+}
+
+// changeDecoder return the decoder of the format.
+// The provided context cancels the requests made while decoding like schema registry fetches
+func (c *Validate) changeDecoder(ctx context.Context, format string) (changeDecoder, error) {
+	if format == formatCockroachAvro {
+		registry, err := c.schemaRegistry()
+		if err != nil {
+			return nil, err
+		}
+		return decodeAvro(ctx, registry), nil
+	}
+	decoder, ok := changeDecoders[format]
+	if !ok {
+		return nil, fmt.Errorf("Format %s is not supported", format)
+	}
+	return decoder, nil
+}
+
+// cockroachFormat return true when messages are published by cockroach changefeeds
+func cockroachFormat(format string) bool {
+	format = strings.TrimSpace(format)
+	return format == "" || format == formatCockroach || format == formatCockroachAvro
+}
+
+// messageFormat return the format of kafka messages of the schema
+func (c *Validate) messageFormat(index int) string {
+	format := strings.TrimSpace(c.validatedSchemas.Schemas[index].Format)
+	if format == "" {
+		return formatCockroach
+	}
+	return format
+}
+
+// validateFormat permit to check that the features of the schema
+// are supported by its format
+func validateFormat(s configSchema) error {
+	format := strings.TrimSpace(s.Format)
+	if cockroachFormat(format) {
+		if len(s.ChangeFeed.Options) == 0 {
+			return fmt.Errorf("ChangeFeed options are required with cockroach formats")
+		}
+		return nil
+	}
+	// advanced queries and dependencies are executed against cockroach
+	if strings.TrimSpace(s.SQL.Query) != "" || len(s.SQL.Dependencies) > 0 {
+		return fmt.Errorf("SQL queries are not supported with format %s", format)
+	}
+	return nil
+}
+
+// decodeCockroach decode messages of cockroach changefeeds.
+// The key is a json array and the value holds after, before and updated fields
+func decodeCockroach(key, value []byte) (z changeEvent, skip bool, err error) {
+	if err = unmarshalNumbers(key, &z.Key); err != nil {
+		return z, false, fmt.Errorf("Fail to unmarshal key: %w", err)
+	}
+
+	var v struct {
+		After   map[string]interface{} `json:"after"`
+		Before  map[string]interface{} `json:"before"`
+		Updated interface{}            `json:"updated"`
+	}
+	if err = unmarshalNumbers(value, &v); err != nil {
+		return z, false, fmt.Errorf("Fail to unmarshal value: %w", err)
+	}
+	z.After, z.Before, z.Updated = v.After, v.Before, v.Updated
+	return
+}
+
+// decodeCockroachQuery decode messages of cockroach changefeeds created with a cdc query.
+// The value holds the projected columns with the operation, the previous image of the row
+// and the __crdb__ metadata
+func decodeCockroachQuery(key, value []byte) (z changeEvent, skip bool, err error) {
+	if err = unmarshalNumbers(key, &z.Key); err != nil {
+		return z, false, fmt.Errorf("Fail to unmarshal key: %w", err)
+	}
+
+	var v map[string]interface{}
+	if err = unmarshalNumbers(value, &v); err != nil {
+		return z, false, fmt.Errorf("Fail to unmarshal value: %w", err)
+	}
+	if v == nil {
+		return z, true, nil
+	}
+	if metadata, ok := v[changeFeedMetadata].(map[string]interface{}); ok {
+		z.Updated = metadata["updated"]
+	}
+	z.Before, _ = v[changeFeedPrev].(map[string]interface{})
+	op, _ := v[changeFeedEventOp].(string)
+	delete(v, changeFeedMetadata)
+	delete(v, changeFeedPrev)
+	delete(v, changeFeedEventOp)
+
+	switch op {
+	case "insert", "update", "upsert":
+		z.After = v
+	case "delete":
+	default:
+		return z, false, fmt.Errorf("Operation `%s` is not supported", op)
+	}
+	return
+}
+
+// decodeDebezium return the decoder of debezium messages.
+// When schema is true, the key and the value are wrapped into schema and payload fields
+func decodeDebezium(schema bool) changeDecoder {
+	return func(key, value []byte) (z changeEvent, skip bool, err error) {
+		// tombstones sent after deletes for log compaction
+		if len(bytes.TrimSpace(value)) == 0 || bytes.Equal(bytes.TrimSpace(value), []byte("null")) {
+			return z, true, nil
+		}
+
+		if schema {
+			if key, err = debeziumPayload(key); err != nil {
+				return z, false, fmt.Errorf("Fail to unmarshal key: %w", err)
+			}
+			if value, err = debeziumPayload(value); err != nil {
+				return z, false, fmt.Errorf("Fail to unmarshal value: %w", err)
+			}
+		}
+		if z.Key, err = orderedValues(key); err != nil {
+			return z, false, fmt.Errorf("Fail to unmarshal key: %w", err)
+		}
+
+		var v struct {
+			Op     string                 `json:"op"`
+			After  map[string]interface{} `json:"after"`
+			Before map[string]interface{} `json:"before"`
+		}
+		if err = unmarshalNumbers(value, &v); err != nil {
+			return z, false, fmt.Errorf("Fail to unmarshal value: %w", err)
+		}
+
+		switch v.Op {
+		// create, snapshot read and update
+		case "c", "r", "u":
+			if v.After == nil {
+				return z, false, fmt.Errorf("Field after is missing on operation %s", v.Op)
+			}
+			z.After, z.Before = v.After, v.Before
+		case "d":
+			if v.Before == nil {
+				return z, false, fmt.Errorf("Field before is missing on operation %s", v.Op)
+			}
+			z.Before = v.Before
+		// truncate and logical decoding messages do not target a row
+		case "t", "m":
+			return z, true, nil
+		default:
+			return z, false, fmt.Errorf("Operation `%s` is not supported", v.Op)
+		}
+		return
+	}
+}
+
+// unmarshalNumbers decode the json data into v with numbers kept as json.Number
+// so integers like unique_rowid() ids above 2^53 do not lose precision
+func unmarshalNumbers(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// numberValue return the json number as int64 when it is an integer,
+// as a numeric string when it is an integer beyond int64 like a DECIMAL
+// and as float64 otherwise
+func numberValue(n json.Number) interface{} {
+	if i, err := n.Int64(); err == nil {
+		return i
+	}
+	if !strings.ContainsAny(n.String(), ".eE") {
+		return n.String()
+	}
+	if f, err := n.Float64(); err == nil {
+		return f
+	}
+	return n.String()
+}
+
+// debeziumPayload return the payload of a message with schema
+func debeziumPayload(data []byte) ([]byte, error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return data, nil
+	}
+	var envelope struct {
+		Payload json.RawMessage `json:"payload"`
+	}
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, err
+	}
+	if envelope.Payload == nil {
+		return nil, fmt.Errorf("Field payload is missing")
+	}
+	return envelope.Payload, nil
+}
+
+// orderedValues return the values of the json object in their order of appearance
+// so the primary key values keep the order of the key columns
+func orderedValues(data []byte) (z []interface{}, err error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || bytes.Equal(data, []byte("null")) {
+		return
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	token, err := decoder.Token()
+	if err != nil {
+		return
+	}
+	// primitive keys like a single id
+	if delim, ok := token.(json.Delim); !ok || delim != '{' {
+		var v interface{}
+		decoder = json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		if err = decoder.Decode(&v); err != nil {
+			return
+		}
+		return []interface{}{v}, nil
+	}
+
+	for decoder.More() {
+		if _, err = decoder.Token(); err != nil {
+			return
+		}
+		var v interface{}
+		if err = decoder.Decode(&v); err != nil {
+			return
+		}
+		z = append(z, v)
+	}
+	return
+}
@@ -0,0 +1,214 @@
+// Package processing provide all requirements to process change data capture
+package processing
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeCockroach(t *testing.T) {
+	assert := assert.New(t)
+
+	z, skip, err := decodeCockroach(
+		[]byte(`["new york", 924663522148958209]`),
+		[]byte(`{"after": {"id": 1, "city": "new york"}, "before": null, "updated": "1532377312562986715.0000000001"}`),
+	)
+	assert.Nil(err)
+	assert.Equal(false, skip)
+	assert.Equal([]interface{}{"new york", json.Number("924663522148958209")}, z.Key)
+	assert.Equal("new york", z.After["city"])
+	assert.Nil(z.Before)
+	assert.Equal("1532377312562986715.0000000001", z.Updated)
+
+	z, _, err = decodeCockroach([]byte(`[1]`), []byte(`{"after": null, "before": {"id": 1}}`))
+	assert.Nil(err)
+	assert.Nil(z.After)
+	assert.Equal(json.Number("1"), z.Before["id"])
+
+	// unique_rowid() ids above 2^53 keep their precision
+	z, _, err = decodeCockroach([]byte(`[946271346521194497]`), []byte(`{"after": {"id": 946271346521194497, "ratio": 1.5}, "before": {"id": 946271346521194497}}`))
+	assert.Nil(err)
+	assert.Equal(json.Number("946271346521194497"), z.After["id"])
+	assert.Equal(json.Number("946271346521194497"), z.Before["id"])
+	assert.Equal(json.Number("1.5"), z.After["ratio"])
+
+	_, _, err = decodeCockroach([]byte(`fake`), []byte(`{}`))
+	assert.Error(err)
+	_, _, err = decodeCockroach([]byte(`[1]`), []byte(`fake`))
+	assert.Error(err)
+}
+
+func TestDecodeCockroachQuery(t *testing.T) {
+	assert := assert.New(t)
+
+	z, skip, err := decodeCockroachQuery(
+		[]byte(`["new york", 924663522148958209]`),
+		[]byte(`{"id": 1, "city": "new york", "__event_op": "update", "__cdc_prev": {"id": 1, "city": "boston"}, "__crdb__": {"updated": "1532377312562986715.0000000001"}}`),
+	)
+	assert.Nil(err)
+	assert.Equal(false, skip)
+	assert.Equal([]interface{}{"new york", json.Number("924663522148958209")}, z.Key)
+	assert.Equal(map[string]interface{}{"id": json.Number("1"), "city": "new york"}, z.After)
+	assert.Equal("boston", z.Before["city"])
+	assert.Equal("1532377312562986715.0000000001", z.Updated)
+
+	z, _, err = decodeCockroachQuery([]byte(`[1]`), []byte(`{"id": 1, "__event_op": "insert", "__cdc_prev": null}`))
+	assert.Nil(err)
+	assert.Equal(json.Number("1"), z.After["id"])
+	assert.Nil(z.Before)
+	assert.Nil(z.Updated)
+
+	z, _, err = decodeCockroachQuery([]byte(`[1]`), []byte(`{"id": null, "__event_op": "delete", "__cdc_prev": {"id": 1}}`))
+	assert.Nil(err)
+	assert.Nil(z.After)
+	assert.Equal(json.Number("1"), z.Before["id"])
+
+	_, skip, err = decodeCockroachQuery([]byte(`[1]`), []byte(`null`))
+	assert.Nil(err)
+	assert.Equal(true, skip)
+
+	_, _, err = decodeCockroachQuery([]byte(`[1]`), []byte(`{"id": 1}`))
+	assert.Error(err)
+	_, _, err = decodeCockroachQuery([]byte(`fake`), []byte(`{}`))
+	assert.Error(err)
+	_, _, err = decodeCockroachQuery([]byte(`[1]`), []byte(`fake`))
+	assert.Error(err)
+}
+
+func TestDecodeDebezium(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		schema bool
+		key    string
+		value  string
+		after  bool
+		before bool
+		skip   bool
+		fail   bool
+	}{
+		{
+			key:   `{"id": 924663522148958209, "city": "paris"}`,
+			value: `{"op": "c", "after": {"id": 924663522148958209, "city": "paris"}, "before": null, "source": {"table": "users"}}`,
+			after: true,
+		},
+		{
+			schema: true,
+			key:    `{"schema": {"type": "struct"}, "payload": {"id": 924663522148958209, "city": "paris"}}`,
+			value:  `{"schema": {"type": "struct"}, "payload": {"op": "u", "after": {"id": 924663522148958209, "city": "paris"}, "before": {"id": 924663522148958209, "city": "lyon"}}}`,
+			after:  true,
+			before: true,
+		},
+		{
+			key:   `{"id": 924663522148958209, "city": "paris"}`,
+			value: `{"op": "r", "after": {"id": 924663522148958209, "city": "paris"}}`,
+			after: true,
+		},
+		{
+			key:    `{"id": 924663522148958209, "city": "paris"}`,
+			value:  `{"op": "d", "after": null, "before": {"id": 924663522148958209, "city": "paris"}}`,
+			before: true,
+		},
+		{
+			key:  `{"id": 924663522148958209, "city": "paris"}`,
+			skip: true,
+		},
+		{
+			schema: true,
+			key:    `{"schema": {"type": "struct"}, "payload": {"id": 924663522148958209, "city": "paris"}}`,
+			value:  `null`,
+			skip:   true,
+		},
+		{
+			value: `{"op": "t"}`,
+			skip:  true,
+		},
+		{
+			key:   `{"id": 924663522148958209, "city": "paris"}`,
+			value: `{"op": "d", "after": null, "before": null}`,
+			fail:  true,
+		},
+		{
+			key:   `{"id": 924663522148958209, "city": "paris"}`,
+			value: `{"op": "x", "after": {"id": 924663522148958209}}`,
+			fail:  true,
+		},
+		{
+			schema: true,
+			key:    `{"id": 924663522148958209, "city": "paris"}`,
+			value:  `{"op": "c", "after": {"id": 924663522148958209, "city": "paris"}}`,
+			fail:   true,
+		},
+	}
+
+	for _, tc := range tests {
+		z, skip, err := decodeDebezium(tc.schema)([]byte(tc.key), []byte(tc.value))
+		if tc.fail {
+			assert.Error(err, tc.value)
+			continue
+		}
+		assert.Nil(err, tc.value)
+		assert.Equal(tc.skip, skip)
+		if tc.skip {
+			continue
+		}
+		assert.Equal([]interface{}{json.Number("924663522148958209"), "paris"}, z.Key)
+		assert.Equal(tc.after, z.After != nil)
+		assert.Equal(tc.before, z.Before != nil)
+		assert.Nil(z.Updated)
+	}
+}
+
+func TestOrderedValues(t *testing.T) {
+	assert := assert.New(t)
+
+	z, err := orderedValues([]byte(`{"b": 1, "a": "x"}`))
+	assert.Nil(err)
+	assert.Equal([]interface{}{json.Number("1"), "x"}, z)
+
+	z, err = orderedValues([]byte(`42`))
+	assert.Nil(err)
+	assert.Equal([]interface{}{json.Number("42")}, z)
+
+	z, err = orderedValues([]byte(`null`))
+	assert.Nil(err)
+	assert.Nil(z)
+
+	_, err = orderedValues([]byte(`{"a": `))
+	assert.Error(err)
+}
+
+func TestValidateFormat(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		schema configSchema
+		fail   bool
+	}{
+		{
+			schema: configSchema{ChangeFeed: changeFeed{Options: []string{"updated"}}},
+		},
+		{
+			schema: configSchema{},
+			fail:   true,
+		},
+		{
+			schema: configSchema{Format: formatDebeziumJSON},
+		},
+		{
+			schema: configSchema{Format: formatDebeziumJSONSchemaless, SQL: sql{Query: "SELECT users.id FROM users"}},
+			fail:   true,
+		},
+	}
+
+	for _, tc := range tests {
+		err := validateFormat(tc.schema)
+		if tc.fail {
+			assert.Error(err)
+		} else {
+			assert.Nil(err)
+		}
+	}
+}
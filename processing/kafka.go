@@ -5,10 +5,12 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
+	"fmt"
 	"net"
 	"os"
+	"sort"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/Lord-Y/synker/commons"
@@ -18,16 +20,10 @@ import (
 	"github.com/segmentio/kafka-go/sasl/scram"
 )
 
-const (
-	timeout time.Duration = 10 * time.Second
-)
-
-// kClient permit to connect to kafka brokers
-func (c *Validate) kClient() (conn *kafka.Conn, err error) {
-	var (
-		dialer    *kafka.Dialer
-		mechanism sasl.Mechanism
-	)
+// newKDialer permit to create the dialer used to connect to kafka brokers
+func (c *Validate) newKDialer() (dialer *kafka.Dialer, err error) {
+	var mechanism sasl.Mechanism
+	timeout := commons.GetKafkaConnectTimeout()
 
 	switch {
 	case commons.GetKafkaCACert() != "" &&
@@ -93,21 +89,157 @@ func (c *Validate) kClient() (conn *kafka.Conn, err error) {
 			DualStack: true,
 		}
 	}
+	return
+}
+
+// kClient permit to connect to kafka brokers
+func (c *Validate) kClient() (conn *kafka.Conn, err error) {
+	dialer, err := c.kDialer()
+	if err != nil {
+		return
+	}
+
 	conn, err = dialer.Dial("tcp", commons.GetKafkaURI())
+	if err != nil {
+		return
+	}
+	return
+}
+
+// kController return the connection to the kafka controller
+// with the list of kafka brokers, both created on first call
+func (c *Validate) kController() (controller *kafka.Conn, brokers []string, err error) {
+	c.clients.mu.Lock()
+	controller, brokers = c.clients.controller, c.clients.brokers
+	c.clients.mu.Unlock()
+	if controller != nil {
+		return
+	}
+
+	conn, err := c.kClient()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
 
+	controller, err = c.connectToController(conn)
 	if err != nil {
 		return
 	}
+
+	brokerList, err := controller.Brokers()
+	if err != nil {
+		controller.Close()
+		return nil, nil, err
+	}
+	if len(brokerList) == 0 {
+		controller.Close()
+		return nil, nil, fmt.Errorf("Brokers list cannot be empty")
+	}
+	for _, v := range brokerList {
+		brokers = append(brokers, v.Host+":"+strconv.Itoa(conn.Broker().Port))
+	}
+
+	c.clients.mu.Lock()
+	defer c.clients.mu.Unlock()
+	// another caller may have connected in the meantime
+	if c.clients.controller != nil {
+		controller.Close()
+		return c.clients.controller, c.clients.brokers, nil
+	}
+	c.clients.controller, c.clients.brokers = controller, brokers
 	return
 }
 
+// releaseController permit to close the connection to the kafka controller after a failure
+// so the next call connects again to the current controller
+func (c *Validate) releaseController(controller *kafka.Conn) {
+	c.clients.mu.Lock()
+	defer c.clients.mu.Unlock()
+	if c.clients.controller == controller {
+		c.clients.controller, c.clients.brokers = nil, nil
+	}
+	controller.Close()
+}
+
+// withController permit to run the provided function with the connection to the kafka controller
+func (c *Validate) withController(f func(controller *kafka.Conn) error) (err error) {
+	controller, _, err := c.kController()
+	if err != nil {
+		return
+	}
+	if err = f(controller); err != nil {
+		c.releaseController(controller)
+	}
+	return
+}
+
+// kBrokers permit to retrieve the list of kafka brokers
+func (c *Validate) kBrokers() (brokers []string, err error) {
+	_, brokers, err = c.kController()
+	return
+}
+
+// kWriter permit to create a writer producing messages into the provided topic
+func (c *Validate) kWriter(brokers []string, topic string) (w *kafka.Writer, err error) {
+	transport, err := c.kTransport()
+	if err != nil {
+		return
+	}
+
+	w = &kafka.Writer{
+		Addr:         kafka.TCP(brokers...),
+		Topic:        topic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+		Transport:    transport,
+	}
+	return
+}
+
+// kAdmin return the client used to describe and alter kafka topics
+// created on first call
+func (c *Validate) kAdmin() (client *kafka.Client, err error) {
+	c.clients.mu.Lock()
+	client = c.clients.admin
+	c.clients.mu.Unlock()
+	if client != nil {
+		return
+	}
+
+	brokers, err := c.kBrokers()
+	if err != nil {
+		return
+	}
+	transport, err := c.kTransport()
+	if err != nil {
+		return
+	}
+
+	c.clients.mu.Lock()
+	defer c.clients.mu.Unlock()
+	if c.clients.admin == nil {
+		c.clients.admin = &kafka.Client{
+			Addr:      kafka.TCP(brokers...),
+			Transport: transport,
+			Timeout:   commons.GetKafkaConnectTimeout(),
+		}
+	}
+	return c.clients.admin, nil
+}
+
 func (c *Validate) connectToController(conn *kafka.Conn) (connLeader *kafka.Conn, err error) {
 	controller, err := conn.Controller()
 	if err != nil {
 		return
 	}
 
-	connLeader, err = kafka.Dial(
+	dialer, err := c.kDialer()
+	if err != nil {
+		return
+	}
+
+	connLeader, err = dialer.Dial(
 		"tcp",
 		net.JoinHostPort(
 			controller.Host,
@@ -121,14 +253,7 @@ func (c *Validate) connectToController(conn *kafka.Conn) (connLeader *kafka.Conn
 }
 
 // createTopic permit to create a topic
-func (c *Validate) createTopic(conn *kafka.Conn, kf createTopicModel) (err error) {
-	defer conn.Close()
-	connLeader, err := c.connectToController(conn)
-	if err != nil {
-		return
-	}
-	defer connLeader.Close()
-
+func (c *Validate) createTopic(kf createTopicModel) (err error) {
 	var topicConfig []kafka.ConfigEntry
 	if len(kf.TopicConfig) > 0 {
 		for _, v := range kf.TopicConfig {
@@ -151,23 +276,18 @@ func (c *Validate) createTopic(conn *kafka.Conn, kf createTopicModel) (err error
 		},
 	}
 
-	err = connLeader.CreateTopics(topicConfigs...)
-	if err != nil {
-		return
-	}
-	return
+	return c.withController(func(controller *kafka.Conn) error {
+		return controller.CreateTopics(topicConfigs...)
+	})
 }
 
 // listTopics permit to list all topics
-func (c *Validate) listTopics(conn *kafka.Conn) (topics []string, err error) {
-	defer conn.Close()
-	connLeader, err := c.connectToController(conn)
-	if err != nil {
+func (c *Validate) listTopics() (topics []string, err error) {
+	var partitions []kafka.Partition
+	err = c.withController(func(controller *kafka.Conn) (err error) {
+		partitions, err = controller.ReadPartitions()
 		return
-	}
-	defer connLeader.Close()
-
-	partitions, err := connLeader.ReadPartitions()
+	})
 	if err != nil {
 		return
 	}
@@ -183,55 +303,28 @@ func (c *Validate) listTopics(conn *kafka.Conn) (topics []string, err error) {
 }
 
 // deleteTopics permit to delete topics
-func (c *Validate) deleteTopics(conn *kafka.Conn, topics []string) (err error) {
-	defer conn.Close()
-	connLeader, err := c.connectToController(conn)
-	if err != nil {
-		c.Logger.Info().Msgf("XXXX %v", err.Error())
-		return
-	}
-	defer connLeader.Close()
-
-	err = connLeader.DeleteTopics(topics...)
-	return
+func (c *Validate) deleteTopics(topics []string) (err error) {
+	return c.withController(func(controller *kafka.Conn) error {
+		return controller.DeleteTopics(topics...)
+	})
 }
 
 // produceMessage permit to write a message into specified topic
-func (c *Validate) produceMessage(conn *kafka.Conn, message kafkaWriteMessage) (err error) {
-	defer conn.Close()
-	connLeader, err := c.connectToController(conn)
+func (c *Validate) produceMessage(message kafkaWriteMessage) (err error) {
+	brokers, err := c.kBrokers()
 	if err != nil {
 		return
 	}
-	defer connLeader.Close()
-
-	err = connLeader.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	w, err := c.kWriter(brokers, message.TopicName)
 	if err != nil {
 		return
 	}
-	_, err = conn.WriteMessages(
-		kafka.Message{
-			Key:   []byte(message.Key),
-			Value: []byte(message.Value),
-		},
-	)
-
-	w := &kafka.Writer{
-		Addr: kafka.TCP(
-			strings.Join(
-				[]string{
-					conn.Broker().Host,
-					strconv.Itoa(conn.Broker().Port),
-				},
-				":",
-			),
-		),
-		Topic:    message.TopicName,
-		Balancer: &kafka.LeastBytes{},
-	}
+	defer w.Close()
 
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 	err = w.WriteMessages(
-		context.Background(),
+		ctx,
 		kafka.Message{
 			Key:   []byte(message.Key),
 			Value: []byte(message.Value),
@@ -242,23 +335,21 @@ func (c *Validate) produceMessage(conn *kafka.Conn, message kafkaWriteMessage) (
 
 // consumeMessage permit to consume message into specified topic
 // and will be used for unit testing only
-func (c *Validate) consumeMessage(conn *kafka.Conn, consumerGroup string, topicName string) (err error) {
-	defer conn.Close()
-	connLeader, err := c.connectToController(conn)
+func (c *Validate) consumeMessage(consumerGroup string, topicName string) (err error) {
+	brokers, err := c.kBrokers()
 	if err != nil {
-		c.Logger.Info().Msgf("YYYY %v", err.Error())
 		return
 	}
-	defer connLeader.Close()
-
-	brokers := []string{
-		connLeader.Broker().Host + ":" + strconv.Itoa(connLeader.Broker().Port),
+	dialer, err := c.kDialer()
+	if err != nil {
+		return
 	}
 
 	r := kafka.NewReader(kafka.ReaderConfig{
 		Brokers:  brokers,
 		Topic:    topicName,
 		GroupID:  consumerGroup,
+		Dialer:   dialer,
 		MinBytes: 1,
 		MaxBytes: 10e6,
 	})
@@ -272,3 +363,124 @@ func (c *Validate) consumeMessage(conn *kafka.Conn, consumerGroup string, topicN
 	c.Logger.Debug().Msgf("Message at offset %d: %s = %s", m.Offset, string(m.Key), string(m.Value))
 	return
 }
+
+// describeTopics permit to retrieve the partitions, replication factor
+// and the provided config keys of topics
+func (c *Validate) describeTopics(ctx context.Context, client *kafka.Client, topics map[string][]string) (z map[string]topicState, err error) {
+	var names []string
+	for name := range topics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	metadata, err := client.Metadata(ctx, &kafka.MetadataRequest{Topics: names})
+	if err != nil {
+		return
+	}
+
+	z = make(map[string]topicState)
+	var resources []kafka.DescribeConfigRequestResource
+	for _, topic := range metadata.Topics {
+		if topic.Error != nil {
+			if errors.Is(topic.Error, kafka.UnknownTopicOrPartition) {
+				continue
+			}
+			return nil, fmt.Errorf("Fail to describe topic %s: %w", topic.Name, topic.Error)
+		}
+		state := topicState{
+			Exists:     true,
+			Partitions: len(topic.Partitions),
+			Config:     make(map[string]string),
+		}
+		if len(topic.Partitions) > 0 {
+			state.ReplicationFactor = len(topic.Partitions[0].Replicas)
+		}
+		z[topic.Name] = state
+		if len(topics[topic.Name]) > 0 {
+			resources = append(resources, kafka.DescribeConfigRequestResource{
+				ResourceType: kafka.ResourceTypeTopic,
+				ResourceName: topic.Name,
+				ConfigNames:  topics[topic.Name],
+			})
+		}
+	}
+	if len(resources) == 0 {
+		return
+	}
+
+	configs, err := client.DescribeConfigs(ctx, &kafka.DescribeConfigsRequest{Resources: resources})
+	if err != nil {
+		return nil, err
+	}
+	for _, resource := range configs.Resources {
+		if resource.Error != nil {
+			return nil, fmt.Errorf("Fail to describe config of topic %s: %w", resource.ResourceName, resource.Error)
+		}
+		for _, entry := range resource.ConfigEntries {
+			z[resource.ResourceName].Config[entry.ConfigName] = entry.ConfigValue
+		}
+	}
+	return
+}
+
+// createPartitions permit to increase the number of partitions of a topic
+func (c *Validate) createPartitions(ctx context.Context, client *kafka.Client, topic string, count int) (err error) {
+	resp, err := client.CreatePartitions(ctx, &kafka.CreatePartitionsRequest{
+		Topics: []kafka.TopicPartitionsConfig{
+			{
+				Name:  topic,
+				Count: int32(count),
+			},
+		},
+	})
+	if err != nil {
+		return
+	}
+	return resp.Errors[topic]
+}
+
+// alterTopicConfig permit to set config entries of a topic
+// while keeping the other ones
+func (c *Validate) alterTopicConfig(ctx context.Context, client *kafka.Client, topic string, config []topicConfig) (err error) {
+	var configs []kafka.IncrementalAlterConfigsRequestConfig
+	for _, v := range config {
+		configs = append(configs, kafka.IncrementalAlterConfigsRequestConfig{
+			Name:            v.Key,
+			Value:           v.Value,
+			ConfigOperation: kafka.ConfigOperationSet,
+		})
+	}
+	resp, err := client.IncrementalAlterConfigs(ctx, &kafka.IncrementalAlterConfigsRequest{
+		Resources: []kafka.IncrementalAlterConfigsRequestResource{
+			{
+				ResourceType: kafka.ResourceTypeTopic,
+				ResourceName: topic,
+				Configs:      configs,
+			},
+		},
+	})
+	if err != nil {
+		return
+	}
+	for _, resource := range resp.Resources {
+		if resource.Error != nil {
+			return resource.Error
+		}
+	}
+	return
+}
+
+// deleteGroups permit to delete consumer groups.
+// Groups that do not exist are ignored
+func (c *Validate) deleteGroups(ctx context.Context, client *kafka.Client, groups []string) (err error) {
+	resp, err := client.DeleteGroups(ctx, &kafka.DeleteGroupsRequest{GroupIDs: groups})
+	if err != nil {
+		return
+	}
+	for _, group := range groups {
+		if err = resp.Errors[group]; err != nil && !errors.Is(err, kafka.GroupIdNotFound) {
+			return fmt.Errorf("Fail to delete consumer group %s: %w", group, err)
+		}
+	}
+	return nil
+}
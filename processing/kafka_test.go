@@ -122,10 +122,7 @@ func TestCreateTopic(t *testing.T) {
 	var c Validate
 	c.Logger = logger.NewLogger()
 
-	conn, err := c.kClient()
-	assert.Nil(err)
-	err = c.createTopic(
-		conn,
+	err := c.createTopic(
 		createTopicModel{
 			Name:              test_create_topic,
 			NumPartitions:     1,
@@ -145,9 +142,7 @@ func TestKafkaListTopics(t *testing.T) {
 	var c Validate
 	c.Logger = logger.NewLogger()
 
-	conn, err := c.kClient()
-	assert.Nil(err)
-	_, err = c.listTopics(conn)
+	_, err := c.listTopics()
 	assert.Nil(err)
 }
 
@@ -156,10 +151,7 @@ func TestKafkaProduceMessage(t *testing.T) {
 	var c Validate
 	c.Logger = logger.NewLogger()
 
-	conn, err := c.kClient()
-	assert.Nil(err)
-	err = c.produceMessage(
-		conn,
+	err := c.produceMessage(
 		kafkaWriteMessage{
 			TopicName: test_create_topic,
 			Key:       "test",
@@ -176,9 +168,6 @@ func TestKafkaConsumeMessage(t *testing.T) {
 	var c Validate
 	c.Logger = logger.NewLogger()
 
-	conn, err := c.kClient()
-	assert.Nil(err)
-
 	proc, err := os.FindProcess(os.Getpid())
 	if err != nil {
 		t.Fatal(err)
@@ -189,7 +178,6 @@ func TestKafkaConsumeMessage(t *testing.T) {
 
 	go func() {
 		err = c.consumeMessage(
-			conn,
 			"test",
 			test_create_topic,
 		)
@@ -208,10 +196,7 @@ func TestKafkaDeleteTopics(t *testing.T) {
 	var c Validate
 	c.Logger = logger.NewLogger()
 
-	conn, err := c.kClient()
-	assert.Nil(err)
-	err = c.deleteTopics(
-		conn,
+	err := c.deleteTopics(
 		[]string{
 			test_create_topic,
 		},
@@ -4,7 +4,9 @@ package processing
 import (
 	"os"
 	"strings"
+	"time"
 
+	"github.com/Lord-Y/synker/commons"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -29,6 +31,69 @@ func newMetrics() (m *metrics, err error) {
 			},
 			[]string{"error_type", "topic"},
 		),
+		stale: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: name,
+				Subsystem: "elaticsearch",
+				Name:      "stale_writes_total",
+				Help:      "Number of writes ignored because a newer version of the document exists",
+			},
+			[]string{"action", "topic"},
+		),
+		fanout: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: name,
+				Subsystem: "dependency",
+				Name:      "fanout_total",
+				Help:      "Number of documents refreshed or skipped because a joined table changed",
+			},
+			[]string{"result", "topic"},
+		),
+		consumer: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: name,
+				Subsystem: "consumer",
+				Name:      "restarts_total",
+				Help:      "Number of consumer restarts",
+			},
+			[]string{"error_type", "topic"},
+		),
+		changeFeedResumes: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: name,
+				Subsystem: "changefeed",
+				Name:      "resumes_total",
+				Help:      "Number of changefeed jobs resumed, recreated or given up",
+			},
+			[]string{"action", "schema"},
+		),
+		changeFeedStatus: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: name,
+				Subsystem: "changefeed",
+				Name:      "status",
+				Help:      "Status of the changefeed job of the schema, 1 for the current status",
+			},
+			[]string{"schema", "status"},
+		),
+		changeFeedHighWater: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: name,
+				Subsystem: "changefeed",
+				Name:      "high_water_timestamp_seconds",
+				Help:      "Time up to which changes have been emitted by the changefeed job of the schema",
+			},
+			[]string{"schema"},
+		),
+		changeFeedJobs: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: name,
+				Subsystem: "changefeed",
+				Name:      "jobs",
+				Help:      "Number of running or paused changefeed jobs of the table of the schema, more than 1 means duplicates",
+			},
+			[]string{"schema"},
+		),
 	}
 	if err := prometheus.Register(z.kafka); err != nil {
 		_, ok := err.(prometheus.AlreadyRegisteredError)
@@ -42,6 +107,32 @@ func newMetrics() (m *metrics, err error) {
 			return nil, err
 		}
 	}
+	if err := prometheus.Register(z.stale); err != nil {
+		_, ok := err.(prometheus.AlreadyRegisteredError)
+		if !ok {
+			return nil, err
+		}
+	}
+	if err := prometheus.Register(z.fanout); err != nil {
+		_, ok := err.(prometheus.AlreadyRegisteredError)
+		if !ok {
+			return nil, err
+		}
+	}
+	if err := prometheus.Register(z.consumer); err != nil {
+		_, ok := err.(prometheus.AlreadyRegisteredError)
+		if !ok {
+			return nil, err
+		}
+	}
+	for _, collector := range []prometheus.Collector{z.changeFeedResumes, z.changeFeedStatus, z.changeFeedHighWater, z.changeFeedJobs} {
+		if err := prometheus.Register(collector); err != nil {
+			_, ok := err.(prometheus.AlreadyRegisteredError)
+			if !ok {
+				return nil, err
+			}
+		}
+	}
 	return z, nil
 }
 
@@ -58,6 +149,144 @@ func (c *Validate) increaseMetrics(metricType, topic, errorType string) {
 				"topic":      topic,
 				"error_type": errorType,
 			}).Inc()
+		case "stale":
+			c.metrics.stale.With(prometheus.Labels{
+				"topic":  topic,
+				"action": errorType,
+			}).Inc()
+		case "fanout":
+			c.metrics.fanout.With(prometheus.Labels{
+				"topic":  topic,
+				"result": errorType,
+			}).Inc()
+		case "consumer":
+			c.metrics.consumer.With(prometheus.Labels{
+				"topic":      topic,
+				"error_type": errorType,
+			}).Inc()
+		case "changefeed":
+			c.metrics.changeFeedResumes.With(prometheus.Labels{
+				"schema": topic,
+				"action": errorType,
+			}).Inc()
+		}
+	}
+}
+
+// setChangeFeedMetrics permit to export the state of the changefeed of the schema
+func (c *Validate) setChangeFeedMetrics(state changeFeedState) {
+	if strings.TrimSpace(os.Getenv("SYNKER_PROMETHEUS")) == "" || c.metrics == nil {
+		return
+	}
+	for _, status := range changeFeedStatuses {
+		var z float64
+		if status == state.Status {
+			z = 1
 		}
+		c.metrics.changeFeedStatus.With(prometheus.Labels{"schema": state.Schema, "status": status}).Set(z)
 	}
+	jobs := 0
+	if state.Status == "running" || state.Status == "paused" {
+		jobs = 1 + len(state.Duplicates)
+	}
+	c.metrics.changeFeedJobs.With(prometheus.Labels{"schema": state.Schema}).Set(float64(jobs))
+	if t, ok := highWaterTime(state.HighWater); ok {
+		c.metrics.changeFeedHighWater.With(prometheus.Labels{"schema": state.Schema}).Set(float64(t.UnixNano()) / float64(time.Second))
+	}
+}
+
+// poolCollector expose the statistics of the cockroach connection pool
+// and the connections opened to elasticsearch and kafka
+type poolCollector struct {
+	validate                    *Validate
+	elasticsearchOpen           *prometheus.Desc
+	elasticsearchDialsTotal     *prometheus.Desc
+	elasticsearchMaxIdle        *prometheus.Desc
+	kafkaOpen                   *prometheus.Desc
+	kafkaDialsTotal             *prometheus.Desc
+	acquiredConnections         *prometheus.Desc
+	idleConnections             *prometheus.Desc
+	constructingConnections     *prometheus.Desc
+	totalConnections            *prometheus.Desc
+	maxConnections              *prometheus.Desc
+	emptyAcquireTotal           *prometheus.Desc
+	canceledAcquireTotal        *prometheus.Desc
+	acquireDurationSecondsTotal *prometheus.Desc
+}
+
+// newPoolCollector return the collector of the connection pools statistics
+func (c *Validate) newPoolCollector() *poolCollector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName("synker", "cockroach_pool", name), help, nil, nil)
+	}
+	subsystemDesc := func(subsystem, name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName("synker", subsystem, name), help, nil, nil)
+	}
+	return &poolCollector{
+		validate:                    c,
+		elasticsearchOpen:           subsystemDesc("elasticsearch_pool", "open_connections", "Number of open connections to elasticsearch"),
+		elasticsearchDialsTotal:     subsystemDesc("elasticsearch_pool", "dials_total", "Number of connections opened to elasticsearch"),
+		elasticsearchMaxIdle:        subsystemDesc("elasticsearch_pool", "max_idle_connections", "Maximum number of idle connections kept to elasticsearch"),
+		kafkaOpen:                   subsystemDesc("kafka_pool", "open_connections", "Number of open connections to kafka brokers"),
+		kafkaDialsTotal:             subsystemDesc("kafka_pool", "dials_total", "Number of connections opened to kafka brokers"),
+		acquiredConnections:         desc("acquired_connections", "Number of connections currently in use"),
+		idleConnections:             desc("idle_connections", "Number of idle connections"),
+		constructingConnections:     desc("constructing_connections", "Number of connections being established"),
+		totalConnections:            desc("total_connections", "Total number of connections"),
+		maxConnections:              desc("max_connections", "Maximum number of connections"),
+		emptyAcquireTotal:           desc("empty_acquire_total", "Number of acquires that waited for a connection"),
+		canceledAcquireTotal:        desc("canceled_acquire_total", "Number of acquires cancelled by their context"),
+		acquireDurationSecondsTotal: desc("acquire_duration_seconds_total", "Total time spent waiting for a connection"),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (p *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- p.elasticsearchOpen
+	ch <- p.elasticsearchDialsTotal
+	ch <- p.elasticsearchMaxIdle
+	ch <- p.kafkaOpen
+	ch <- p.kafkaDialsTotal
+	ch <- p.acquiredConnections
+	ch <- p.idleConnections
+	ch <- p.constructingConnections
+	ch <- p.totalConnections
+	ch <- p.maxConnections
+	ch <- p.emptyAcquireTotal
+	ch <- p.canceledAcquireTotal
+	ch <- p.acquireDurationSecondsTotal
+}
+
+// Collect implements prometheus.Collector
+func (p *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	p.validate.clients.mu.Lock()
+	db := p.validate.clients.db
+	elasticsearch := p.validate.clients.elasticsearch
+	kafka := p.validate.clients.dialer
+	p.validate.clients.mu.Unlock()
+
+	if elasticsearch != nil {
+		conns := &p.validate.clients.elasticsearchConns
+		ch <- prometheus.MustNewConstMetric(p.elasticsearchOpen, prometheus.GaugeValue, float64(conns.open.Load()))
+		ch <- prometheus.MustNewConstMetric(p.elasticsearchDialsTotal, prometheus.CounterValue, float64(conns.dials.Load()))
+		ch <- prometheus.MustNewConstMetric(p.elasticsearchMaxIdle, prometheus.GaugeValue, float64(commons.GetElasticsearchMaxIdleConns()))
+	}
+	if kafka != nil {
+		conns := &p.validate.clients.kafkaConns
+		ch <- prometheus.MustNewConstMetric(p.kafkaOpen, prometheus.GaugeValue, float64(conns.open.Load()))
+		ch <- prometheus.MustNewConstMetric(p.kafkaDialsTotal, prometheus.CounterValue, float64(conns.dials.Load()))
+	}
+	if db == nil {
+		return
+	}
+
+	stat := db.Stat()
+	ch <- prometheus.MustNewConstMetric(p.acquiredConnections, prometheus.GaugeValue, float64(stat.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(p.idleConnections, prometheus.GaugeValue, float64(stat.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(p.constructingConnections, prometheus.GaugeValue, float64(stat.ConstructingConns()))
+	ch <- prometheus.MustNewConstMetric(p.totalConnections, prometheus.GaugeValue, float64(stat.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(p.maxConnections, prometheus.GaugeValue, float64(stat.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(p.emptyAcquireTotal, prometheus.CounterValue, float64(stat.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(p.canceledAcquireTotal, prometheus.CounterValue, float64(stat.CanceledAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(p.acquireDurationSecondsTotal, prometheus.CounterValue, stat.AcquireDuration().Seconds())
 }
@@ -2,6 +2,7 @@
 package processing
 
 import (
+	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -24,6 +25,20 @@ type Configuration struct {
 	Logger *zerolog.Logger
 	// metrics hold all metrics that will be used by synker
 	metrics *metrics
+	// supervisor keep track of consumers and restart them when they fail
+	supervisor *supervisor
+	// changeFeeds keep track of changefeed jobs and resume them when they are paused or failed
+	changeFeeds *changeFeedMonitor
+	// clients hold the long-lived connections shared by all consumers
+	clients clients
+	// primaryKeys cache the primary key columns of tables
+	primaryKeys sync.Map
+	// filters cache the compiled filter expressions of schemas
+	filters sync.Map
+	// indexes cache the generated elasticsearch indexes known to exist
+	indexes sync.Map
+	// dualWrites cache the indexes being rebuilt by a reindex of each schema
+	dualWrites sync.Map
 }
 
 // List of validated files with SQL queries
@@ -50,6 +65,32 @@ type topicConfig struct {
 	Value string `json:"value" yaml:"value" validate:"required"` // Config value
 }
 
+// topicState is the live state of a kafka topic
+type topicState struct {
+	// Exists is true when the topic exists
+	Exists bool
+	// Number of partitions
+	Partitions int
+	// Replication factor of the first partition
+	ReplicationFactor int
+	// Config hold the values of the config keys of the schema
+	Config map[string]string
+}
+
+// changeFeedJob is a changefeed job returned by SHOW CHANGEFEED JOBS
+type changeFeedJob struct {
+	// Id of the job
+	Id int64
+	// Status of the job like running, paused or failed
+	Status string
+	// Description is the statement that created the changefeed
+	Description string
+	// HighWater is the hybrid logical clock timestamp up to which changes have been emitted
+	HighWater string
+	// SinkURI is the uri of the sink with its secrets redacted
+	SinkURI string
+}
+
 // kafkaWriteMessage define the requirements to write messages into kafka
 type kafkaWriteMessage struct {
 	TopicName string `json:"name" yaml:"name"`   // Topic name
@@ -75,18 +116,95 @@ type configSchema struct {
 	Elasticsearch elasticsearchSchema `json:"elasticsearch" yaml:"elasticsearch" validate:"required"`
 	// // Requirements to create change feed
 	ChangeFeed changeFeed `json:"changeFeed" yaml:"changeFeed" validate:"required"`
+	// Transform is the ordered list of steps applied to documents before indexing
+	Transform []transformStep `json:"transform" yaml:"transform"`
+	// Filter is the expression a row must match to be indexed like status == 'active'
+	Filter string `json:"filter" yaml:"filter"`
+	// Format of kafka messages. Default to cockroach
+	Format string `json:"format" yaml:"format" validate:"omitempty,oneof=cockroach cockroach-avro debezium-json debezium-json-schemaless"`
+}
+
+// transformStep is one step applied to documents before indexing.
+// Only one operation must be set per step
+type transformStep struct {
+	// Rename a field
+	Rename *transformRename `json:"rename,omitempty" yaml:"rename,omitempty"`
+	// Drop the list of fields
+	Drop []string `json:"drop,omitempty" yaml:"drop,omitempty"`
+	// Include only the list of fields
+	Include []string `json:"include,omitempty" yaml:"include,omitempty"`
+	// Cast a field into another type
+	Cast *transformCast `json:"cast,omitempty" yaml:"cast,omitempty"`
+	// Set a field with a constant value
+	Set *transformSet `json:"set,omitempty" yaml:"set,omitempty"`
+	// Concat fields into another one
+	Concat *transformConcat `json:"concat,omitempty" yaml:"concat,omitempty"`
+	// Copy fields into a sub-object
+	Copy *transformCopy `json:"copy,omitempty" yaml:"copy,omitempty"`
+}
+
+// transformRename is the requirement to rename a field
+type transformRename struct {
+	// From is the field to rename
+	From string `json:"from" yaml:"from"`
+	// To is the new name of the field
+	To string `json:"to" yaml:"to"`
+}
+
+// transformCast is the requirement to cast a field
+type transformCast struct {
+	// Field to cast
+	Field string `json:"field" yaml:"field"`
+	// Type is one of string, long, double, boolean or date
+	Type string `json:"type" yaml:"type"`
+	// Layout used to parse string dates like 2006-01-02. Default to RFC3339
+	Layout string `json:"layout" yaml:"layout"`
+}
+
+// transformSet is the requirement to set a field with a constant value
+type transformSet struct {
+	// Field to set
+	Field string `json:"field" yaml:"field"`
+	// Value of the field
+	Value interface{} `json:"value" yaml:"value"`
+}
+
+// transformConcat is the requirement to concatenate fields
+type transformConcat struct {
+	// Fields to concatenate
+	Fields []string `json:"fields" yaml:"fields"`
+	// Separator between fields
+	Separator string `json:"separator" yaml:"separator"`
+	// To is the field holding the result
+	To string `json:"to" yaml:"to"`
+}
+
+// transformCopy is the requirement to copy fields into a sub-object
+type transformCopy struct {
+	// Fields to copy
+	Fields []string `json:"fields" yaml:"fields"`
+	// To is the sub-object receiving the fields
+	To string `json:"to" yaml:"to"`
 }
 
 // topicSchema is the requirement to create the topic
 type topicSchema struct {
-	// Topic name
-	Name string `json:"name" yaml:"name" validate:"required"`
+	// Topic name. Default to the topic written by the changefeed with cockroach formats
+	Name string `json:"name" yaml:"name"`
 	// Number of partitions
 	NumPartitions int `json:"numPartitions" yaml:"numPartitions" validate:"required"`
 	// Cluster replication factor
 	ReplicationFactor int `json:"replicationFactor" yaml:"replicationFactor" validate:"required"`
 	// Topic config
 	TopicConfig []topicConfig `json:"config" yaml:"config" validate:"dive"`
+	// DeadLetter is the topic receiving messages that cannot be processed
+	DeadLetter deadLetterSchema `json:"deadLetter" yaml:"deadLetter"`
+}
+
+// deadLetterSchema is the requirement to create the dead letter topic
+type deadLetterSchema struct {
+	// Topic name. Default to the topic name suffixed by _dlq
+	Name string `json:"name" yaml:"name"`
 }
 
 // sql is the requirement to query the SQL database
@@ -96,14 +214,62 @@ type sql struct {
 	Columns []string `json:"columnNames" yaml:"columnNames" validate:"required"`
 	// Query is the SQL query to execute
 	Query string `json:"query" yaml:"query" validate:"required"`
+	// Aggregate permit to fold all rows returned by the query into one document
+	Aggregate sqlAggregate `json:"aggregate" yaml:"aggregate"`
+	// Dependencies is the list of tables joined by the query
+	// whose changes must refresh the documents of the schema
+	Dependencies []sqlDependency `json:"dependencies" yaml:"dependencies"`
+}
+
+// sqlDependency is the requirement to refresh documents
+// when a table joined by the SQL query changes
+type sqlDependency struct {
+	// Table joined by the query like vehicles or movr.public.vehicles
+	Table string `json:"table" yaml:"table" validate:"required"`
+	// Column of the joined table like id
+	Column string `json:"column" yaml:"column" validate:"required"`
+	// JoinColumn is the column of the schema table referencing the joined table like vehicle_id
+	JoinColumn string `json:"joinColumn" yaml:"joinColumn" validate:"required"`
+	// MaxFanOut is the maximum number of documents refreshed by a change. Default to 1000
+	MaxFanOut int `json:"maxFanOut" yaml:"maxFanOut" validate:"omitempty,min=1"`
+}
+
+// sqlAggregate is the requirement to fold rows returned by the SQL query
+// into nested collections of one document
+type sqlAggregate struct {
+	// GroupBy is the list of columns identifying the document
+	GroupBy []string `json:"groupBy" yaml:"groupBy"`
+	// Collections is the list of nested arrays built from the rows
+	Collections []sqlCollection `json:"collections" yaml:"collections"`
+	// OrderBy is the list of expressions ordering the rows like rides.start_time DESC
+	// so the limit of collections keeps the first rows
+	OrderBy []string `json:"orderBy" yaml:"orderBy"`
+}
+
+// sqlCollection is the requirement to build a nested array from the rows
+type sqlCollection struct {
+	// Name of the field holding the array
+	Name string `json:"name" yaml:"name" validate:"required"`
+	// Columns moved into the objects of the array.
+	// Columns prefixed by the collection name like "rides.id" are stored without the prefix
+	Columns []string `json:"columns" yaml:"columns" validate:"required,min=1"`
+	// Limit is the maximum number of objects in the array
+	Limit int `json:"limit" yaml:"limit" validate:"omitempty,min=1"`
 }
 
 // changeFeed bind all requrirements to create changefeed
 type changeFeed struct {
 	// FullTableName is cockroach full table name like movr.public.promo_codes
+	// or the source table name of debezium messages
 	FullTableName string `json:"fullTableName" yaml:"fullTableName" validate:"required"`
-	// Options is all change feed options required to create the change feed
-	Options []string `json:"options" yaml:"options" validate:"required"`
+	// Options is all change feed options required to create the change feed.
+	// Only required with cockroach format
+	Options []string `json:"options" yaml:"options"`
+	// Sink is the kafka sink of the changefeed. Only used with cockroach format
+	Sink changeFeedSink `json:"sink" yaml:"sink"`
+	// Select is the cdc query projecting and filtering rows in the changefeed.
+	// Only used with cockroach format
+	Select changeFeedSelect `json:"select" yaml:"select"`
 }
 
 // elasticsearchSchema is the requirement related to elasticsearch
@@ -112,6 +278,32 @@ type elasticsearchSchema struct {
 	Index elasticsearchIndex `json:"index" yaml:"index" validate:"required"`
 	// Type defined if the sql query is plain or not
 	Mapping map[string]interface{} `json:"mapping" yaml:"mapping" validate:"required"`
+	// Bulk is the requirement to batch requests sent to elasticsearch
+	Bulk bulkSchema `json:"bulk" yaml:"bulk"`
+	// DocumentId is the requirement to generate elasticsearch document ids
+	DocumentId documentIdSchema `json:"documentId" yaml:"documentId"`
+	// Sink receiving documents. Default to elasticsearch
+	Sink string `json:"sink" yaml:"sink" validate:"omitempty,oneof=elasticsearch opensearch"`
+}
+
+// documentIdSchema is the requirement to generate elasticsearch document ids
+type documentIdSchema struct {
+	// Strategy used to generate the id. Default to uuid
+	Strategy string `json:"strategy" yaml:"strategy" validate:"omitempty,oneof=primaryKey template hash uuid"`
+	// Template built with immutable columns like {city}-{id} when strategy is template
+	Template string `json:"template" yaml:"template"`
+	// Columns to hash when strategy is hash. Default to the primary key
+	Columns []string `json:"columns" yaml:"columns"`
+}
+
+// bulkSchema is the requirement to batch elasticsearch requests
+type bulkSchema struct {
+	// Actions is the maximum number of requests in a batch
+	Actions int `json:"actions" yaml:"actions" validate:"omitempty,min=1"`
+	// Size is the maximum size in bytes of a batch
+	Size int `json:"size" yaml:"size" validate:"omitempty,min=1"`
+	// FlushInterval is the maximum time to wait before sending a batch like 1s
+	FlushInterval string `json:"flushInterval" yaml:"flushInterval"`
 }
 
 // elasticsearchIndex is the requirement to the elasticsearch index
@@ -119,9 +311,11 @@ type elasticsearchIndex struct {
 	// Create index
 	Create bool `json:"create" yaml:"create"`
 	// Index name
-	Name string `json:"name" yaml:"name" validate:"required"`
-	// Alias name
+	Name string `json:"name" yaml:"name" validate:"required_without=Pattern"`
+	// Alias name. Required with pattern to read all generated indexes
 	Alias string `json:"alias" yaml:"alias"`
+	// Pattern used to compute the index of each document like vlh-{timestamp:2006.01} or rides-{city}
+	Pattern string `json:"pattern" yaml:"pattern"`
 }
 
 // consumeMessage permit to consume kafka messages
@@ -145,4 +339,12 @@ type consumeMessage struct {
 type metrics struct {
 	kafka         *prometheus.CounterVec
 	elasticsearch *prometheus.CounterVec
+	stale         *prometheus.CounterVec
+	fanout        *prometheus.CounterVec
+	consumer      *prometheus.CounterVec
+	// changeFeed* are related to the changefeed jobs of each schema
+	changeFeedResumes   *prometheus.CounterVec
+	changeFeedStatus    *prometheus.GaugeVec
+	changeFeedHighWater *prometheus.GaugeVec
+	changeFeedJobs      *prometheus.GaugeVec
 }
@@ -0,0 +1,749 @@
+// Package processing provide all requirements to process change data capture
+package processing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	// planCreate is a missing resource that can be created
+	planCreate string = "create"
+	// planUpdate is a resource that can be updated in place
+	planUpdate string = "update"
+	// planReplace is a resource that must be recreated and may lose data
+	planReplace string = "replace"
+	// planManual is a difference that synker cannot apply
+	planManual string = "manual"
+)
+
+const (
+	planTopic      string = "topic"
+	planIndex      string = "index"
+	planAlias      string = "alias"
+	planMapping    string = "mapping"
+	planChangeFeed string = "changefeed"
+)
+
+// planChange is a difference between a schema and the live infrastructure
+type planChange struct {
+	// Schema name
+	Schema string `json:"schema"`
+	// Resource is one of topic, index, alias, mapping or changefeed
+	Resource string `json:"resource"`
+	// Name of the resource
+	Name string `json:"name"`
+	// Action is one of create, update, replace or manual
+	Action string `json:"action"`
+	// Field that differs like partitions or properties.code.type
+	Field string `json:"field,omitempty"`
+	// Current value of the field
+	Current string `json:"current,omitempty"`
+	// Expected value of the field
+	Expected string `json:"expected,omitempty"`
+	// Destructive is true when applying the change may lose data
+	Destructive bool `json:"destructive"`
+	// Reason explains replace and manual changes
+	Reason string `json:"reason,omitempty"`
+	// index of the schema
+	index int
+	// job is the changefeed job to replace
+	job changeFeedJob
+}
+
+// planOutput is the JSON output of the plan
+type planOutput struct {
+	Changes []planChange `json:"changes"`
+	Create  int          `json:"create"`
+	Update  int          `json:"update"`
+	Replace int          `json:"replace"`
+	Manual  int          `json:"manual"`
+}
+
+// mappingChange is a difference between the expected and the live mapping of a field
+type mappingChange struct {
+	Field    string
+	Current  string
+	Expected string
+	// Conflict is true when the field already exists with another definition
+	Conflict bool
+}
+
+// ApplyOptions hold the requirements to apply the plan
+type ApplyOptions struct {
+	// AllowDestructive permit to apply changes that may lose data
+	AllowDestructive bool
+}
+
+// Plan permit to print the differences between schemas and the live infrastructure.
+// Output is one of text or json
+func (c *Validate) Plan(output string) {
+	defer c.closeClients()
+
+	changes, err := c.plan(context.Background())
+	if err != nil {
+		c.Logger.Fatal().Err(err).Msg("Fail to plan changes")
+		return
+	}
+	if output == "json" {
+		err = writePlanJSON(os.Stdout, changes)
+	} else {
+		err = writePlan(os.Stdout, changes)
+	}
+	if err != nil {
+		c.Logger.Fatal().Err(err).Msg("Fail to print plan")
+	}
+}
+
+// Apply permit to apply the differences between schemas and the live infrastructure.
+// Destructive changes are refused unless allowed
+func (c *Validate) Apply(options ApplyOptions) {
+	defer c.closeClients()
+
+	ctx := context.Background()
+	changes, err := c.plan(ctx)
+	if err != nil {
+		c.Logger.Fatal().Err(err).Msg("Fail to plan changes")
+		return
+	}
+	if err = writePlan(os.Stdout, changes); err != nil {
+		c.Logger.Fatal().Err(err).Msg("Fail to print plan")
+		return
+	}
+	if err = c.apply(ctx, changes, options); err != nil {
+		c.Logger.Fatal().Err(err).Msg("Fail to apply changes")
+		return
+	}
+}
+
+// plan permit to compare every schema against kafka topics,
+// elasticsearch indexes and cockroach changefeed jobs
+func (c *Validate) plan(ctx context.Context) (changes []planChange, err error) {
+	topics, err := c.planTopics(ctx)
+	if err != nil {
+		return
+	}
+	for k := range c.validatedSchemas.Schemas {
+		changes = append(changes, topics[k]...)
+
+		z, err := c.planIndex(ctx, k)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, z...)
+
+		z, err = c.planChangeFeed(ctx, k)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, z...)
+	}
+	return
+}
+
+// expectedTopics return the topics of the schema with their dead letter topic
+func (c *Validate) expectedTopics(index int) []createTopicModel {
+	v := c.validatedSchemas.Schemas[index]
+	return []createTopicModel{
+		{
+			Name:              v.Topic.Name,
+			NumPartitions:     v.Topic.NumPartitions,
+			ReplicationFactor: v.Topic.ReplicationFactor,
+			TopicConfig:       v.Topic.TopicConfig,
+		},
+		{
+			Name:              c.deadLetterTopic(index),
+			NumPartitions:     v.Topic.NumPartitions,
+			ReplicationFactor: v.Topic.ReplicationFactor,
+		},
+	}
+}
+
+// planTopics permit to compare the topics of every schema with kafka
+func (c *Validate) planTopics(ctx context.Context) (changes map[int][]planChange, err error) {
+	client, err := c.kAdmin()
+	if err != nil {
+		return
+	}
+
+	keys := make(map[string][]string)
+	for k := range c.validatedSchemas.Schemas {
+		for _, topic := range c.expectedTopics(k) {
+			for _, config := range topic.TopicConfig {
+				keys[topic.Name] = append(keys[topic.Name], config.Key)
+			}
+			if _, ok := keys[topic.Name]; !ok {
+				keys[topic.Name] = nil
+			}
+		}
+	}
+	states, err := c.describeTopics(ctx, client, keys)
+	if err != nil {
+		return
+	}
+
+	changes = make(map[int][]planChange)
+	for k, v := range c.validatedSchemas.Schemas {
+		for _, topic := range c.expectedTopics(k) {
+			for _, change := range topicChanges(topic, states[topic.Name]) {
+				change.Schema = v.Name
+				change.index = k
+				changes[k] = append(changes[k], change)
+			}
+		}
+	}
+	return
+}
+
+// topicChanges return the differences between the expected and the live topic
+func topicChanges(expected createTopicModel, live topicState) (changes []planChange) {
+	if !live.Exists {
+		return []planChange{
+			{
+				Resource: planTopic,
+				Name:     expected.Name,
+				Action:   planCreate,
+			},
+		}
+	}
+
+	change := planChange{
+		Resource: planTopic,
+		Name:     expected.Name,
+		Field:    "partitions",
+		Current:  strconv.Itoa(live.Partitions),
+		Expected: strconv.Itoa(expected.NumPartitions),
+	}
+	switch {
+	case live.Partitions < expected.NumPartitions:
+		change.Action = planUpdate
+		changes = append(changes, change)
+	case live.Partitions > expected.NumPartitions:
+		change.Action = planManual
+		change.Reason = "the number of partitions of a topic cannot be decreased"
+		changes = append(changes, change)
+	}
+
+	if live.ReplicationFactor != expected.ReplicationFactor {
+		changes = append(changes, planChange{
+			Resource: planTopic,
+			Name:     expected.Name,
+			Action:   planManual,
+			Field:    "replicationFactor",
+			Current:  strconv.Itoa(live.ReplicationFactor),
+			Expected: strconv.Itoa(expected.ReplicationFactor),
+			Reason:   "the replication factor must be changed with a partition reassignment",
+		})
+	}
+
+	for _, config := range expected.TopicConfig {
+		if current, ok := live.Config[config.Key]; !ok || current != config.Value {
+			changes = append(changes, planChange{
+				Resource: planTopic,
+				Name:     expected.Name,
+				Action:   planUpdate,
+				Field:    "config." + config.Key,
+				Current:  current,
+				Expected: config.Value,
+			})
+		}
+	}
+	return
+}
+
+// planIndex permit to compare the index, alias and mapping of the schema
+// with the sink. Indexes computed from a pattern are created on the fly
+// and indexes not created by synker are ignored
+func (c *Validate) planIndex(ctx context.Context, index int) (changes []planChange, err error) {
+	v := c.validatedSchemas.Schemas[index]
+	if c.indexPattern(index) != "" || !v.Elasticsearch.Index.Create {
+		return
+	}
+	name := strings.TrimSpace(v.Elasticsearch.Index.Name)
+	alias := strings.TrimSpace(v.Elasticsearch.Index.Alias)
+
+	sink, err := c.sink(index)
+	if err != nil {
+		return
+	}
+
+	// the alias may point to a versioned index created by a reindex
+	target := name
+	var indexes []string
+	if alias != "" {
+		if indexes, err = sink.AliasIndexes(ctx, alias); err != nil {
+			return
+		}
+		if len(indexes) > 0 && !slices.Contains(indexes, name) {
+			target = indexes[0]
+		}
+	}
+
+	exist, err := sink.IndexExists(ctx, target)
+	if err != nil {
+		return
+	}
+	if exist {
+		if changes, err = c.planMapping(ctx, sink, index, target); err != nil {
+			return
+		}
+	} else {
+		changes = append(changes, planChange{
+			Schema:   v.Name,
+			Resource: planIndex,
+			Name:     target,
+			Action:   planCreate,
+			index:    index,
+		})
+	}
+
+	if alias != "" && len(indexes) == 0 {
+		changes = append(changes, planChange{
+			Schema:   v.Name,
+			Resource: planAlias,
+			Name:     alias,
+			Action:   planCreate,
+			Expected: name,
+			index:    index,
+		})
+	}
+	return
+}
+
+// planMapping permit to compare the mapping of the schema with the one of the index.
+// Changed fields are grouped into one replace of the index
+// which also adds the new fields so no update is planned with it
+func (c *Validate) planMapping(ctx context.Context, sink sink, index int, name string) (changes []planChange, err error) {
+	v := c.validatedSchemas.Schemas[index]
+	live, err := sink.Mapping(ctx, name)
+	if err != nil {
+		return
+	}
+	expected, _ := v.Elasticsearch.Mapping["mappings"].(map[string]interface{})
+
+	var fields, conflicts []string
+	for _, z := range mappingChanges(expected, live) {
+		if z.Conflict {
+			fields = append(fields, z.Field)
+			conflicts = append(conflicts, fmt.Sprintf("%s from %q to %q", z.Field, z.Current, z.Expected))
+			continue
+		}
+		changes = append(changes, planChange{
+			Schema:   v.Name,
+			Resource: planMapping,
+			Name:     name,
+			Action:   planUpdate,
+			Field:    z.Field,
+			Current:  z.Current,
+			Expected: z.Expected,
+			index:    index,
+		})
+	}
+	if len(conflicts) == 0 {
+		return
+	}
+	return []planChange{
+		{
+			Schema:      v.Name,
+			Resource:    planMapping,
+			Name:        name,
+			Action:      planReplace,
+			Field:       strings.Join(fields, ", "),
+			Destructive: true,
+			Reason:      fmt.Sprintf("existing fields cannot be changed (%s), use synker reindex or recreate the index", strings.Join(conflicts, ", ")),
+			index:       index,
+		},
+	}, nil
+}
+
+// mappingProperties return the properties of the mapping
+func mappingProperties(m map[string]interface{}) map[string]interface{} {
+	z, _ := m["properties"].(map[string]interface{})
+	return z
+}
+
+// mappingType return the type of the field which defaults to object
+func mappingType(m map[string]interface{}) string {
+	if z, ok := m["type"].(string); ok {
+		return z
+	}
+	return "object"
+}
+
+// mappingChanges return the fields of the expected mapping
+// missing or defined differently in the live mapping.
+// Fields only present in the live mapping like dynamic ones are ignored
+func mappingChanges(expected, live map[string]interface{}) []mappingChange {
+	return propertiesChanges("properties.", mappingProperties(expected), mappingProperties(live))
+}
+
+// propertiesChanges return the differences between expected and live properties
+func propertiesChanges(prefix string, expected, live map[string]interface{}) (z []mappingChange) {
+	var names []string
+	for name := range expected {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		field := prefix + name
+		e, _ := expected[name].(map[string]interface{})
+		current, ok := live[name].(map[string]interface{})
+		if !ok {
+			z = append(z, mappingChange{Field: field, Expected: mappingType(e)})
+			continue
+		}
+		if mappingType(e) != mappingType(current) {
+			z = append(z, mappingChange{Field: field + ".type", Current: mappingType(current), Expected: mappingType(e), Conflict: true})
+			continue
+		}
+
+		var params []string
+		for param := range e {
+			params = append(params, param)
+		}
+		sort.Strings(params)
+		for _, param := range params {
+			if param == "type" || param == "properties" {
+				continue
+			}
+			// only scalar parameters like normalizer or format are compared
+			switch e[param].(type) {
+			case map[string]interface{}, []interface{}:
+				continue
+			}
+			cv, ok := current[param]
+			if !ok || fmt.Sprint(cv) != fmt.Sprint(e[param]) {
+				change := mappingChange{Field: field + "." + param, Expected: fmt.Sprint(e[param]), Conflict: true}
+				if ok {
+					change.Current = fmt.Sprint(cv)
+				}
+				z = append(z, change)
+			}
+		}
+		z = append(z, propertiesChanges(field+".properties.", mappingProperties(e), mappingProperties(current))...)
+	}
+	return
+}
+
+// planChangeFeed permit to compare the options of the changefeed of the schema
+// with the ones of its running or paused jobs
+func (c *Validate) planChangeFeed(ctx context.Context, index int) (changes []planChange, err error) {
+	v := c.validatedSchemas.Schemas[index]
+	if !cockroachFormat(c.messageFormat(index)) {
+		return
+	}
+	expected, err := changeFeedOptions(v.ChangeFeed, v.Format)
+	if err != nil {
+		return
+	}
+	jobs, err := c.changeFeedJobs(ctx, v.ChangeFeed)
+	if err != nil {
+		return nil, fmt.Errorf("Fail to retrieve changefeed jobs of table %s: %w", v.ChangeFeed.FullTableName, err)
+	}
+	for _, change := range changeFeedChanges(v.ChangeFeed.FullTableName, expected, jobs) {
+		change.Schema = v.Name
+		change.index = index
+		changes = append(changes, change)
+	}
+	return
+}
+
+// changeFeedChanges return the differences between the expected options
+// and the changefeed jobs of the table
+func changeFeedChanges(fullTableName string, expected []string, jobs []changeFeedJob) []planChange {
+	if len(jobs) == 0 {
+		return []planChange{
+			{
+				Resource: planChangeFeed,
+				Name:     fullTableName,
+				Action:   planCreate,
+				Expected: strings.Join(normalizeChangeFeedOptions(expected), ", "),
+			},
+		}
+	}
+
+	want := normalizeChangeFeedOptions(expected)
+	for _, job := range jobs {
+		if slices.Equal(want, normalizeChangeFeedOptions(changeFeedDescriptionOptions(job.Description))) {
+			return nil
+		}
+	}
+	return []planChange{
+		{
+			Resource:    planChangeFeed,
+			Name:        fullTableName,
+			Action:      planReplace,
+			Field:       "options",
+			Current:     strings.Join(normalizeChangeFeedOptions(changeFeedDescriptionOptions(jobs[0].Description)), ", "),
+			Expected:    strings.Join(want, ", "),
+			Destructive: true,
+			Reason:      fmt.Sprintf("job %d is cancelled and a new changefeed is created from its high-water timestamp", jobs[0].Id),
+			job:         jobs[0],
+		},
+	}
+}
+
+// normalizeChangeFeedOptions return the sorted options with lowercase names
+// and unquoted values like on_error=pause.
+// The cursor option is ignored as it is only used when the changefeed is created
+func normalizeChangeFeedOptions(options []string) (z []string) {
+	for _, option := range options {
+		k, v, ok := strings.Cut(option, "=")
+		k = strings.ToLower(strings.TrimSpace(k))
+		if k == "" || k == "cursor" {
+			continue
+		}
+		if !ok {
+			z = append(z, k)
+			continue
+		}
+		v = strings.TrimSpace(v)
+		if len(v) >= 2 && strings.HasPrefix(v, "'") && strings.HasSuffix(v, "'") {
+			v = strings.ReplaceAll(v[1:len(v)-1], "''", "'")
+		}
+		z = append(z, k+"="+v)
+	}
+	sort.Strings(z)
+	return
+}
+
+// changeFeedDescriptionOptions return the options of the statement that created the changefeed
+// like CREATE CHANGEFEED FOR TABLE t INTO 'kafka://...' WITH OPTIONS (diff, on_error = 'pause').
+// The cdc query following the options is ignored
+func changeFeedDescriptionOptions(description string) (options []string) {
+	if i := strings.Index(strings.ToUpper(description), " AS SELECT "); i != -1 {
+		description = description[:i]
+	}
+	i := strings.LastIndex(strings.ToUpper(description), " WITH ")
+	if i == -1 {
+		return
+	}
+	z := strings.TrimSpace(description[i+len(" WITH "):])
+	if strings.HasPrefix(strings.ToUpper(z), "OPTIONS") {
+		z = strings.TrimSpace(z[len("OPTIONS"):])
+	}
+	z = strings.TrimSuffix(strings.TrimPrefix(z, "("), ")")
+
+	// commas of quoted values are not separators
+	var current strings.Builder
+	quoted := false
+	for _, r := range z {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+		case r == ',' && !quoted:
+			options = append(options, strings.TrimSpace(current.String()))
+			current.Reset()
+			continue
+		}
+		current.WriteRune(r)
+	}
+	if strings.TrimSpace(current.String()) != "" {
+		options = append(options, strings.TrimSpace(current.String()))
+	}
+	return
+}
+
+// planSummary return the number of changes of each action
+func planSummary(changes []planChange) (z planOutput) {
+	z.Changes = changes
+	if z.Changes == nil {
+		z.Changes = []planChange{}
+	}
+	for _, change := range changes {
+		switch change.Action {
+		case planCreate:
+			z.Create++
+		case planUpdate:
+			z.Update++
+		case planReplace:
+			z.Replace++
+		case planManual:
+			z.Manual++
+		}
+	}
+	return
+}
+
+// writePlan permit to print the plan in a human readable format
+func writePlan(w io.Writer, changes []planChange) (err error) {
+	symbols := map[string]string{
+		planCreate:  "+",
+		planUpdate:  "~",
+		planReplace: "-/+",
+		planManual:  "!",
+	}
+	schema := ""
+	for _, change := range changes {
+		if change.Schema != schema {
+			schema = change.Schema
+			if _, err = fmt.Fprintf(w, "Schema %s\n", schema); err != nil {
+				return
+			}
+		}
+		line := fmt.Sprintf("  %s %s %s", symbols[change.Action], change.Resource, change.Name)
+		switch {
+		case change.Field != "" && change.Current == "" && change.Expected == "":
+			line += " " + change.Field
+		case change.Field != "":
+			line += fmt.Sprintf(" %s: %q => %q", change.Field, change.Current, change.Expected)
+		case change.Expected != "":
+			line += fmt.Sprintf(" => %s", change.Expected)
+		}
+		if change.Destructive {
+			line += " (destructive)"
+		}
+		if change.Reason != "" {
+			line += fmt.Sprintf("\n      %s", change.Reason)
+		}
+		if _, err = fmt.Fprintln(w, line); err != nil {
+			return
+		}
+	}
+
+	summary := planSummary(changes)
+	if len(changes) == 0 {
+		_, err = fmt.Fprintln(w, "No changes, the infrastructure matches the schemas")
+		return
+	}
+	_, err = fmt.Fprintf(w, "Plan: %d to create, %d to update, %d to replace, %d manual\n", summary.Create, summary.Update, summary.Replace, summary.Manual)
+	return
+}
+
+// writePlanJSON permit to print the plan in JSON format
+func writePlanJSON(w io.Writer, changes []planChange) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(planSummary(changes))
+}
+
+// apply permit to apply the safe changes of the plan
+// and the destructive ones when allowed
+func (c *Validate) apply(ctx context.Context, changes []planChange, options ApplyOptions) (err error) {
+	summary := planSummary(changes)
+	if summary.Replace > 0 && !options.AllowDestructive {
+		return fmt.Errorf("%d destructive changes refused, use --allow-destructive to apply them", summary.Replace)
+	}
+
+	var client *kafka.Client
+	mappings := make(map[string]bool)
+	for _, change := range changes {
+		if change.Action == planManual {
+			c.Logger.Warn().Msgf("Change of %s %s field %s on schema %s must be applied manually: %s", change.Resource, change.Name, change.Field, change.Schema, change.Reason)
+			continue
+		}
+		if change.Resource == planTopic && change.Action != planCreate && client == nil {
+			if client, err = c.kAdmin(); err != nil {
+				return
+			}
+		}
+		// all added fields of an index are put at once
+		if change.Resource == planMapping && change.Action == planUpdate {
+			if mappings[change.Name] {
+				continue
+			}
+			mappings[change.Name] = true
+		}
+		name := change.Name
+		if change.Field != "" {
+			name += " " + change.Field
+		}
+		if err = c.applyChange(ctx, client, change); err != nil {
+			return fmt.Errorf("Fail to %s %s %s on schema %s: %w", change.Action, change.Resource, name, change.Schema, err)
+		}
+		c.Logger.Info().Msgf("Change %s of %s %s applied on schema %s", change.Action, change.Resource, name, change.Schema)
+	}
+	return
+}
+
+// applyChange permit to apply one change of the plan
+func (c *Validate) applyChange(ctx context.Context, client *kafka.Client, change planChange) (err error) {
+	v := c.validatedSchemas.Schemas[change.index]
+	switch change.Resource {
+	case planTopic:
+		for _, topic := range c.expectedTopics(change.index) {
+			if topic.Name != change.Name {
+				continue
+			}
+			switch {
+			case change.Action == planCreate:
+				return c.createTopic(topic)
+			case change.Field == "partitions":
+				return c.createPartitions(ctx, client, topic.Name, topic.NumPartitions)
+			case strings.HasPrefix(change.Field, "config."):
+				key := strings.TrimPrefix(change.Field, "config.")
+				return c.alterTopicConfig(ctx, client, topic.Name, []topicConfig{{Key: key, Value: change.Expected}})
+			}
+		}
+		return fmt.Errorf("Change of field %s is not supported", change.Field)
+
+	case planIndex:
+		sink, err := c.sink(change.index)
+		if err != nil {
+			return err
+		}
+		_, err = sink.EnsureIndex(ctx, change.Name, v.Elasticsearch.Mapping)
+		return err
+
+	case planAlias:
+		sink, err := c.sink(change.index)
+		if err != nil {
+			return err
+		}
+		if _, err = sink.EnsureIndex(ctx, change.Expected, v.Elasticsearch.Mapping); err != nil {
+			return err
+		}
+		return sink.AddAlias(ctx, change.Expected, change.Name, true)
+
+	case planMapping:
+		sink, err := c.sink(change.index)
+		if err != nil {
+			return err
+		}
+		expected, _ := v.Elasticsearch.Mapping["mappings"].(map[string]interface{})
+		if change.Action == planUpdate {
+			return sink.PutMapping(ctx, change.Name, expected)
+		}
+		// existing fields cannot be changed so the index is recreated empty
+		aliases, err := sink.Aliases(ctx, change.Name)
+		if err != nil {
+			return err
+		}
+		if err = sink.DeleteIndex(ctx, change.Name); err != nil {
+			return err
+		}
+		if _, err = sink.EnsureIndex(ctx, change.Name, v.Elasticsearch.Mapping); err != nil {
+			return err
+		}
+		for _, alias := range aliases {
+			if err = sink.AddAlias(ctx, change.Name, alias, alias == strings.TrimSpace(v.Elasticsearch.Index.Alias)); err != nil {
+				return err
+			}
+		}
+		c.Logger.Warn().Msgf("Index %s has been recreated empty, run synker backfill to index existing rows", change.Name)
+		return nil
+
+	case planChangeFeed:
+		changefeed := v.ChangeFeed
+		if change.Action == planReplace {
+			if err = c.cancelChangeFeed(ctx, change.job.Id); err != nil {
+				return err
+			}
+			// the new changefeed starts where the cancelled one stopped
+			changefeed = changeFeedFrom(changefeed, change.job.HighWater)
+		}
+		_, err = c.createChangeFeed(ctx, changefeed, v.Format)
+		return err
+	}
+	return fmt.Errorf("Resource %s is not supported", change.Resource)
+}
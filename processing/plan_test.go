@@ -0,0 +1,258 @@
+// Package processing provide all requirements to process change data capture
+package processing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/Lord-Y/synker/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTopicChanges(t *testing.T) {
+	assert := assert.New(t)
+
+	expected := createTopicModel{
+		Name:              "users",
+		NumPartitions:     3,
+		ReplicationFactor: 3,
+		TopicConfig:       []topicConfig{{Key: "max.message.bytes", Value: "128000"}},
+	}
+
+	changes := topicChanges(expected, topicState{})
+	assert.Equal(1, len(changes))
+	assert.Equal(planCreate, changes[0].Action)
+
+	changes = topicChanges(expected, topicState{
+		Exists:            true,
+		Partitions:        3,
+		ReplicationFactor: 3,
+		Config:            map[string]string{"max.message.bytes": "128000"},
+	})
+	assert.Equal(0, len(changes))
+
+	changes = topicChanges(expected, topicState{
+		Exists:            true,
+		Partitions:        1,
+		ReplicationFactor: 1,
+		Config:            map[string]string{"max.message.bytes": "1048588"},
+	})
+	assert.Equal(3, len(changes))
+	assert.Equal(planUpdate, changes[0].Action)
+	assert.Equal("partitions", changes[0].Field)
+	assert.Equal(planManual, changes[1].Action)
+	assert.Equal("replicationFactor", changes[1].Field)
+	assert.Equal(planUpdate, changes[2].Action)
+	assert.Equal("config.max.message.bytes", changes[2].Field)
+	assert.Equal("1048588", changes[2].Current)
+
+	changes = topicChanges(expected, topicState{
+		Exists:            true,
+		Partitions:        6,
+		ReplicationFactor: 3,
+		Config:            map[string]string{"max.message.bytes": "128000"},
+	})
+	assert.Equal(1, len(changes))
+	assert.Equal(planManual, changes[0].Action)
+}
+
+func TestMappingChanges(t *testing.T) {
+	assert := assert.New(t)
+
+	expected := map[string]interface{}{
+		"properties": map[string]interface{}{
+			"code":   map[string]interface{}{"type": "keyword", "normalizer": "lowerasciinormalizer"},
+			"amount": map[string]interface{}{"type": "double"},
+			"rules": map[string]interface{}{
+				"properties": map[string]interface{}{
+					"type": map[string]interface{}{"type": "keyword"},
+				},
+			},
+			"created": map[string]interface{}{"type": "date", "format": "strict_date_optional_time"},
+		},
+	}
+	live := map[string]interface{}{
+		"properties": map[string]interface{}{
+			"code":    map[string]interface{}{"type": "text"},
+			"rules":   map[string]interface{}{"properties": map[string]interface{}{}},
+			"created": map[string]interface{}{"type": "date"},
+			"dynamic": map[string]interface{}{"type": "keyword"},
+		},
+	}
+
+	changes := mappingChanges(expected, live)
+	assert.Equal([]mappingChange{
+		{Field: "properties.amount", Expected: "double"},
+		{Field: "properties.code.type", Current: "text", Expected: "keyword", Conflict: true},
+		{Field: "properties.created.format", Expected: "strict_date_optional_time", Conflict: true},
+		{Field: "properties.rules.properties.type", Expected: "keyword"},
+	}, changes)
+
+	assert.Equal(0, len(mappingChanges(expected, expected)))
+}
+
+func TestChangeFeedDescriptionOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	description := "CREATE CHANGEFEED FOR TABLE movr.public.promo_codes INTO 'kafka://redpanda:29092' WITH OPTIONS (diff, full_table_name, on_error = 'pause', confluent_schema_registry = 'http://registry:8081/a,b', updated)"
+	assert.Equal([]string{
+		"diff",
+		"full_table_name",
+		"on_error = 'pause'",
+		"confluent_schema_registry = 'http://registry:8081/a,b'",
+		"updated",
+	}, changeFeedDescriptionOptions(description))
+	assert.Equal(0, len(changeFeedDescriptionOptions("CREATE CHANGEFEED FOR TABLE t INTO 'kafka://redpanda:29092'")))
+
+	assert.Equal(
+		[]string{"diff", "on_error=pause", "updated"},
+		normalizeChangeFeedOptions([]string{"updated", "ON_ERROR = 'pause'", "diff", "cursor = '1.0'"}),
+	)
+}
+
+func TestChangeFeedChanges(t *testing.T) {
+	assert := assert.New(t)
+
+	expected := []string{"updated", "on_error = 'pause'", "diff"}
+	changes := changeFeedChanges("movr.public.users", expected, nil)
+	assert.Equal(1, len(changes))
+	assert.Equal(planCreate, changes[0].Action)
+
+	jobs := []changeFeedJob{
+		{Id: 1, Status: "running", Description: "CREATE CHANGEFEED FOR TABLE movr.public.users INTO 'kafka://redpanda:29092' WITH OPTIONS (diff, updated)", HighWater: "1.0"},
+	}
+	changes = changeFeedChanges("movr.public.users", expected, jobs)
+	assert.Equal(1, len(changes))
+	assert.Equal(planReplace, changes[0].Action)
+	assert.Equal(true, changes[0].Destructive)
+	assert.Equal(int64(1), changes[0].job.Id)
+
+	jobs = append(jobs, changeFeedJob{Id: 2, Status: "paused", Description: "CREATE CHANGEFEED FOR TABLE movr.public.users INTO 'kafka://redpanda:29092' WITH OPTIONS (diff, on_error = 'pause', updated, cursor = '1.0')"})
+	assert.Equal(0, len(changeFeedChanges("movr.public.users", expected, jobs)))
+}
+
+func TestPlanIndex(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	var c Validate
+	c.Logger = logger.NewLogger()
+	mapping := map[string]interface{}{
+		"mappings": map[string]interface{}{
+			"properties": map[string]interface{}{
+				"code": map[string]interface{}{"type": "keyword"},
+			},
+		},
+	}
+	c.validatedSchemas.Schemas = []configSchema{
+		{
+			Name: "users",
+			Elasticsearch: elasticsearchSchema{
+				Index:   elasticsearchIndex{Name: "users", Alias: "users_alias", Create: true},
+				Mapping: mapping,
+			},
+		},
+	}
+	memory := newMemorySink()
+	c.clients.sinks = map[string]sink{sinkElasticsearch: memory}
+
+	changes, err := c.planIndex(ctx, 0)
+	assert.Nil(err)
+	assert.Equal(2, len(changes))
+	assert.Equal(planIndex, changes[0].Resource)
+	assert.Equal(planAlias, changes[1].Resource)
+	assert.Nil(c.apply(ctx, changes, ApplyOptions{}))
+
+	changes, err = c.planIndex(ctx, 0)
+	assert.Nil(err)
+	assert.Equal(0, len(changes))
+
+	// added fields are put
+	mapping = map[string]interface{}{
+		"mappings": map[string]interface{}{
+			"properties": map[string]interface{}{
+				"code":   map[string]interface{}{"type": "keyword"},
+				"amount": map[string]interface{}{"type": "double"},
+			},
+		},
+	}
+	c.validatedSchemas.Schemas[0].Elasticsearch.Mapping = mapping
+	changes, err = c.planIndex(ctx, 0)
+	assert.Nil(err)
+	assert.Equal(1, len(changes))
+	assert.Equal(planUpdate, changes[0].Action)
+	assert.Equal("properties.amount", changes[0].Field)
+	assert.Nil(c.apply(ctx, changes, ApplyOptions{}))
+
+	// changed fields require the index to be recreated once with the added ones
+	mapping = map[string]interface{}{
+		"mappings": map[string]interface{}{
+			"properties": map[string]interface{}{
+				"code":    map[string]interface{}{"type": "text"},
+				"amount":  map[string]interface{}{"type": "long"},
+				"created": map[string]interface{}{"type": "date"},
+			},
+		},
+	}
+	c.validatedSchemas.Schemas[0].Elasticsearch.Mapping = mapping
+	changes, err = c.planIndex(ctx, 0)
+	assert.Nil(err)
+	assert.Equal(1, len(changes))
+	assert.Equal(planReplace, changes[0].Action)
+	assert.Equal("properties.amount.type, properties.code.type", changes[0].Field)
+	assert.Contains(changes[0].Reason, `properties.code.type from "keyword" to "text"`)
+
+	assert.Nil(memory.Upsert(ctx, "users", "1", map[string]interface{}{"code": "a"}, 0))
+	assert.Error(c.apply(ctx, changes, ApplyOptions{}))
+	assert.Nil(c.apply(ctx, changes, ApplyOptions{AllowDestructive: true}))
+	count, err := memory.Count(ctx, "users_alias")
+	assert.Nil(err)
+	assert.Equal(int64(0), count)
+	changes, err = c.planIndex(ctx, 0)
+	assert.Nil(err)
+	assert.Equal(0, len(changes))
+
+	// a reindex moved the alias to a versioned index
+	_, err = memory.EnsureIndex(ctx, "users-v2", mapping)
+	assert.Nil(err)
+	assert.Nil(memory.UpdateAliases(ctx, []aliasAction{
+		{Remove: true, Index: "users", Alias: "users_alias"},
+		{Index: "users-v2", Alias: "users_alias", WriteIndex: true},
+	}))
+	assert.Nil(memory.DeleteIndex(ctx, "users"))
+	changes, err = c.planIndex(ctx, 0)
+	assert.Nil(err)
+	assert.Equal(0, len(changes))
+}
+
+func TestWritePlan(t *testing.T) {
+	assert := assert.New(t)
+
+	changes := []planChange{
+		{Schema: "users", Resource: planTopic, Name: "users", Action: planCreate},
+		{Schema: "users", Resource: planTopic, Name: "users_dlq", Action: planUpdate, Field: "partitions", Current: "1", Expected: "3"},
+		{Schema: "users", Resource: planMapping, Name: "users", Action: planReplace, Field: "properties.code.type", Current: "text", Expected: "keyword", Destructive: true, Reason: "existing fields cannot be changed"},
+	}
+
+	var b bytes.Buffer
+	assert.Nil(writePlan(&b, changes))
+	assert.Contains(b.String(), "Schema users\n")
+	assert.Contains(b.String(), "  + topic users\n")
+	assert.Contains(b.String(), `  ~ topic users_dlq partitions: "1" => "3"`)
+	assert.Contains(b.String(), "(destructive)")
+	assert.Contains(b.String(), "Plan: 1 to create, 1 to update, 1 to replace, 0 manual")
+
+	b.Reset()
+	assert.Nil(writePlan(&b, nil))
+	assert.Contains(b.String(), "No changes")
+
+	b.Reset()
+	assert.Nil(writePlanJSON(&b, changes))
+	var output planOutput
+	assert.Nil(json.Unmarshal(b.Bytes(), &output))
+	assert.Equal(3, len(output.Changes))
+	assert.Equal(1, output.Replace)
+	assert.Equal(true, output.Changes[2].Destructive)
+}
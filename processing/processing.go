@@ -5,22 +5,23 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
 	"reflect"
-	"strconv"
+	"slices"
 	"strings"
 	"sync"
 
+	"github.com/Lord-Y/synker/commons"
 	"github.com/Lord-Y/synker/tools"
 	"github.com/go-playground/validator/v10"
 	"github.com/google/uuid"
 	"github.com/mitchellh/mapstructure"
 	"github.com/nqd/flat"
-	"github.com/olivere/elastic/v7"
 	kafkago "github.com/segmentio/kafka-go"
 	"gopkg.in/yaml.v3"
 )
@@ -123,7 +124,43 @@ func (c *Validate) parsing() (file string, err error) {
 				return file, err
 			}
 		}
+		// topic names are derived first as other validations depend on them
+		for k := range z.Schemas {
+			if err := validateTopic(&z.Schemas[k]); err != nil {
+				return file, fmt.Errorf("%s on schema %s", err.Error(), z.Schemas[k].Name)
+			}
+		}
 		for _, v := range z.Schemas {
+			if _, err := newBulkSettings(v.Elasticsearch.Bulk); err != nil {
+				return file, fmt.Errorf("%s on schema %s", err.Error(), v.Name)
+			}
+			if err := validateDocumentId(v.Elasticsearch.DocumentId); err != nil {
+				return file, fmt.Errorf("%s on schema %s", err.Error(), v.Name)
+			}
+			if err := validateAggregate(v.SQL.Aggregate); err != nil {
+				return file, fmt.Errorf("%s on schema %s", err.Error(), v.Name)
+			}
+			if err := validateDependencies(v); err != nil {
+				return file, fmt.Errorf("%s on schema %s", err.Error(), v.Name)
+			}
+			if err := validateTransform(v.Transform); err != nil {
+				return file, fmt.Errorf("%s on schema %s", err.Error(), v.Name)
+			}
+			if err := validateFilter(v.Filter); err != nil {
+				return file, fmt.Errorf("%s on schema %s", err.Error(), v.Name)
+			}
+			if err := validateIndexPattern(v); err != nil {
+				return file, fmt.Errorf("%s on schema %s", err.Error(), v.Name)
+			}
+			if err := validateFormat(v); err != nil {
+				return file, fmt.Errorf("%s on schema %s", err.Error(), v.Name)
+			}
+			if err := validateChangeFeedSink(v); err != nil {
+				return file, fmt.Errorf("%s on schema %s", err.Error(), v.Name)
+			}
+			if err := validateChangeFeedSelect(v); err != nil {
+				return file, fmt.Errorf("%s on schema %s", err.Error(), v.Name)
+			}
 			if !reflect.ValueOf(v.SQL).IsZero() {
 				query := strings.TrimSpace(v.SQL.Query)
 				if query != "" {
@@ -151,114 +188,100 @@ func (c *Validate) parsing() (file string, err error) {
 	return
 }
 
-// manageTopics permit to create or update topics
-func (c *Validate) manageTopics() (err error) {
-	ls, err := c.kClient()
+// manageTopics permit to create topics with their config
+// and reconcile existing ones with the schemas.
+// Config entries are altered and partitions increased while
+// partition decreases and replication factor changes are refused
+func (c *Validate) manageTopics(ctx context.Context) (err error) {
+	changes, err := c.planTopics(ctx)
 	if err != nil {
-		return err
+		return
 	}
 
-	topics, err := c.listTopics(ls)
-	if err != nil {
-		return err
+	var z []planChange
+	for k := range c.validatedSchemas.Schemas {
+		z = append(z, changes[k]...)
+	}
+	if err = refuseTopicChanges(z); err != nil {
+		return
+	}
+	if len(z) == 0 {
+		return
 	}
 
-	for _, v := range c.validatedSchemas.Schemas {
-		if !tools.InSlice(v.Topic.Name, topics) {
-			client, err := c.kClient()
-			if err != nil {
-				return err
-			}
-			err = c.createTopic(
-				client,
-				createTopicModel{
-					Name:              v.Topic.Name,
-					NumPartitions:     v.Topic.NumPartitions,
-					ReplicationFactor: v.Topic.ReplicationFactor,
-				},
-			)
-			if err != nil {
-				return err
-			}
+	client, err := c.kAdmin()
+	if err != nil {
+		return
+	}
+	for _, change := range z {
+		if err = c.applyChange(ctx, client, change); err != nil {
+			return fmt.Errorf("Fail to %s topic %s on schema %s: %w", change.Action, change.Name, change.Schema, err)
+		}
+		if change.Field == "" {
+			c.Logger.Info().Msgf("Topic %s created on schema %s", change.Name, change.Schema)
+			continue
 		}
+		c.Logger.Info().Msgf("Topic %s updated on schema %s with %s from %s to %s", change.Name, change.Schema, change.Field, change.Current, change.Expected)
 	}
 	return
 }
 
-// manageElasticsearchIndex permit to check or create elasticsearch index
-func (c *Validate) manageElasticsearchIndex() (err error) {
-	client, err := c.eClient()
-	if err != nil {
-		return err
+// refuseTopicChanges return an error listing the changes of topics
+// that cannot be applied like partition decreases or replication factor changes
+func refuseTopicChanges(changes []planChange) error {
+	var refused []string
+	for _, change := range changes {
+		if change.Action == planManual {
+			refused = append(refused, fmt.Sprintf("%s of topic %s on schema %s from %s to %s: %s", change.Field, change.Name, change.Schema, change.Current, change.Expected, change.Reason))
+		}
+	}
+	if len(refused) > 0 {
+		return fmt.Errorf("Topic changes refused: %s", strings.Join(refused, ", "))
 	}
-	defer client.Stop()
-	for _, v := range c.validatedSchemas.Schemas {
+	return nil
+}
+
+// manageElasticsearchIndex permit to check or create elasticsearch index
+func (c *Validate) manageElasticsearchIndex(ctx context.Context) (err error) {
+	for k, v := range c.validatedSchemas.Schemas {
+		// indexes computed from a pattern are created on the fly
+		if strings.TrimSpace(v.Elasticsearch.Index.Pattern) != "" {
+			continue
+		}
 		if v.Elasticsearch.Index.Create {
-			ctx := context.Background()
 			alias := strings.TrimSpace(v.Elasticsearch.Index.Alias)
 			index := strings.TrimSpace(v.Elasticsearch.Index.Name)
+			if alias != "" && index == alias {
+				return fmt.Errorf("Index %s and alias %s cannot have the same name on schema %s", index, alias, v.Name)
+			}
 
-			b, err := client.IndexExists(index).Do(ctx)
+			sink, err := c.sink(k)
 			if err != nil {
 				return err
 			}
-			if !b {
-				create, err := client.CreateIndex(index).
-					BodyJson(v.Elasticsearch.Mapping).Do(ctx)
+			if alias != "" {
+				// the alias may point to a versioned index created by a reindex
+				indexes, err := sink.AliasIndexes(ctx, alias)
 				if err != nil {
 					return err
 				}
-				if !create.Acknowledged {
-					return fmt.Errorf("Fail to get index creation acknowledgement")
-				}
-				if alias != "" {
-					if alias != "" && index != alias {
-						alias_create, err := client.Alias().
-							Add(index, alias).
-							Action(
-								elastic.NewAliasAddAction(alias).
-									Index(index).
-									IsWriteIndex(true),
-							).
-							Do(context.TODO())
-						if err != nil {
-							return err
-						}
-						if !alias_create.Acknowledged {
-							return fmt.Errorf("Fail to get alias creation acknowledgement")
-						}
-					} else {
-						return fmt.Errorf("Index %s and alias %s cannot have the same name on schema %s", index, alias, v.Name)
-					}
+				if len(indexes) > 0 && !slices.Contains(indexes, index) {
+					c.Logger.Debug().Msgf("Alias %s already points to indexes %v on schema %s", alias, indexes, v.Name)
+					continue
 				}
 			}
+			if _, err = sink.EnsureIndex(ctx, index, v.Elasticsearch.Mapping); err != nil {
+				return err
+			}
 			if alias != "" {
-				if alias != "" && index != alias {
-					list, err := client.Aliases().
-						Index(index).
-						Pretty(true).
-						Do(context.TODO())
-					if err != nil {
+				aliases, err := sink.Aliases(ctx, index)
+				if err != nil {
+					return err
+				}
+				if !slices.Contains(aliases, alias) {
+					if err = sink.AddAlias(ctx, index, alias, true); err != nil {
 						return err
 					}
-					if len(list.Indices) <= 1 {
-						alias_create, err := client.Alias().
-							Add(index, alias).
-							Action(
-								elastic.NewAliasAddAction(alias).
-									Index(index).
-									IsWriteIndex(true),
-							).
-							Do(context.TODO())
-						if err != nil {
-							return err
-						}
-						if !alias_create.Acknowledged {
-							return fmt.Errorf("Fail to get alias creation acknowledgement")
-						}
-					}
-				} else {
-					return fmt.Errorf("Index %s and alias %s cannot have the same name on schema %s", index, alias, v.Name)
 				}
 			}
 		}
@@ -267,485 +290,586 @@ func (c *Validate) manageElasticsearchIndex() (err error) {
 }
 
 // manageChangeFeed permit check and create required changefeed
-func (c *Validate) manageChangeFeed() (err error) {
-	for _, v := range c.validatedSchemas.Schemas {
-		count, err := countChangeFeed(v.ChangeFeed.FullTableName, "running")
+func (c *Validate) manageChangeFeed(ctx context.Context) (err error) {
+	for k, v := range c.validatedSchemas.Schemas {
+		// changes of other sources are published by debezium
+		if !cockroachFormat(c.messageFormat(k)) {
+			continue
+		}
+		jobs, err := c.changeFeedJobs(ctx, v.ChangeFeed, "running", "paused", "failed")
 		if err != nil {
-			c.Logger.Fatal().Err(err).Msgf("Fail to check if required changefeed %s on schema %s has status running", v.ChangeFeed.FullTableName, v.Name)
+			return fmt.Errorf("Fail to retrieve changefeed jobs of table %s on schema %s: %w", v.ChangeFeed.FullTableName, v.Name, err)
 		}
-		if count == 0 {
-			err = createChangeFeed(v.ChangeFeed)
+		job, action := startChangeFeedAction(c.changeFeedResumePolicy(), jobs)
+		switch action {
+		case changeFeedActionCreate:
+			id, err := c.createChangeFeed(ctx, v.ChangeFeed, v.Format)
+			if err != nil {
+				return fmt.Errorf("Fail to create changefeed %s on schema %s: %w", v.ChangeFeed.FullTableName, v.Name, err)
+			}
+			c.trackChangeFeed(v.Name, v.ChangeFeed.FullTableName, changeFeedJob{Id: id, Status: "running"})
+			continue
+
+		case changeFeedActionRecreate:
+			id, err := c.recreateChangeFeed(ctx, k, job.HighWater)
 			if err != nil {
-				c.Logger.Fatal().Err(err).Msgf("Fail to create changefeed %s on schema %s", v.ChangeFeed.FullTableName, v.Name)
+				return fmt.Errorf("Fail to recreate failed changefeed job %d of table %s on schema %s: %w", job.Id, v.ChangeFeed.FullTableName, v.Name, err)
 			}
+			c.Logger.Info().Msgf("Changefeed job %d of table %s on schema %s failed and has been recreated as job %d from %s", job.Id, v.ChangeFeed.FullTableName, v.Name, id, job.HighWater)
+			c.trackChangeFeed(v.Name, v.ChangeFeed.FullTableName, changeFeedJob{Id: id, Status: "running"})
+			continue
+		}
+
+		// a paused job is kept so it can be resumed instead of creating a duplicate
+		// and a failed one is reported until it is fixed manually
+		if active := activeChangeFeedJobs(jobs); len(active) > 1 {
+			c.Logger.Warn().Msgf("Changefeed of table %s on schema %s has %d running or paused jobs, job %d is tracked", v.ChangeFeed.FullTableName, v.Name, len(active), job.Id)
+		}
+		if job.Status == "failed" {
+			c.Logger.Warn().Msgf("Changefeed job %d of table %s on schema %s failed and is not recreated with the resume policy %s", job.Id, v.ChangeFeed.FullTableName, v.Name, c.changeFeedResumePolicy())
+		}
+		if job.Status == "paused" {
+			c.Logger.Warn().Msgf("Changefeed job %d of table %s on schema %s is paused", job.Id, v.ChangeFeed.FullTableName, v.Name)
 		}
+		c.trackChangeFeed(v.Name, v.ChangeFeed.FullTableName, *job)
 	}
 	return
 }
 
 // processing permit to start processing kafka messages and sent it to elasticsearch
-func (c *Validate) processing() {
+// until the provided context is cancelled
+func (c *Validate) processing(ctx context.Context) {
 	wg := sync.WaitGroup{}
+	if c.supervisor == nil {
+		c.supervisor = newSupervisor()
+	}
+	if c.changeFeeds == nil {
+		c.changeFeeds = newChangeFeedMonitor()
+	}
+
+	for k := range c.validatedSchemas.Schemas {
+		if cockroachFormat(c.messageFormat(k)) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				c.monitorChangeFeeds(ctx)
+			}()
+			break
+		}
+	}
 
 	for k, v := range c.validatedSchemas.Schemas {
 		wg.Add(1)
 		topic := v.Topic.Name
 		k := k
+		c.supervisor.register(v.Name, topic)
 		c.Logger.Debug().Msgf("Start processing on topic %s", topic)
 		go func() {
 			defer wg.Done()
-			c.consume(k, topic)
+			c.supervise(ctx, k)
 		}()
 	}
 	wg.Wait()
 }
 
-// consume permit to consume messages in kafka and sent it to elasticsearch
-func (c *Validate) consume(index int, topic string) {
-	conn, err := c.kClient()
-	if err != nil {
-		return
-	}
-	defer conn.Close()
+// consumerGroup return the kafka consumer group of the schema
+func consumerGroup(schema string) string {
+	return fmt.Sprintf("synker_%s", strings.TrimSpace(schema))
+}
 
-	connLeader, err := c.connectToController(conn)
+// consume permit to consume messages in kafka and sent it to elasticsearch.
+// Once the provided context is cancelled, the in-flight batch is drained
+// and nil is returned
+func (c *Validate) consume(ctx context.Context, index int, topic string) (err error) {
+	brokers, err := c.kBrokers()
 	if err != nil {
-		c.Logger.Fatal().Err(err).Msg("Fail to connect to the controller")
+		c.increaseMetrics("kafka", topic, "client")
+		return fmt.Errorf("Fail to retrieve kafka brokers: %w", err)
 	}
-	defer connLeader.Close()
 
-	brokerList, err := connLeader.Brokers()
+	dialer, err := c.kDialer()
 	if err != nil {
-		c.Logger.Fatal().Err(err).Msg("Brokers list cannot be empty")
-	}
-
-	var brokers []string
-	for _, v := range brokerList {
-		brokers = append(brokers, v.Host+":"+strconv.Itoa(conn.Broker().Port))
+		c.increaseMetrics("kafka", topic, "client")
+		return fmt.Errorf("Fail to create kafka dialer: %w", err)
 	}
 
 	r := kafkago.NewReader(kafkago.ReaderConfig{
 		Brokers:  brokers,
+		Dialer:   dialer,
 		Topic:    topic,
-		GroupID:  fmt.Sprintf("synker_%s", strings.TrimSpace(c.validatedSchemas.Schemas[index].Name)),
+		GroupID:  consumerGroup(c.validatedSchemas.Schemas[index].Name),
 		MinBytes: 1,
 		MaxBytes: 10e6,
 	})
 	defer r.Close()
 
-	ctx := context.Background()
-	for {
-		var (
-			message                          consumeMessage
-			value                            map[string]interface{}
-			key                              []string
-			mkBytes, mvBytes                 []byte
-			documentIndexed, documentDeleted bool
-			esIndex                          string
-		)
-
-		m, err := r.FetchMessage(ctx)
-		if err != nil {
-			c.Logger.Fatal().Err(err).Msgf("Fail to fetch kafka message from topic %s on partition %d and offset %d", topic, m.Partition, m.Offset)
-		}
-
-		c.Logger.Debug().Msgf("Message at topic/partition/offset %v/%v/%v: %s = %s", m.Topic, m.Partition, m.Offset, string(m.Key), string(m.Value))
-		mjson, err := json.Marshal(m)
-		if err != nil {
-			c.increaseMetrics("kafka", m.Topic, "marshalling")
-			c.Logger.Error().Err(err).Msgf("Fail to marshal kafka message from topic %s on partition %d and offset %d", m.Topic, m.Partition, m.Offset)
-			return
-		}
+	dlq := c.deadLetterTopic(index)
+	w, err := c.kWriter(brokers, dlq)
+	if err != nil {
+		return fmt.Errorf("Fail to create kafka writer on dead letter topic %s: %w", dlq, err)
+	}
+	defer w.Close()
 
-		err = json.Unmarshal(mjson, &message)
-		if err != nil {
-			c.increaseMetrics("kafka", m.Topic, "marshalling")
-			c.Logger.Error().Err(err).Msgf("Fail to unmarshal kafka message from topic %s on partition %d and offset %d", m.Topic, m.Partition, m.Offset)
-			return
-		}
+	settings, err := newBulkSettings(c.validatedSchemas.Schemas[index].Elasticsearch.Bulk)
+	if err != nil {
+		return fmt.Errorf("Fail to get bulk settings on schema %s: %w", c.validatedSchemas.Schemas[index].Name, err)
+	}
 
-		mkBytes, err = base64.StdEncoding.DecodeString(message.Key)
-		if err != nil {
-			c.increaseMetrics("kafka", m.Topic, "encoding")
-			c.Logger.Error().Err(err).Msgf("Fail to decode base64 field value from message from topic %s on partition %d and offset %d", m.Topic, m.Partition, m.Offset)
-			return
-		}
+	if _, err = c.sink(index); err != nil {
+		c.increaseMetrics("elasticsearch", topic, "client")
+		return fmt.Errorf("Fail to create sink for topic %s: %w", topic, err)
+	}
 
-		err = json.Unmarshal(mkBytes, &key)
+	// the in-flight batch must be sent and commited even if
+	// the shutdown is requested in the meantime
+	flushCtx := context.WithoutCancel(ctx)
+	// documents searched before being updated require the refresh of the index
+	refresh := c.documentIdStrategy(index) == documentIdUUID
+	for _, d := range c.dependents(index) {
+		refresh = refresh || c.documentIdStrategy(d.index) == documentIdUUID
+	}
+	batch := newBulkBatch(refresh)
+	for {
+		fetchCtx, cancel := batch.fetchContext(ctx, settings)
+		m, err := r.FetchMessage(fetchCtx)
+		cancel()
 		if err != nil {
-			c.increaseMetrics("kafka", m.Topic, "marshalling")
-			c.Logger.Error().Err(err).Msgf("Fail to unmarshal field key from kafka message from topic %s on partition %d and offset %d", m.Topic, m.Partition, m.Offset)
-			return
+			if ctx.Err() != nil {
+				return c.drain(flushCtx, r, w, index, batch, topic)
+			}
+			if errors.Is(err, context.DeadlineExceeded) {
+				if err := c.flushBatch(flushCtx, r, w, index, batch, topic); err != nil {
+					return fmt.Errorf("Fail to flush batch from topic %s: %w", topic, err)
+				}
+				continue
+			}
+			c.increaseMetrics("kafka", topic, "fetch")
+			return fmt.Errorf("Fail to fetch kafka message from topic %s: %w", topic, err)
 		}
 
-		mvBytes, err = base64.StdEncoding.DecodeString(message.Value)
-		if err != nil {
-			c.increaseMetrics("kafka", m.Topic, "encoding")
-			c.Logger.Error().Err(err).Msgf("Fail to decode base64 field value from message from topic %s on partition %d and offset %d", m.Topic, m.Partition, m.Offset)
-			return
+		c.Logger.Debug().Msgf("Message at topic/partition/offset %v/%v/%v: %s = %s", m.Topic, m.Partition, m.Offset, string(m.Key), string(m.Value))
+		if err = c.processMessage(ctx, index, batch, m); err != nil {
+			// the message has been interrupted by the shutdown
+			// so it will be consumed again on next start
+			if ctx.Err() != nil {
+				return c.drain(flushCtx, r, w, index, batch, topic)
+			}
+			if err := c.deadLetter(flushCtx, w, index, m, err); err != nil {
+				return fmt.Errorf("Fail to send kafka message from topic %s on partition %d and offset %d into dead letter topic %s: %w", m.Topic, m.Partition, m.Offset, dlq, err)
+			}
 		}
+		batch.add(m)
 
-		err = json.Unmarshal(mvBytes, &value)
-		if err != nil {
-			c.increaseMetrics("kafka", m.Topic, "marshalling")
-			c.Logger.Error().Err(err).Msgf("Fail to unmarshal field value from kafka message from topic %s on partition %d and offset %d", m.Topic, m.Partition, m.Offset)
-			return
+		if batch.full(settings) {
+			if err := c.flushBatch(flushCtx, r, w, index, batch, topic); err != nil {
+				return fmt.Errorf("Fail to flush batch from topic %s: %w", topic, err)
+			}
 		}
+	}
+}
 
-		if value["after"] != nil {
-			if reflect.ValueOf(c.validatedSchemas.Schemas[index].SQL).IsZero() {
-				exist, id, esTargetIndex, err := c.searchByVersion(index, value)
-				if err != nil {
-					c.increaseMetrics("elasticsearch", m.Topic, "indexing")
-					c.Logger.Error().Err(err).Msgf("Document already exist in elasticsearch index %s with kafka message from topic %s on partition %d and offset %d", esTargetIndex, m.Topic, m.Partition, m.Offset)
-					return
-				}
-
-				c.Logger.Debug().Msgf("Data exist in elasticsearch index %s? %t", esTargetIndex, exist)
-				err = c.indexNewContent(index, value, id)
-				if err != nil {
-					c.increaseMetrics("elasticsearch", m.Topic, "indexing")
-					c.Logger.Error().Err(err).Msgf("Fail to update elasticsearch document in index %s with kafka message from topic %s on partition %d and offset %d", esTargetIndex, m.Topic, m.Partition, m.Offset)
-					return
-				}
-				documentIndexed = true
-				esIndex = esTargetIndex
-			} else {
-				t := strings.Split(c.validatedSchemas.Schemas[index].ChangeFeed.FullTableName, ".")
-				var v map[string]interface{}
-				err = mapstructure.Decode(value["after"], &v)
-				if err != nil {
-					return
-				}
-
-				result, select_query, err := c.query(c.validatedSchemas.Schemas[index].SQL.Query, t[len(t)-1], v)
-				if err != nil {
-					c.increaseMetrics("elasticsearch", m.Topic, "sql")
-					c.Logger.Error().Err(err).Msgf("Fail to execute SQL query `%s` with kafka message from topic %s on partition %d and offset %d", select_query, m.Topic, m.Partition, m.Offset)
-					return
-				}
-
-				c.Logger.Debug().Msgf("result %+v query %+v", result, select_query)
-				exist, id, esTargetIndex, err := c.searchByVersion(index, value)
-				if err != nil {
-					c.increaseMetrics("elasticsearch", m.Topic, "indexing")
-					c.Logger.Error().Err(err).Msgf("Document already exist in elasticsearch index %s with kafka message from topic %s on partition %d and offset %d", esTargetIndex, m.Topic, m.Partition, m.Offset)
-					return
-				}
+// processMessage permit to add into the batch the requests of the kafka message
+// and the ones refreshing the documents depending on its row.
+// Requests are built into a scratch batch and only added once all of them succeeded
+// so a message sent into the dead letter topic leaves no request behind
+func (c *Validate) processMessage(ctx context.Context, index int, batch *bulkBatch, m kafkago.Message) (err error) {
+	event, skip, err := c.decodeMessage(ctx, index, m)
+	if err != nil {
+		return
+	}
+	if skip {
+		c.Logger.Debug().Msgf("Kafka message from topic %s on partition %d and offset %d does not hold any change", m.Topic, m.Partition, m.Offset)
+		return
+	}
 
-				c.Logger.Debug().Msgf("Data exist in elasticsearch? %t", exist)
-				var errorMessage string
-				if exist {
-					errorMessage = fmt.Sprintf("Fail to index kafka message from topic %s on partition %d and offset %d", m.Topic, m.Partition, m.Offset)
-				} else {
-					errorMessage = fmt.Sprintf("Fail to update elasticsearch document in index %s with kafka message from topic %s on partition %d and offset %d", esTargetIndex, m.Topic, m.Partition, m.Offset)
-				}
+	scratch := batch.scratch()
+	if err = c.batchMessage(ctx, index, scratch, m, event); err != nil {
+		return
+	}
+	if err = c.fanOut(ctx, index, scratch, m, event); err != nil {
+		return
+	}
+	batch.merge(scratch)
+	return
+}
 
-				err = c.indexNewContent(index, result, id)
-				if err != nil {
-					c.increaseMetrics("elasticsearch", m.Topic, "indexing")
-					c.Logger.Error().Err(err).Msgf("%s", errorMessage)
-					return
-				}
-				documentIndexed = true
-				esIndex = esTargetIndex
-			}
+// drain permit to send the in-flight batch and commit its kafka messages
+// within the drain timeout once the shutdown has been requested
+func (c *Validate) drain(ctx context.Context, r *kafkago.Reader, w *kafkago.Writer, index int, batch *bulkBatch, topic string) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, commons.GetDrainTimeout())
+	defer cancel()
 
-			if documentIndexed {
-				c.Logger.Debug().Msgf("Kafka message has been indexed into elasticsearch index `%s` from topic %s on partition %d and offset %d", esIndex, m.Topic, m.Partition, m.Offset)
-				if err := r.CommitMessages(ctx, m); err != nil {
-					c.increaseMetrics("kafka", m.Topic, "commit")
-					c.Logger.Error().Err(err).Msgf("Fail to commit kafka message from topic %s on partition %d and offset %d", m.Topic, m.Partition, m.Offset)
-					return
-				}
-				c.Logger.Debug().Msgf("Kafka message has been commited in topic %s on partition %d and offset %d", m.Topic, m.Partition, m.Offset)
-			}
-		} else {
-			exist, id, esTargetIndex, err := c.searchByVersion(index, value)
-			if err != nil {
-				c.increaseMetrics("elasticsearch", m.Topic, "indexing")
-				c.Logger.Error().Err(err).Msgf("Document with key(s) %s in elasticsearch index %s with kafka message from topic %s on partition %d and offset %d", key, esTargetIndex, m.Topic, m.Partition, m.Offset)
-				return
-			}
+	c.Logger.Info().Msgf("Draining %d kafka messages from topic %s", len(batch.messages), topic)
+	if err = c.flushBatch(ctx, r, w, index, batch, topic); err != nil {
+		c.Logger.Error().Err(err).Msgf("Fail to drain batch from topic %s", topic)
+		return nil
+	}
+	c.Logger.Info().Msgf("Consumer of topic %s stopped", topic)
+	return
+}
 
-			c.Logger.Debug().Msgf("Data exist in elasticsearch index %s? %t", esTargetIndex, exist)
+// decodeMessage permit to decode the key and the value of the kafka message
+// into a change event according to the format of the schema
+func (c *Validate) decodeMessage(ctx context.Context, index int, m kafkago.Message) (event changeEvent, skip bool, err error) {
+	var (
+		message          consumeMessage
+		mkBytes, mvBytes []byte
+	)
 
-			if exist {
-				err = c.deleteContent(index, id)
-				if err != nil {
-					c.increaseMetrics("elasticsearch", m.Topic, "delete")
-					c.Logger.Error().Err(err).Msgf("Fail to delete elasticsearch document with id %s in index %s with kafka message from topic %s on partition %d and offset %d", id, esTargetIndex, m.Topic, m.Partition, m.Offset)
-					return
-				}
-				documentDeleted = true
-				esIndex = esTargetIndex
-			}
+	mjson, err := json.Marshal(m)
+	if err != nil {
+		c.increaseMetrics("kafka", m.Topic, "marshalling")
+		c.Logger.Error().Err(err).Msgf("Fail to marshal kafka message from topic %s on partition %d and offset %d", m.Topic, m.Partition, m.Offset)
+		return
+	}
 
-			if documentDeleted {
-				c.Logger.Debug().Msgf("Kafka message has been deleted from elasticsearch index `%s` from topic %s on partition %d and offset %d", esIndex, m.Topic, m.Partition, m.Offset)
-				if err := r.CommitMessages(ctx, m); err != nil {
-					c.increaseMetrics("kafka", m.Topic, "commit")
-					c.Logger.Error().Err(err).Msgf("Fail to commit kafka message from topic %s on partition %d and offset %d", m.Topic, m.Partition, m.Offset)
-					return
-				}
-				c.Logger.Debug().Msgf("Kafka message has been commited in topic %s on partition %d and offset %d", m.Topic, m.Partition, m.Offset)
-			}
-		}
+	err = json.Unmarshal(mjson, &message)
+	if err != nil {
+		c.increaseMetrics("kafka", m.Topic, "marshalling")
+		c.Logger.Error().Err(err).Msgf("Fail to unmarshal kafka message from topic %s on partition %d and offset %d", m.Topic, m.Partition, m.Offset)
+		return
 	}
-}
 
-// searchByVersion permit to search into elasticsearch
-//
-// if the new data provided already exist
-//
-// if it exist, it will return the elasticsearch id
-//
-// if it exist multiple times, an error will be returned
-func (c *Validate) searchByVersion(index int, value map[string]interface{}) (found bool, id, esTargetIndex string, err error) {
-	client, err := c.eClient()
+	mkBytes, err = base64.StdEncoding.DecodeString(message.Key)
 	if err != nil {
+		c.increaseMetrics("kafka", m.Topic, "encoding")
+		c.Logger.Error().Err(err).Msgf("Fail to decode base64 field value from message from topic %s on partition %d and offset %d", m.Topic, m.Partition, m.Offset)
 		return
 	}
-	defer client.Stop()
 
-	ctx := context.Background()
-	esAlias := strings.TrimSpace(c.validatedSchemas.Schemas[index].Elasticsearch.Index.Alias)
-	esIndex := strings.TrimSpace(c.validatedSchemas.Schemas[index].Elasticsearch.Index.Name)
+	mvBytes, err = base64.StdEncoding.DecodeString(message.Value)
+	if err != nil {
+		c.increaseMetrics("kafka", m.Topic, "encoding")
+		c.Logger.Error().Err(err).Msgf("Fail to decode base64 field value from message from topic %s on partition %d and offset %d", m.Topic, m.Partition, m.Offset)
+		return
+	}
 
-	if esAlias != "" {
-		esTargetIndex = esAlias
-	} else {
-		esTargetIndex = esIndex
+	format := c.messageFormat(index)
+	decoder, err := c.changeDecoder(ctx, format)
+	if err != nil {
+		return
+	}
+	// cdc queries publish rows with the bare envelope
+	if c.validatedSchemas.Schemas[index].ChangeFeed.Select.enabled() {
+		decoder = decodeCockroachQuery
+	}
+	event, skip, err = decoder(mkBytes, mvBytes)
+	if err != nil {
+		c.increaseMetrics("kafka", m.Topic, "marshalling")
+		c.Logger.Error().Err(err).Msgf("Fail to decode %s kafka message from topic %s on partition %d and offset %d", format, m.Topic, m.Partition, m.Offset)
+		return
 	}
+	return
+}
 
-	_, err = client.Refresh().Index(esTargetIndex).Do(ctx)
+// batchMessage permit to add into the batch the elasticsearch requests
+// required by the kafka message
+func (c *Validate) batchMessage(ctx context.Context, index int, batch *bulkBatch, m kafkago.Message, event changeEvent) (err error) {
+	event, skip, err := c.applyFilter(index, event)
 	if err != nil {
+		c.increaseMetrics("kafka", m.Topic, "filter")
+		c.Logger.Error().Err(err).Msgf("Fail to filter kafka message from topic %s on partition %d and offset %d", m.Topic, m.Partition, m.Offset)
+		return
+	}
+	if skip {
+		c.Logger.Debug().Msgf("Kafka message from topic %s on partition %d and offset %d does not match filter", m.Topic, m.Partition, m.Offset)
 		return
 	}
 
-	var documentToDelete bool
-	esQuery := elastic.NewBoolQuery()
-	if reflect.ValueOf(c.validatedSchemas.Schemas[index].SQL).IsZero() {
-		var queries []elastic.Query
-		if value["after"] != nil {
-			var before map[string]interface{}
-			if value["before"] != nil {
-				err := mapstructure.Decode(value["before"], &before)
-				if err != nil {
-					return false, "", esTargetIndex, err
-				}
-				for k, v := range before {
-					isMap, multiKeys := c.isMap(v)
-					switch {
-					case isMap && multiKeys:
-						continue
-					case isMap && !multiKeys:
-						x := make(map[string]interface{})
-						x[k] = v
-						flatten, err := flat.Flatten(x, nil)
-						if err != nil {
-							c.Logger.Error().Err(err).Msgf("Fail to flatten map %+v", v)
-						} else {
-							c.Logger.Debug().Msgf("flatten map %+v", flatten)
-							for fk, fv := range flatten {
-								c.Logger.Debug().Msgf("K/V to search for %v == %v", fk, fv)
-								queries = append(queries, elastic.NewMatchQuery(fk, fv))
-							}
-						}
-					default:
-						c.Logger.Debug().Msgf("K/V to search for %v == %v", k, v)
-						queries = append(queries, elastic.NewMatchQuery(k, v))
-					}
-				}
-			} else {
-				return
-			}
-		} else {
-			documentToDelete = true
+	content := event.After
+	if event.After != nil && !reflect.ValueOf(c.validatedSchemas.Schemas[index].SQL).IsZero() {
+		t := strings.Split(c.validatedSchemas.Schemas[index].ChangeFeed.FullTableName, ".")
+		match := event.Match
+		if match == nil {
+			match = event.After
+		}
+		result, select_query, err := c.query(ctx, c.validatedSchemas.Schemas[index].SQL.Query, t[len(t)-1], match, c.validatedSchemas.Schemas[index].SQL.Aggregate)
+		if err != nil {
+			c.increaseMetrics("elasticsearch", m.Topic, "sql")
+			c.Logger.Error().Err(err).Msgf("Fail to execute SQL query `%s` with kafka message from topic %s on partition %d and offset %d", select_query, m.Topic, m.Partition, m.Offset)
+			return err
+		}
+		c.Logger.Debug().Msgf("result %+v query %+v", result, select_query)
+		content = result
+
+		// the row no longer matches the query like with an inner join
+		// so its document is deleted instead of indexing an empty one
+		if result == nil {
+			c.Logger.Debug().Msgf("SQL query `%s` returned no row with kafka message from topic %s on partition %d and offset %d, the document will be deleted", select_query, m.Topic, m.Partition, m.Offset)
+			event.Before, event.After = event.After, nil
+		}
+	}
 
-			var before map[string]interface{}
-			err := mapstructure.Decode(value["before"], &before)
-			if err != nil {
-				return false, "", esTargetIndex, err
-			}
-			for k, v := range before {
-				isMap, multiKeys := c.isMap(v)
-				switch {
-				case isMap && multiKeys:
-					continue
-				case isMap && !multiKeys:
-					x := make(map[string]interface{})
-					x[k] = v
-					flatten, err := flat.Flatten(x, nil)
-					if err != nil {
-						c.Logger.Error().Err(err).Msgf("Fail to flatten map %+v", v)
-					} else {
-						c.Logger.Debug().Msgf("flatten map %+v", flatten)
-						for fk, fv := range flatten {
-							c.Logger.Debug().Msgf("K/V to delete for %v == %v", fk, fv)
-							queries = append(queries, elastic.NewMatchQuery(fk, fv))
-						}
-					}
-				default:
-					c.Logger.Debug().Msgf("K/V to delete for %v == %v", k, v)
-					queries = append(queries, elastic.NewMatchQuery(k, v))
-				}
-			}
+	if event.After != nil {
+		content, err = c.transform(index, content)
+		if err != nil {
+			c.increaseMetrics("kafka", m.Topic, "transform")
+			c.Logger.Error().Err(err).Msgf("Fail to transform document with kafka message from topic %s on partition %d and offset %d", m.Topic, m.Partition, m.Offset)
+			return
 		}
-		esQuery.Must(queries...)
-	} else {
-		var queries []elastic.Query
-		for _, column := range c.validatedSchemas.Schemas[index].SQL.Columns {
-			if value["after"] != nil {
-				if value["before"] != nil {
-					var before map[string]interface{}
-					err := mapstructure.Decode(value["before"], &before)
-					if err != nil {
-						return false, "", esTargetIndex, err
-					}
-					if z, ok := before[column]; ok {
-						c.Logger.Debug().Msgf("K/V to search for %v == %v", column, z)
-						queries = append(queries, elastic.NewMatchQuery(column, z))
-					}
-				} else {
-					return
-				}
-			} else {
-				documentToDelete = true
 
-				var before map[string]interface{}
-				err := mapstructure.Decode(value["before"], &before)
-				if err != nil {
-					return false, "", esTargetIndex, err
-				}
-				if z, ok := before[column]; ok {
-					c.Logger.Debug().Msgf("K/V to delete %v == %v", column, z)
-					queries = append(queries, elastic.NewMatchQuery(column, z))
-				}
-			}
+		if c.documentIdStrategy(index) != documentIdUUID {
+			return c.batchDocument(ctx, index, batch, m, event, content)
+		}
+
+		exist, id, esTargetIndex, err := c.searchByVersion(ctx, index, batch, event)
+		if err != nil {
+			c.increaseMetrics("elasticsearch", m.Topic, "indexing")
+			c.Logger.Error().Err(err).Msgf("Document already exist in elasticsearch index %s with kafka message from topic %s on partition %d and offset %d", esTargetIndex, m.Topic, m.Partition, m.Offset)
+			return err
 		}
-		esQuery.Must(queries...)
+
+		c.Logger.Debug().Msgf("Data exist in elasticsearch index %s? %t", esTargetIndex, exist)
+		id = c.indexNewContent(batch, index, content, id)
+		c.Logger.Debug().Msgf("Kafka message will be indexed into elasticsearch index `%s` with id %s from topic %s on partition %d and offset %d", esTargetIndex, id, m.Topic, m.Partition, m.Offset)
+		return nil
 	}
 
-	src, err := esQuery.Source()
-	if err != nil {
-		return
+	if c.documentIdStrategy(index) != documentIdUUID {
+		return c.batchDocument(ctx, index, batch, m, event, nil)
 	}
 
-	data, err := json.Marshal(src)
+	exist, id, esTargetIndex, err := c.searchByVersion(ctx, index, batch, event)
 	if err != nil {
+		c.increaseMetrics("elasticsearch", m.Topic, "indexing")
+		c.Logger.Error().Err(err).Msgf("Document with key(s) %v in elasticsearch index %s with kafka message from topic %s on partition %d and offset %d", event.Key, esTargetIndex, m.Topic, m.Partition, m.Offset)
 		return
 	}
 
-	c.Logger.Debug().Msgf("Elasticsearch query that will be executed %s", string(data))
-	result, err := client.Search().
-		Index(esTargetIndex).
-		Query(esQuery).
-		FetchSourceContext(elastic.NewFetchSourceContext(true)).
-		Do(ctx)
+	c.Logger.Debug().Msgf("Data exist in elasticsearch index %s? %t", esTargetIndex, exist)
+	if exist {
+		c.deleteContent(batch, index, esTargetIndex, id, 0)
+		c.Logger.Debug().Msgf("Kafka message will be deleted from elasticsearch index `%s` with id %s from topic %s on partition %d and offset %d", esTargetIndex, id, m.Topic, m.Partition, m.Offset)
+	}
+	return
+}
+
+// batchDocument permit to add into the batch the elasticsearch requests
+// required by the kafka message with a deterministic document id
+// so documents are updated or deleted without any search
+func (c *Validate) batchDocument(ctx context.Context, index int, batch *bulkBatch, m kafkago.Message, event changeEvent, content map[string]interface{}) (err error) {
+	// the updated timestamp of the changefeed is used as external version
+	// so older changes never overwrite newer ones
+	version, _, err := changeVersion(event.Updated)
 	if err != nil {
 		return
 	}
 
-	if result.TotalHits() > 0 {
-		if documentToDelete {
-			for _, hit := range result.Hits.Hits {
-				id = hit.Id
-			}
-			return true, id, esTargetIndex, nil
+	if event.After == nil {
+		id, err := c.documentId(index, event.Key, event.Before)
+		if err != nil {
+			return fmt.Errorf("Fail to build document id: %w", err)
 		}
-
-		if len(result.Hits.Hits) > 1 {
-			c.Logger.Info().Msgf("Elasticsearch search query on index %s %s", esTargetIndex, string(data))
-			return false, "", esTargetIndex, fmt.Errorf("Multiple document found with same data")
+		esTargetIndex, err := c.routeIndex(index, event.Before)
+		if err != nil {
+			return fmt.Errorf("Fail to route document: %w", err)
+		}
+		exist, err := c.indexExists(ctx, index, esTargetIndex)
+		if err != nil {
+			return err
 		}
-		for _, hit := range result.Hits.Hits {
-			id = hit.Id
+		if !exist {
+			c.Logger.Debug().Msgf("Elasticsearch index `%s` does not exist, nothing to delete", esTargetIndex)
+			return nil
 		}
-		return true, id, esTargetIndex, nil
+		c.deleteContent(batch, index, esTargetIndex, id, version)
+		c.Logger.Debug().Msgf("Kafka message will be deleted from elasticsearch index `%s` with id %s from topic %s on partition %d and offset %d", esTargetIndex, id, m.Topic, m.Partition, m.Offset)
+		return nil
+	}
+
+	id, err := c.documentId(index, event.Key, event.After)
+	if err != nil {
+		return fmt.Errorf("Fail to build document id: %w", err)
+	}
+	esTargetIndex, err := c.routeIndex(index, event.After)
+	if err != nil {
+		return fmt.Errorf("Fail to route document: %w", err)
+	}
+	if err = c.ensureIndex(ctx, index, esTargetIndex); err != nil {
+		return fmt.Errorf("Fail to create elasticsearch index %s: %w", esTargetIndex, err)
 	}
+
+	// the id or the index built from columns of the row changes when one of them
+	// is updated so the previous document must be deleted
+	if event.Before != nil && (c.documentIdStrategy(index) != documentIdPrimaryKey || c.indexPattern(index) != "") {
+		previous, err := c.documentId(index, event.Key, event.Before)
+		if err == nil {
+			previousIndex, err := c.routeIndex(index, event.Before)
+			if err == nil && (previous != id || previousIndex != esTargetIndex) {
+				exist, err := c.indexExists(ctx, index, previousIndex)
+				if err != nil {
+					return err
+				}
+				if exist {
+					c.deleteContent(batch, index, previousIndex, previous, version)
+					c.Logger.Debug().Msgf("Previous document with id %s will be deleted from elasticsearch index `%s`", previous, previousIndex)
+				}
+			}
+		}
+	}
+
+	c.indexDocument(batch, index, esTargetIndex, content, id, version)
+	c.Logger.Debug().Msgf("Kafka message will be indexed into elasticsearch index `%s` with id %s from topic %s on partition %d and offset %d", esTargetIndex, id, m.Topic, m.Partition, m.Offset)
 	return
 }
 
-// indexNewContent permit to add or update provided data
-// into elasticsearch index
-func (c *Validate) indexNewContent(index int, value map[string]interface{}, uniqId string) (err error) {
-	var esTargetIndex string
-	client, err := c.eClient()
-	defer client.Stop()
-
+// targetIndex return the alias of the schema if provided
+// otherwise its index name
+func (c *Validate) targetIndex(index int) string {
 	esAlias := strings.TrimSpace(c.validatedSchemas.Schemas[index].Elasticsearch.Index.Alias)
-	esIndex := strings.TrimSpace(c.validatedSchemas.Schemas[index].Elasticsearch.Index.Name)
+	if esAlias != "" {
+		return esAlias
+	}
+	return strings.TrimSpace(c.validatedSchemas.Schemas[index].Elasticsearch.Index.Name)
+}
 
-	var content map[string]interface{}
-	if !reflect.ValueOf(c.validatedSchemas.Schemas[index].SQL).IsZero() {
-		content = value
-	} else {
-		err = mapstructure.Decode(value["after"], &content)
-		if err != nil {
+// searchFilters permit to retrieve the fields and values
+// identifying the document in elasticsearch
+func (c *Validate) searchFilters(index int, event changeEvent) (filters map[string]interface{}, documentToDelete bool, err error) {
+	if event.After != nil {
+		if event.Before == nil {
 			return
 		}
-	}
-
-	if esAlias != "" {
-		esTargetIndex = esAlias
 	} else {
-		esTargetIndex = esIndex
+		documentToDelete = true
 	}
 
-	ctx := context.Background()
-	if uniqId == "" {
-		uuidgen := uuid.New()
-		_, err = client.
-			Index().
-			Index(esTargetIndex).
-			Id(uuidgen.String()).
-			BodyJson(content).
-			Do(ctx)
-	} else {
-		_, err = client.Update().
-			Index(esTargetIndex).
-			Id(uniqId).
-			Doc(content).
-			Do(ctx)
+	before := event.Before
+
+	filters = make(map[string]interface{})
+	if !reflect.ValueOf(c.validatedSchemas.Schemas[index].SQL).IsZero() {
+		for _, column := range c.validatedSchemas.Schemas[index].SQL.Columns {
+			if z, ok := before[column]; ok {
+				filters[column] = z
+			}
+		}
+		return
 	}
 
+	// documents are searched with the fields indexed after transform
+	before, err = c.transform(index, before)
 	if err != nil {
 		return
 	}
+
+	for k, v := range before {
+		isMap, multiKeys := c.isMap(v)
+		switch {
+		case isMap && multiKeys:
+			continue
+		case isMap && !multiKeys:
+			x := make(map[string]interface{})
+			x[k] = v
+			flatten, err := flat.Flatten(x, nil)
+			if err != nil {
+				c.Logger.Error().Err(err).Msgf("Fail to flatten map %+v", v)
+			} else {
+				c.Logger.Debug().Msgf("flatten map %+v", flatten)
+				for fk, fv := range flatten {
+					filters[fk] = fv
+				}
+			}
+		default:
+			filters[k] = v
+		}
+	}
 	return
 }
 
-// deleteContent permit to delete data with the provided id
-// from elasticsearch index
-func (c *Validate) deleteContent(index int, id string) (err error) {
-	var esTargetIndex string
-	client, err := c.eClient()
-	defer client.Stop()
-
-	esAlias := strings.TrimSpace(c.validatedSchemas.Schemas[index].Elasticsearch.Index.Alias)
-	esIndex := strings.TrimSpace(c.validatedSchemas.Schemas[index].Elasticsearch.Index.Name)
+// searchByVersion permit to search into the batch and then elasticsearch
+//
+// if the new data provided already exist
+//
+// if it exist, it will return the elasticsearch id
+//
+// if it exist multiple times, an error will be returned
+func (c *Validate) searchByVersion(ctx context.Context, index int, batch *bulkBatch, event changeEvent) (found bool, id, esTargetIndex string, err error) {
+	esTargetIndex = c.targetIndex(index)
 
-	if esAlias != "" {
-		esTargetIndex = esAlias
-	} else {
-		esTargetIndex = esIndex
+	filters, documentToDelete, err := c.searchFilters(index, event)
+	if err != nil || len(filters) == 0 {
+		return
 	}
 
-	ctx := context.Background()
+	found, id = batch.find(filters)
+	if found {
+		c.Logger.Debug().Msgf("Document with id %s found in pending batch", id)
+		return
+	}
 
-	_, err = client.
-		Delete().
-		Index(esTargetIndex).
-		Id(id).
-		Do(ctx)
+	sink, err := c.sink(index)
+	if err != nil {
+		return
+	}
 
+	c.Logger.Debug().Msgf("Search filters that will be executed %v", filters)
+	ids, err := sink.Search(ctx, esTargetIndex, filters)
 	if err != nil {
 		return
 	}
+
+	if len(ids) > 0 {
+		if !documentToDelete && len(ids) > 1 {
+			c.Logger.Info().Msgf("Search on index %s with filters %v", esTargetIndex, filters)
+			return false, "", esTargetIndex, fmt.Errorf("Multiple document found with same data")
+		}
+		return true, ids[len(ids)-1], esTargetIndex, nil
+	}
 	return
 }
 
+// indexNewContent permit to add into the batch the request
+// that will add or update provided data into elasticsearch index
+func (c *Validate) indexNewContent(batch *bulkBatch, index int, content map[string]interface{}, uniqId string) (id string) {
+	if uniqId == "" {
+		id = uuid.New().String()
+		c.indexDocument(batch, index, c.targetIndex(index), content, id, 0)
+		return
+	}
+
+	id = uniqId
+	batch.addRequest(sinkRequest{
+		schema:   index,
+		Action:   sinkActionUpdate,
+		Index:    c.targetIndex(index),
+		Id:       id,
+		Document: content,
+	})
+	batch.upsert(id, content)
+	return
+}
+
+// indexDocument permit to add into the batch the request
+// that will index the whole document with the provided id.
+// When version is provided, elasticsearch rejects the request
+// if the document has already been written with a newer version
+func (c *Validate) indexDocument(batch *bulkBatch, index int, esTargetIndex string, content map[string]interface{}, id string, version int64) {
+	batch.addRequest(sinkRequest{
+		schema:   index,
+		Action:   sinkActionIndex,
+		Index:    esTargetIndex,
+		Id:       id,
+		Document: content,
+		Version:  version,
+	})
+	batch.upsert(id, content)
+}
+
+// deleteContent permit to add into the batch the request
+// that will delete the document from elasticsearch index.
+// When version is provided, elasticsearch rejects the request
+// if the document has already been written with a newer version
+func (c *Validate) deleteContent(batch *bulkBatch, index int, esTargetIndex string, id string, version int64) {
+	batch.addRequest(sinkRequest{
+		schema:  index,
+		Action:  sinkActionDelete,
+		Index:   esTargetIndex,
+		Id:      id,
+		Version: version,
+	})
+	batch.remove(id)
+}
+
 func (c *Validate) isMap(x interface{}) (isMap bool, multiKeys bool) {
 	t := fmt.Sprintf("%T", x)
 	var m map[string]interface{}
@@ -137,15 +137,10 @@ func TestManageTopicsAndElasticsearchIndex(t *testing.T) {
 	var c Validate
 	c.Logger = logger.NewLogger()
 
-	conn, err := c.kClient()
-	assert.Nil(err)
-	topics, err := c.listTopics(conn)
+	topics, err := c.listTopics()
 	assert.Nil(err)
 	if tools.InSlice("movr.public.user_promo_codes", topics) {
-		conndel, err := c.kClient()
-		assert.Nil(err)
 		err = c.deleteTopics(
-			conndel,
 			[]string{
 				"movr.public.user_promo_codes",
 			},
@@ -167,9 +162,9 @@ func TestManageTopicsAndElasticsearchIndex(t *testing.T) {
 		c.ConfigDir = "examples/schemas"
 		c.ParseAndValidateConfig()
 
-		err = c.manageTopics()
+		err = c.manageTopics(context.Background())
 		assert.Nil(err)
-		err = c.manageElasticsearchIndex()
+		err = c.manageElasticsearchIndex(context.Background())
 		assert.Nil(err)
 	}
 }
@@ -179,15 +174,10 @@ func TestManageTopicsAndElasticsearchIndex_with_alias(t *testing.T) {
 	var c Validate
 	c.Logger = logger.NewLogger()
 
-	conn, err := c.kClient()
-	assert.Nil(err)
-	topics, err := c.listTopics(conn)
+	topics, err := c.listTopics()
 	assert.Nil(err)
 	if tools.InSlice("movr.public.user_promo_codes", topics) {
-		conndel, err := c.kClient()
-		assert.Nil(err)
 		err = c.deleteTopics(
-			conndel,
 			[]string{
 				"movr.public.user_promo_codes",
 			},
@@ -204,7 +194,7 @@ func TestManageTopicsAndElasticsearchIndex_with_alias(t *testing.T) {
 	c.ConfigDir = "examples/schemas"
 	c.ParseAndValidateConfig()
 
-	err = c.manageTopics()
+	err = c.manageTopics(context.Background())
 	assert.Nil(err)
 
 	var schema_id int
@@ -215,7 +205,7 @@ func TestManageTopicsAndElasticsearchIndex_with_alias(t *testing.T) {
 		}
 	}
 	c.validatedSchemas.Schemas[schema_id].Elasticsearch.Index.Alias = "user_promo_codes_alias"
-	err = c.manageElasticsearchIndex()
+	err = c.manageElasticsearchIndex(context.Background())
 	assert.Nil(err)
 }
 
@@ -224,15 +214,10 @@ func TestManageTopicsAndElasticsearchIndex_with_same_index_alias(t *testing.T) {
 	var c Validate
 	c.Logger = logger.NewLogger()
 
-	conn, err := c.kClient()
-	assert.Nil(err)
-	topics, err := c.listTopics(conn)
+	topics, err := c.listTopics()
 	assert.Nil(err)
 	if tools.InSlice("movr.public.user_promo_codes", topics) {
-		conndel, err := c.kClient()
-		assert.Nil(err)
 		err = c.deleteTopics(
-			conndel,
 			[]string{
 				"movr.public.user_promo_codes",
 			},
@@ -249,7 +234,7 @@ func TestManageTopicsAndElasticsearchIndex_with_same_index_alias(t *testing.T) {
 	c.ConfigDir = "examples/schemas"
 	c.ParseAndValidateConfig()
 
-	err = c.manageTopics()
+	err = c.manageTopics(context.Background())
 	assert.Nil(err)
 
 	var schema_id int
@@ -260,7 +245,7 @@ func TestManageTopicsAndElasticsearchIndex_with_same_index_alias(t *testing.T) {
 		}
 	}
 	c.validatedSchemas.Schemas[schema_id].Elasticsearch.Index.Alias = "user_promo_codes"
-	err = c.manageElasticsearchIndex()
+	err = c.manageElasticsearchIndex(context.Background())
 	assert.Error(err)
 }
 
@@ -269,15 +254,10 @@ func TestManageTopicsAndElasticsearchIndex_with_same_index_alias_after_index_cre
 	var c Validate
 	c.Logger = logger.NewLogger()
 
-	conn, err := c.kClient()
-	assert.Nil(err)
-	topics, err := c.listTopics(conn)
+	topics, err := c.listTopics()
 	assert.Nil(err)
 	if tools.InSlice("movr.public.user_promo_codes", topics) {
-		conndel, err := c.kClient()
-		assert.Nil(err)
 		err = c.deleteTopics(
-			conndel,
 			[]string{
 				"movr.public.user_promo_codes",
 			},
@@ -297,7 +277,7 @@ func TestManageTopicsAndElasticsearchIndex_with_same_index_alias_after_index_cre
 	c.ConfigDir = "examples/schemas"
 	c.ParseAndValidateConfig()
 
-	err = c.manageTopics()
+	err = c.manageTopics(context.Background())
 	assert.Nil(err)
 
 	var schema_id int
@@ -307,10 +287,10 @@ func TestManageTopicsAndElasticsearchIndex_with_same_index_alias_after_index_cre
 			break
 		}
 	}
-	err = c.manageElasticsearchIndex()
+	err = c.manageElasticsearchIndex(context.Background())
 	assert.Nil(err)
 	c.validatedSchemas.Schemas[schema_id].Elasticsearch.Index.Alias = "user_promo_codes"
-	err = c.manageElasticsearchIndex()
+	err = c.manageElasticsearchIndex(context.Background())
 	assert.Error(err)
 }
 
@@ -321,7 +301,7 @@ func TestManageChangeFeed(t *testing.T) {
 	c.Logger = logger.NewLogger()
 	c.ConfigDir = "examples/schemas"
 	c.ParseAndValidateConfig()
-	err := c.manageChangeFeed()
+	err := c.manageChangeFeed(context.Background())
 	assert.Nil(err)
 }
 
@@ -344,7 +324,7 @@ func TestValidate_processing(t *testing.T) {
 			<-sigc
 			signal.Stop(sigc)
 		}()
-		c.processing()
+		c.processing(context.Background())
 	}()
 
 	err = proc.Signal(os.Interrupt)
@@ -367,7 +347,7 @@ func (c *Validate) testProcessing(stop chan struct{}) {
 		default:
 			if i == 0 {
 				i++
-				c.processing()
+				c.processing(context.Background())
 			}
 		}
 	}
@@ -454,3 +434,21 @@ func manageUserPromoCodesForUnitTesting(action, fakeCharacter string) (err error
 	}
 	return
 }
+
+func TestRefuseTopicChanges(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(refuseTopicChanges(nil))
+	assert.Nil(refuseTopicChanges(topicChanges(
+		createTopicModel{Name: "users", NumPartitions: 3, ReplicationFactor: 1, TopicConfig: []topicConfig{{Key: "max.message.bytes", Value: "128000"}}},
+		topicState{Exists: true, Partitions: 1, ReplicationFactor: 1},
+	)))
+
+	err := refuseTopicChanges(topicChanges(
+		createTopicModel{Name: "users", NumPartitions: 1, ReplicationFactor: 3},
+		topicState{Exists: true, Partitions: 3, ReplicationFactor: 1},
+	))
+	assert.Error(err)
+	assert.Contains(err.Error(), "partitions of topic users")
+	assert.Contains(err.Error(), "replicationFactor of topic users")
+}
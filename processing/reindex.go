@@ -0,0 +1,316 @@
+// Package processing provide all requirements to process change data capture
+package processing
+
+import (
+	"context"
+	"fmt"
+	"os/signal"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Lord-Y/synker/commons"
+)
+
+const (
+	// reindexAliasSuffix is the suffix of the alias holding the index being rebuilt.
+	// Consumers write changes into the indexes of this alias too
+	reindexAliasSuffix string = "-reindex"
+	// reindexCountAttempts is the number of times document counts are compared
+	reindexCountAttempts int = 5
+)
+
+// ReindexOptions hold the requirements to rebuild the index of a schema
+type ReindexOptions struct {
+	// Schema name to reindex
+	Schema string
+	// Checkpoint is the file holding the progress of the backfill of the new index.
+	// Default to synker-reindex-<index>.json
+	Checkpoint string
+	// RowsPerSecond is the maximum number of rows read per second. 0 means unlimited
+	RowsPerSecond int
+	// PageSize is the number of rows read per query. Default to 1000
+	PageSize int
+	// MaxCountDiff is the maximum difference of documents tolerated between both indexes
+	MaxCountDiff int64
+	// DeleteAfter is the time to wait before deleting the old index once the alias is moved.
+	// 0 keeps the old index
+	DeleteAfter time.Duration
+}
+
+// dualWrite is the list of indexes being rebuilt of a schema
+type dualWrite struct {
+	indexes []string
+	expires time.Time
+}
+
+// versionedIndex match index names like users-v2
+var versionedIndex = regexp.MustCompile(`^(.+)-v([0-9]+)$`)
+
+// reindexAlias return the alias holding the index being rebuilt
+func reindexAlias(alias string) string {
+	return alias + reindexAliasSuffix
+}
+
+// nextIndexName return the name of the index replacing the current one
+// like users-v2 for users and users-v3 for users-v2
+func nextIndexName(name, current string) string {
+	if z := versionedIndex.FindStringSubmatch(current); z != nil && z[1] == name {
+		version, err := strconv.Atoi(z[2])
+		if err == nil {
+			return fmt.Sprintf("%s-v%d", name, version+1)
+		}
+	}
+	return name + "-v2"
+}
+
+// dualWriteTargets return the indexes being rebuilt by a reindex of the schema.
+// The result is cached so the sink is only queried once per refresh interval
+func (c *Validate) dualWriteTargets(ctx context.Context, index int) (z []string, err error) {
+	es := c.validatedSchemas.Schemas[index].Elasticsearch
+	alias := strings.TrimSpace(es.Index.Alias)
+	if alias == "" || strings.TrimSpace(es.Index.Pattern) != "" {
+		return
+	}
+	if v, ok := c.dualWrites.Load(index); ok && time.Now().Before(v.(dualWrite).expires) {
+		return v.(dualWrite).indexes, nil
+	}
+
+	sink, err := c.sink(index)
+	if err != nil {
+		return
+	}
+	if z, err = sink.AliasIndexes(ctx, reindexAlias(alias)); err != nil {
+		return
+	}
+	c.dualWrites.Store(index, dualWrite{indexes: z, expires: time.Now().Add(commons.GetReindexRefreshInterval())})
+	return
+}
+
+// dualWriteRequests return the requests of the batch followed by their copies
+// for the indexes being rebuilt by a reindex.
+// Origins hold the position in the batch of each request or -1 for copies
+func (c *Validate) dualWriteRequests(ctx context.Context, batch *bulkBatch) (requests []sinkRequest, origins []int, err error) {
+	requests = make([]sinkRequest, 0, len(batch.requests))
+	origins = make([]int, 0, len(batch.requests))
+	for k, request := range batch.requests {
+		requests = append(requests, request)
+		origins = append(origins, k)
+		// the backfill of a reindex already writes into the index being rebuilt
+		if batch.target != "" || request.Index != c.targetIndex(request.schema) {
+			continue
+		}
+		targets, err := c.dualWriteTargets(ctx, request.schema)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, target := range targets {
+			copied := request
+			copied.Index = target
+			requests = append(requests, copied)
+			origins = append(origins, -1)
+		}
+	}
+	return
+}
+
+// Reindex permit to rebuild the index of a schema with its current mapping without downtime.
+// A new versioned index is created, live changes are written into both indexes while
+// the new one is backfilled and the alias is atomically moved once document counts match
+func (c *Validate) Reindex(options ReindexOptions) {
+	defer c.closeClients()
+
+	index := -1
+	for k, v := range c.validatedSchemas.Schemas {
+		if v.Name == options.Schema {
+			index = k
+			break
+		}
+	}
+	if index == -1 {
+		c.Logger.Fatal().Err(fmt.Errorf("Schema %s not found", options.Schema)).Msgf("Fail to reindex schema %s", options.Schema)
+		return
+	}
+
+	// the backfill stops between two pages on interruption so the reindex can be resumed
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	current, next, err := c.reindex(ctx, index, options)
+	if err != nil {
+		c.Logger.Fatal().Err(err).Msgf("Fail to reindex schema %s", options.Schema)
+		return
+	}
+	c.Logger.Info().Msgf("Reindex of schema %s from index %s to index %s completed", options.Schema, current, next)
+}
+
+// validateReindex permit to check that the index of the schema can be rebuilt
+func (c *Validate) validateReindex(index int) error {
+	s := c.validatedSchemas.Schemas[index]
+	if !cockroachFormat(s.Format) {
+		return fmt.Errorf("Reindex is only supported with cockroach formats")
+	}
+	if c.indexPattern(index) != "" {
+		return fmt.Errorf("Reindex is not supported with index pattern, indexes are created with the mapping of the schema")
+	}
+	alias := strings.TrimSpace(s.Elasticsearch.Index.Alias)
+	if alias == "" || alias == strings.TrimSpace(s.Elasticsearch.Index.Name) {
+		return fmt.Errorf("Reindex requires an alias different from the index name so it can be moved")
+	}
+	if c.documentIdStrategy(index) == documentIdUUID {
+		return fmt.Errorf("Reindex requires a documentId strategy other than uuid so both indexes hold the same documents")
+	}
+	return nil
+}
+
+// reindexTarget return the index currently behind the alias of the schema and the index replacing it.
+// An index already being rebuilt is reused so an interrupted reindex can be resumed
+func (c *Validate) reindexTarget(ctx context.Context, index int) (current, next string, err error) {
+	es := c.validatedSchemas.Schemas[index].Elasticsearch
+	alias := strings.TrimSpace(es.Index.Alias)
+	sink, err := c.sink(index)
+	if err != nil {
+		return
+	}
+
+	indexes, err := sink.AliasIndexes(ctx, alias)
+	if err != nil {
+		return
+	}
+	if len(indexes) != 1 {
+		return "", "", fmt.Errorf("Alias %s must point to exactly one index to be reindexed, found %d", alias, len(indexes))
+	}
+	current = indexes[0]
+
+	rebuilding, err := sink.AliasIndexes(ctx, reindexAlias(alias))
+	if err != nil {
+		return
+	}
+	switch len(rebuilding) {
+	case 0:
+		next = nextIndexName(strings.TrimSpace(es.Index.Name), current)
+	case 1:
+		next = rebuilding[0]
+		c.Logger.Info().Msgf("Resuming reindex of alias %s into index %s", alias, next)
+	default:
+		return "", "", fmt.Errorf("Alias %s must point to only one index, found %v", reindexAlias(alias), rebuilding)
+	}
+	if next == current {
+		return "", "", fmt.Errorf("Index %s is already behind alias %s", next, alias)
+	}
+	return
+}
+
+// verifyReindex permit to compare the number of documents of both indexes
+// until the difference is tolerated
+func (c *Validate) verifyReindex(ctx context.Context, sink sink, current, next string, options ReindexOptions, wait time.Duration) (err error) {
+	var currentCount, nextCount int64
+	for attempt := 1; attempt <= reindexCountAttempts; attempt++ {
+		if currentCount, err = sink.Count(ctx, current); err != nil {
+			return
+		}
+		if nextCount, err = sink.Count(ctx, next); err != nil {
+			return
+		}
+		diff := currentCount - nextCount
+		if diff < 0 {
+			diff = -diff
+		}
+		if diff <= options.MaxCountDiff {
+			c.Logger.Info().Msgf("Index %s holds %d documents and index %s holds %d documents", current, currentCount, next, nextCount)
+			return nil
+		}
+		if attempt == reindexCountAttempts {
+			break
+		}
+		c.Logger.Warn().Msgf("Index %s holds %d documents while index %s holds %d documents, retrying in %s", current, currentCount, next, nextCount, wait)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+	return fmt.Errorf("Document count mismatch between index %s with %d documents and index %s with %d documents", current, currentCount, next, nextCount)
+}
+
+// reindex permit to create the new versioned index, backfill it
+// while consumers write live changes into both indexes
+// and atomically move the alias once document counts match
+func (c *Validate) reindex(ctx context.Context, index int, options ReindexOptions) (current, next string, err error) {
+	if err = c.validateReindex(index); err != nil {
+		return
+	}
+	s := c.validatedSchemas.Schemas[index]
+	alias := strings.TrimSpace(s.Elasticsearch.Index.Alias)
+
+	if current, next, err = c.reindexTarget(ctx, index); err != nil {
+		return
+	}
+	sink, err := c.sink(index)
+	if err != nil {
+		return
+	}
+	if _, err = sink.EnsureIndex(ctx, next, s.Elasticsearch.Mapping); err != nil {
+		return current, next, fmt.Errorf("Fail to create index %s: %w", next, err)
+	}
+	if err = sink.AddAlias(ctx, next, reindexAlias(alias), false); err != nil {
+		return current, next, fmt.Errorf("Fail to add index %s to alias %s: %w", next, reindexAlias(alias), err)
+	}
+
+	// consumers must write live changes into the new index before it is backfilled
+	wait := 2 * commons.GetReindexRefreshInterval()
+	c.Logger.Info().Msgf("Waiting %s for consumers to write changes into index %s", wait, next)
+	select {
+	case <-ctx.Done():
+		return current, next, ctx.Err()
+	case <-time.After(wait):
+	}
+
+	checkpoint := strings.TrimSpace(options.Checkpoint)
+	if checkpoint == "" {
+		checkpoint = fmt.Sprintf("synker-reindex-%s.json", next)
+	}
+	backfilled, err := c.backfill(ctx, index, BackfillOptions{
+		Schema:        s.Name,
+		Checkpoint:    checkpoint,
+		RowsPerSecond: options.RowsPerSecond,
+		PageSize:      options.PageSize,
+		index:         next,
+	})
+	if err != nil {
+		return
+	}
+	c.Logger.Info().Msgf("Index %s backfilled with %d rows read and %d rows skipped", next, backfilled.Rows, backfilled.Skipped)
+
+	if err = c.verifyReindex(ctx, sink, current, next, options, wait); err != nil {
+		return
+	}
+
+	err = sink.UpdateAliases(ctx, []aliasAction{
+		{Remove: true, Index: current, Alias: alias},
+		{Index: next, Alias: alias, WriteIndex: true},
+		{Remove: true, Index: next, Alias: reindexAlias(alias)},
+	})
+	if err != nil {
+		return current, next, fmt.Errorf("Fail to move alias %s from index %s to index %s: %w", alias, current, next, err)
+	}
+	c.Logger.Info().Msgf("Alias %s moved from index %s to index %s", alias, current, next)
+
+	if options.DeleteAfter <= 0 {
+		c.Logger.Info().Msgf("Index %s is kept and can be deleted once no longer needed", current)
+		return
+	}
+	c.Logger.Info().Msgf("Index %s will be deleted in %s", current, options.DeleteAfter)
+	select {
+	case <-ctx.Done():
+		return current, next, ctx.Err()
+	case <-time.After(options.DeleteAfter):
+	}
+	if err = sink.DeleteIndex(ctx, current); err != nil {
+		return current, next, fmt.Errorf("Fail to delete index %s: %w", current, err)
+	}
+	c.Logger.Info().Msgf("Index %s deleted", current)
+	return
+}
@@ -0,0 +1,179 @@
+// Package processing provide all requirements to process change data capture
+package processing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Lord-Y/synker/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNextIndexName(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		name    string
+		current string
+		expect  string
+	}{
+		{name: "users", current: "users", expect: "users-v2"},
+		{name: "users", current: "users-v2", expect: "users-v3"},
+		{name: "users", current: "users-v19", expect: "users-v20"},
+		{name: "users", current: "accounts-v2", expect: "users-v2"},
+		{name: "users-v1", current: "users-v1", expect: "users-v1-v2"},
+	}
+
+	for _, tc := range tests {
+		assert.Equal(tc.expect, nextIndexName(tc.name, tc.current))
+	}
+}
+
+func TestValidateReindex(t *testing.T) {
+	assert := assert.New(t)
+
+	var c Validate
+	c.Logger = logger.NewLogger()
+
+	valid := configSchema{
+		Elasticsearch: elasticsearchSchema{
+			Index:      elasticsearchIndex{Name: "users", Alias: "users_alias"},
+			DocumentId: documentIdSchema{Strategy: documentIdPrimaryKey},
+		},
+	}
+
+	tests := []struct {
+		update func(s *configSchema)
+		fail   bool
+	}{
+		{
+			update: func(s *configSchema) {},
+		},
+		{
+			update: func(s *configSchema) { s.Format = formatDebeziumJSON },
+			fail:   true,
+		},
+		{
+			update: func(s *configSchema) { s.Elasticsearch.Index.Alias = "" },
+			fail:   true,
+		},
+		{
+			update: func(s *configSchema) { s.Elasticsearch.Index.Alias = "users" },
+			fail:   true,
+		},
+		{
+			update: func(s *configSchema) { s.Elasticsearch.Index.Pattern = "users-{city}" },
+			fail:   true,
+		},
+		{
+			update: func(s *configSchema) { s.Elasticsearch.DocumentId.Strategy = "" },
+			fail:   true,
+		},
+	}
+
+	for _, tc := range tests {
+		s := valid
+		tc.update(&s)
+		c.validatedSchemas.Schemas = []configSchema{s}
+		if tc.fail {
+			assert.Error(c.validateReindex(0))
+		} else {
+			assert.Nil(c.validateReindex(0))
+		}
+	}
+}
+
+func TestMemorySink_aliases(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	s := newMemorySink()
+	_, err := s.EnsureIndex(ctx, "users", nil)
+	assert.Nil(err)
+	_, err = s.EnsureIndex(ctx, "users-v2", nil)
+	assert.Nil(err)
+	assert.Nil(s.AddAlias(ctx, "users", "users_alias", true))
+
+	indexes, err := s.AliasIndexes(ctx, "users_alias")
+	assert.Nil(err)
+	assert.Equal([]string{"users"}, indexes)
+	indexes, err = s.AliasIndexes(ctx, "missing")
+	assert.Nil(err)
+	assert.Equal(0, len(indexes))
+
+	assert.Error(s.UpdateAliases(ctx, []aliasAction{{Index: "missing", Alias: "users_alias"}}))
+	assert.Nil(s.UpdateAliases(ctx, []aliasAction{
+		{Remove: true, Index: "users", Alias: "users_alias"},
+		{Index: "users-v2", Alias: "users_alias", WriteIndex: true},
+	}))
+	indexes, err = s.AliasIndexes(ctx, "users_alias")
+	assert.Nil(err)
+	assert.Equal([]string{"users-v2"}, indexes)
+
+	assert.Nil(s.DeleteIndex(ctx, "users"))
+	assert.Error(s.DeleteIndex(ctx, "users"))
+	exist, err := s.IndexExists(ctx, "users")
+	assert.Nil(err)
+	assert.Equal(false, exist)
+}
+
+func TestSendBatch_dualWrite(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	var c Validate
+	c.Logger = logger.NewLogger()
+	c.validatedSchemas.Schemas = []configSchema{
+		{
+			Name: "users",
+			Elasticsearch: elasticsearchSchema{
+				Index: elasticsearchIndex{Name: "users", Alias: "users_alias"},
+			},
+		},
+	}
+	memory := newMemorySink()
+	c.clients.sinks = map[string]sink{sinkElasticsearch: memory}
+	_, err := memory.EnsureIndex(ctx, "users", nil)
+	assert.Nil(err)
+	_, err = memory.EnsureIndex(ctx, "users-v2", nil)
+	assert.Nil(err)
+	assert.Nil(memory.AddAlias(ctx, "users", "users_alias", true))
+	assert.Nil(memory.AddAlias(ctx, "users-v2", reindexAlias("users_alias"), false))
+
+	// changes are written into both indexes and failures of copies are ignored
+	batch := newBulkBatch(false)
+	c.indexDocument(batch, 0, c.targetIndex(0), map[string]interface{}{"name": "a"}, "1", 10)
+	c.indexNewContent(batch, 0, map[string]interface{}{"name": "b"}, "2")
+	var rejected []int
+	_, err = c.sendBatch(ctx, batch, "users", func(k int, reason error) error {
+		rejected = append(rejected, k)
+		return nil
+	})
+	assert.Nil(err)
+	assert.Equal([]int{1}, rejected)
+	_, ok := memory.Document("users", "1")
+	assert.Equal(true, ok)
+	_, ok = memory.Document("users-v2", "1")
+	assert.Equal(true, ok)
+
+	// the backfill only writes into the index being rebuilt
+	batch = newBulkBatch(false)
+	batch.target = "users-v2"
+	c.indexDocument(batch, 0, c.targetIndex(0), map[string]interface{}{"name": "c"}, "3", 10)
+	_, err = c.sendBatch(ctx, batch, "users", func(k int, reason error) error {
+		return reason
+	})
+	assert.Nil(err)
+	_, ok = memory.Document("users", "3")
+	assert.Equal(false, ok)
+	_, ok = memory.Document("users-v2", "3")
+	assert.Equal(true, ok)
+
+	current, next, err := c.reindexTarget(ctx, 0)
+	assert.Nil(err)
+	assert.Equal("users", current)
+	assert.Equal("users-v2", next)
+
+	assert.Error(c.verifyReindex(ctx, memory, current, next, ReindexOptions{}, 0))
+	assert.Nil(c.verifyReindex(ctx, memory, current, next, ReindexOptions{MaxCountDiff: 1}, 0))
+}
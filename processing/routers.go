@@ -10,6 +10,7 @@ import (
 	"github.com/gin-contrib/logger"
 	"github.com/gin-contrib/requestid"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
@@ -51,12 +52,18 @@ func (c *Validate) setupRouter() *gin.Engine {
 			c.Logger.Fatal().Err(err).Msg("Fail to register prometheus metrics")
 		}
 		c.metrics = newMetrics
+
+		if err := prometheus.Register(c.newPoolCollector()); err != nil {
+			if _, ok := err.(prometheus.AlreadyRegisteredError); !ok {
+				c.Logger.Fatal().Err(err).Msg("Fail to register prometheus connection pool metrics")
+			}
+		}
 	}
 
 	v1 := router.Group("/api/v1")
 	{
-		v1.GET("/health", health)
-		v1.GET("/healthz", healthz)
+		v1.GET("/health", c.health)
+		v1.GET("/healthz", c.healthz)
 	}
 	return router
 }
@@ -8,11 +8,29 @@ import (
 )
 
 // health permit to return basic health check
-func health(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"health": "OK"})
+// that fails when at least one consumer is unhealthy
+func (c *Validate) health(ctx *gin.Context) {
+	if c.supervisor != nil && !c.supervisor.healthy() {
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{"health": "KO"})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"health": "OK"})
 }
 
-// healthz permit to get the status of all backend required by the api
-func healthz(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"health": "OK"})
+// healthz permit to get the status of all consumers required by the api
+func (c *Validate) healthz(ctx *gin.Context) {
+	if c.supervisor == nil {
+		ctx.JSON(http.StatusOK, gin.H{"health": "OK"})
+		return
+	}
+	z := gin.H{"health": "OK", "consumers": c.supervisor.states()}
+	if c.changeFeeds != nil {
+		z["changefeeds"] = c.changeFeeds.states()
+	}
+	if !c.supervisor.healthy() {
+		z["health"] = "KO"
+		ctx.JSON(http.StatusServiceUnavailable, z)
+		return
+	}
+	ctx.JSON(http.StatusOK, z)
 }
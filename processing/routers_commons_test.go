@@ -2,6 +2,7 @@
 package processing
 
 import (
+	"fmt"
 	"os"
 	"testing"
 
@@ -115,3 +116,33 @@ func TestHealth_prometheus_port(t *testing.T) {
 	assert.NotNil(wp.Body.String())
 	assert.Equal(200, wp.Result().StatusCode)
 }
+
+func TestHealth_unhealthy_consumer(t *testing.T) {
+	assert := assert.New(t)
+	headers := make(map[string]string)
+	headers["Content-Type"] = "application/x-www-form-urlencoded"
+
+	var c Validate
+	c.Logger = logger.NewLogger()
+	c.supervisor = newSupervisor()
+	c.supervisor.maxFailures = 1
+	c.supervisor.register("users", "movr.public.users")
+	c.supervisor.failed("users", fmt.Errorf("connection refused"))
+	router := c.setupRouter()
+
+	w, err := performRequest(router, headers, "GET", "/api/v1/health", "")
+	if err != nil {
+		assert.FailNow("Failed to perform http GET request")
+		return
+	}
+	assert.Equal(503, w.Code, "Failed to perform http GET request")
+	assert.Contains(w.Body.String(), `{"health":"KO"}`, "Failed to get right body content")
+
+	w, err = performRequest(router, headers, "GET", "/api/v1/healthz", "")
+	if err != nil {
+		assert.FailNow("Failed to perform http GET request")
+		return
+	}
+	assert.Equal(503, w.Code, "Failed to perform http GET request")
+	assert.Contains(w.Body.String(), `"lastError":"connection refused"`, "Failed to get right body content")
+}
@@ -0,0 +1,129 @@
+// Package processing provide all requirements to process change data capture
+package processing
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+// indexNameReplacer replace characters forbidden in elasticsearch index names
+var indexNameReplacer = strings.NewReplacer(
+	`\`, "_",
+	"/", "_",
+	"*", "_",
+	"?", "_",
+	`"`, "_",
+	"<", "_",
+	">", "_",
+	"|", "_",
+	" ", "_",
+	",", "_",
+	"#", "_",
+	":", "_",
+)
+
+// indexPattern return the pattern used to compute the index of documents
+func (c *Validate) indexPattern(index int) string {
+	return strings.TrimSpace(c.validatedSchemas.Schemas[index].Elasticsearch.Index.Pattern)
+}
+
+// validateIndexPattern permit to check index pattern requirements
+func validateIndexPattern(s configSchema) (err error) {
+	pattern := strings.TrimSpace(s.Elasticsearch.Index.Pattern)
+	if pattern == "" {
+		if strings.TrimSpace(s.Elasticsearch.Index.Name) == "" {
+			return fmt.Errorf("Index name or pattern is required")
+		}
+		return
+	}
+	if len(templateFields(pattern)) == 0 {
+		return fmt.Errorf("Index pattern `%s` must contain at least one column like {city} or {timestamp:2006.01}", pattern)
+	}
+	if strings.TrimSpace(s.Elasticsearch.Index.Alias) == "" {
+		return fmt.Errorf("Index pattern `%s` requires an alias to read all generated indexes", pattern)
+	}
+	strategy := strings.TrimSpace(s.Elasticsearch.DocumentId.Strategy)
+	if strategy == "" || strategy == documentIdUUID {
+		return fmt.Errorf("Index pattern `%s` requires a documentId strategy other than %s", pattern, documentIdUUID)
+	}
+	return
+}
+
+// indexName return the elasticsearch index name rendered from the pattern
+func indexName(pattern string, fields map[string]interface{}) (string, error) {
+	z, err := renderTemplate(pattern, fields)
+	if err != nil {
+		return "", err
+	}
+	return strings.ToLower(indexNameReplacer.Replace(z)), nil
+}
+
+// routeIndex return the index holding the document of the provided image
+// of the row
+func (c *Validate) routeIndex(index int, image interface{}) (string, error) {
+	pattern := c.indexPattern(index)
+	if pattern == "" {
+		return c.targetIndex(index), nil
+	}
+
+	var fields map[string]interface{}
+	if err := mapstructure.Decode(image, &fields); err != nil {
+		return "", err
+	}
+	return indexName(pattern, fields)
+}
+
+// indexExists return true when the generated index exists.
+// Static indexes are always considered as existing
+func (c *Validate) indexExists(ctx context.Context, index int, name string) (bool, error) {
+	if c.indexPattern(index) == "" {
+		return true, nil
+	}
+	if _, ok := c.indexes.Load(name); ok {
+		return true, nil
+	}
+	sink, err := c.sink(index)
+	if err != nil {
+		return false, err
+	}
+	exist, err := sink.IndexExists(ctx, name)
+	if err != nil {
+		return false, err
+	}
+	if exist {
+		c.indexes.Store(name, true)
+	}
+	return exist, nil
+}
+
+// ensureIndex permit to create the generated index with the mapping of the schema
+// and add it to the read alias
+func (c *Validate) ensureIndex(ctx context.Context, index int, name string) (err error) {
+	exist, err := c.indexExists(ctx, index, name)
+	if err != nil || exist {
+		return
+	}
+	es := c.validatedSchemas.Schemas[index].Elasticsearch
+	if !es.Index.Create {
+		return fmt.Errorf("Index %s does not exist and index creation is disabled", name)
+	}
+
+	sink, err := c.sink(index)
+	if err != nil {
+		return
+	}
+	// the index may have been created by another consumer in the meantime
+	if _, err = sink.EnsureIndex(ctx, name, es.Mapping); err != nil {
+		return
+	}
+	if err = sink.AddAlias(ctx, name, strings.TrimSpace(es.Index.Alias), false); err != nil {
+		return
+	}
+
+	c.indexes.Store(name, true)
+	c.Logger.Info().Msgf("Elasticsearch index %s created with alias %s", name, es.Index.Alias)
+	return
+}
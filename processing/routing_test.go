@@ -0,0 +1,109 @@
+// Package processing provide all requirements to process change data capture
+package processing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Lord-Y/synker/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateIndexPattern(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		es   elasticsearchSchema
+		fail bool
+	}{
+		{
+			es: elasticsearchSchema{Index: elasticsearchIndex{Name: "rides"}},
+		},
+		{
+			es:   elasticsearchSchema{},
+			fail: true,
+		},
+		{
+			es: elasticsearchSchema{
+				Index:      elasticsearchIndex{Pattern: "rides-{city}", Alias: "rides"},
+				DocumentId: documentIdSchema{Strategy: documentIdPrimaryKey},
+			},
+		},
+		{
+			es: elasticsearchSchema{
+				Index:      elasticsearchIndex{Pattern: "rides", Alias: "rides"},
+				DocumentId: documentIdSchema{Strategy: documentIdPrimaryKey},
+			},
+			fail: true,
+		},
+		{
+			es: elasticsearchSchema{
+				Index:      elasticsearchIndex{Pattern: "rides-{city}"},
+				DocumentId: documentIdSchema{Strategy: documentIdPrimaryKey},
+			},
+			fail: true,
+		},
+		{
+			es: elasticsearchSchema{
+				Index: elasticsearchIndex{Pattern: "rides-{city}", Alias: "rides"},
+			},
+			fail: true,
+		},
+	}
+
+	for _, tc := range tests {
+		err := validateIndexPattern(configSchema{Elasticsearch: tc.es})
+		if tc.fail {
+			assert.Error(err)
+		} else {
+			assert.Nil(err)
+		}
+	}
+}
+
+func TestRouteIndex(t *testing.T) {
+	assert := assert.New(t)
+
+	var c Validate
+	c.Logger = logger.NewLogger()
+	c.validatedSchemas.Schemas = []configSchema{
+		{
+			Elasticsearch: elasticsearchSchema{
+				Index: elasticsearchIndex{Name: "rides", Alias: "rides_alias"},
+			},
+		},
+		{
+			Elasticsearch: elasticsearchSchema{
+				Index: elasticsearchIndex{Pattern: "vlh-{timestamp:2006.01}", Alias: "vlh"},
+			},
+		},
+		{
+			Elasticsearch: elasticsearchSchema{
+				Index: elasticsearchIndex{Pattern: "Rides-{city}", Alias: "rides"},
+			},
+		},
+	}
+
+	z, err := c.routeIndex(0, map[string]interface{}{"city": "paris"})
+	assert.Nil(err)
+	assert.Equal("rides_alias", z)
+
+	z, err = c.routeIndex(1, map[string]interface{}{"timestamp": "2024-03-01T10:20:30.123456"})
+	assert.Nil(err)
+	assert.Equal("vlh-2024.03", z)
+
+	z, err = c.routeIndex(2, map[string]interface{}{"city": "New York"})
+	assert.Nil(err)
+	assert.Equal("rides-new_york", z)
+
+	_, err = c.routeIndex(1, map[string]interface{}{"timestamp": "fake"})
+	assert.Error(err)
+
+	_, err = c.routeIndex(2, map[string]interface{}{})
+	assert.Error(err)
+
+	// static indexes are never checked
+	exist, err := c.indexExists(context.Background(), 0, "rides_alias")
+	assert.Nil(err)
+	assert.Equal(true, exist)
+}
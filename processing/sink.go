@@ -0,0 +1,191 @@
+// Package processing provide all requirements to process change data capture
+package processing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	// sinkElasticsearch send documents to elasticsearch 7
+	sinkElasticsearch string = "elasticsearch"
+	// sinkOpensearch send documents to opensearch
+	sinkOpensearch string = "opensearch"
+	// sinkActionIndex index the whole document
+	sinkActionIndex string = "index"
+	// sinkActionUpdate update fields of the document
+	sinkActionUpdate string = "update"
+	// sinkActionDelete delete the document
+	sinkActionDelete string = "delete"
+)
+
+// sink is the destination of documents built from kafka messages
+type sink interface {
+	// Ping return an error when the sink cannot be reached
+	Ping(ctx context.Context) error
+	// IndexExists return true when the index or alias exists
+	IndexExists(ctx context.Context, index string) (bool, error)
+	// EnsureIndex create the index with the provided mapping when it does not exist
+	// and return true when it has been created
+	EnsureIndex(ctx context.Context, index string, mapping map[string]interface{}) (bool, error)
+	// Aliases return the aliases of the index
+	Aliases(ctx context.Context, index string) ([]string, error)
+	// AddAlias add the index to the alias
+	AddAlias(ctx context.Context, index, alias string, writeIndex bool) error
+	// Search return the ids of documents matching all filters
+	Search(ctx context.Context, index string, filters map[string]interface{}) ([]string, error)
+	// Upsert index the whole document with the provided id.
+	// When version is greater than 0, it is used as external version
+	Upsert(ctx context.Context, index, id string, document map[string]interface{}, version int64) error
+	// Delete delete the document with the provided id.
+	// When version is greater than 0, it is used as external version
+	Delete(ctx context.Context, index, id string, version int64) error
+	// Bulk send all requests at once and return the result of each request in order.
+	// When refresh is true, it waits for the documents to be searchable
+	Bulk(ctx context.Context, requests []sinkRequest, refresh bool) ([]sinkResult, error)
+	// AliasIndexes return the indexes of the alias or nothing when the alias does not exist
+	AliasIndexes(ctx context.Context, alias string) ([]string, error)
+	// UpdateAliases apply all alias actions atomically
+	UpdateAliases(ctx context.Context, actions []aliasAction) error
+	// Count return the number of documents of the index or alias once refreshed
+	Count(ctx context.Context, index string) (int64, error)
+	// DeleteIndex delete the index
+	DeleteIndex(ctx context.Context, index string) error
+	// Mapping return the mappings of the index like {"properties": {...}}
+	Mapping(ctx context.Context, index string) (map[string]interface{}, error)
+	// PutMapping add the provided mappings to the index
+	PutMapping(ctx context.Context, index string, mappings map[string]interface{}) error
+}
+
+// aliasAction is an action applied on an alias
+type aliasAction struct {
+	// Remove the index from the alias instead of adding it
+	Remove bool
+	// Index added or removed
+	Index string
+	// Alias name
+	Alias string
+	// WriteIndex set the index as write index of the alias when added
+	WriteIndex bool
+}
+
+// sinkRequest is a request sent to the sink within a bulk
+type sinkRequest struct {
+	// schema is the index of the schema that produced the request
+	schema int
+	// Action is one of index, update or delete
+	Action string
+	// Index receiving the request
+	Index string
+	// Id of the document
+	Id string
+	// Document to index or fields to update
+	Document map[string]interface{}
+	// Version is the external version of the document when greater than 0
+	Version int64
+}
+
+// sinkResult is the result of a request sent within a bulk
+type sinkResult struct {
+	// Action of the request
+	Action string
+	// Index of the request
+	Index string
+	// Id of the document
+	Id string
+	// Status is the http status of the request
+	Status int
+	// ErrorType is the type of the error returned by the sink if any
+	ErrorType string
+	// ErrorReason is the reason of the error returned by the sink if any
+	ErrorReason string
+}
+
+// failed return true when the request has been rejected by the sink
+func (r sinkResult) failed() bool {
+	return r.ErrorType != ""
+}
+
+// size return the estimated size in bytes of the request
+func (r sinkRequest) size() int {
+	z := len(r.Action) + len(r.Index) + len(r.Id) + 64
+	if r.Document != nil {
+		b, err := json.Marshal(r.Document)
+		if err == nil {
+			z += len(b)
+		}
+	}
+	return z
+}
+
+// sinkKind return the kind of sink of the schema
+func (c *Validate) sinkKind(index int) string {
+	kind := strings.TrimSpace(c.validatedSchemas.Schemas[index].Elasticsearch.Sink)
+	if kind == "" {
+		return sinkElasticsearch
+	}
+	return kind
+}
+
+// sink return the sink of the schema created on first call
+// and shared by all schemas using the same kind of sink
+func (c *Validate) sink(index int) (sink, error) {
+	kind := c.sinkKind(index)
+
+	c.clients.mu.Lock()
+	if z, ok := c.clients.sinks[kind]; ok {
+		c.clients.mu.Unlock()
+		return z, nil
+	}
+	c.clients.mu.Unlock()
+
+	var (
+		z   sink
+		err error
+	)
+	switch kind {
+	case sinkElasticsearch:
+		z, err = c.newElasticsearchSink()
+	case sinkOpensearch:
+		z, err = newOpensearchSink()
+	default:
+		err = fmt.Errorf("Sink %s is not supported", kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c.clients.mu.Lock()
+	defer c.clients.mu.Unlock()
+	if existing, ok := c.clients.sinks[kind]; ok {
+		return existing, nil
+	}
+	if c.clients.sinks == nil {
+		c.clients.sinks = make(map[string]sink)
+	}
+	c.clients.sinks[kind] = z
+	return z, nil
+}
+
+// indexMappings return the mappings of the index from a get mapping response
+// like {"users": {"mappings": {...}}}.
+// The response of an alias is keyed by the index it points to
+func indexMappings(result map[string]interface{}, index string) (map[string]interface{}, error) {
+	v, ok := result[index]
+	if !ok && len(result) == 1 {
+		for _, z := range result {
+			v, ok = z, true
+		}
+	}
+	if !ok {
+		return nil, fmt.Errorf("Fail to find mapping of index %s", index)
+	}
+	body, _ := v.(map[string]interface{})
+	z, _ := body["mappings"].(map[string]interface{})
+	if z == nil {
+		z = make(map[string]interface{})
+	}
+	return z, nil
+}
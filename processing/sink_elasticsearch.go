@@ -0,0 +1,263 @@
+// Package processing provide all requirements to process change data capture
+package processing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/Lord-Y/synker/commons"
+	"github.com/olivere/elastic/v7"
+)
+
+// elasticsearchSink send documents to elasticsearch 7
+type elasticsearchSink struct {
+	client *elastic.Client
+}
+
+// newElasticsearchSink return the sink using the shared elasticsearch client
+func (c *Validate) newElasticsearchSink() (*elasticsearchSink, error) {
+	client, err := c.eClient()
+	if err != nil {
+		return nil, err
+	}
+	return &elasticsearchSink{client: client}, nil
+}
+
+// Ping implements sink
+func (s *elasticsearchSink) Ping(ctx context.Context) error {
+	_, code, err := s.client.Ping(commons.GetElasticsearchURI()).HttpHeadOnly(true).Do(ctx)
+	if err != nil {
+		return err
+	}
+	if code != http.StatusOK {
+		return fmt.Errorf("Elasticsearch ping returned http status %d", code)
+	}
+	return nil
+}
+
+// IndexExists implements sink
+func (s *elasticsearchSink) IndexExists(ctx context.Context, index string) (bool, error) {
+	return s.client.IndexExists(index).Do(ctx)
+}
+
+// EnsureIndex implements sink
+func (s *elasticsearchSink) EnsureIndex(ctx context.Context, index string, mapping map[string]interface{}) (bool, error) {
+	exist, err := s.client.IndexExists(index).Do(ctx)
+	if err != nil || exist {
+		return false, err
+	}
+	create, err := s.client.CreateIndex(index).BodyJson(mapping).Do(ctx)
+	if err != nil {
+		// the index has been created by another consumer in the meantime
+		var e *elastic.Error
+		if errors.As(err, &e) && e.Details != nil && e.Details.Type == "resource_already_exists_exception" {
+			return false, nil
+		}
+		return false, err
+	}
+	if !create.Acknowledged {
+		return false, fmt.Errorf("Fail to get index creation acknowledgement")
+	}
+	return true, nil
+}
+
+// Aliases implements sink
+func (s *elasticsearchSink) Aliases(ctx context.Context, index string) (z []string, err error) {
+	list, err := s.client.Aliases().Index(index).Do(ctx)
+	if err != nil {
+		return
+	}
+	for _, v := range list.Indices {
+		for _, alias := range v.Aliases {
+			z = append(z, alias.AliasName)
+		}
+	}
+	return
+}
+
+// AddAlias implements sink
+func (s *elasticsearchSink) AddAlias(ctx context.Context, index, alias string, writeIndex bool) error {
+	action := elastic.NewAliasAddAction(alias).Index(index)
+	if writeIndex {
+		action.IsWriteIndex(true)
+	}
+	resp, err := s.client.Alias().Action(action).Do(ctx)
+	if err != nil {
+		return err
+	}
+	if !resp.Acknowledged {
+		return fmt.Errorf("Fail to get alias creation acknowledgement")
+	}
+	return nil
+}
+
+// Search implements sink
+func (s *elasticsearchSink) Search(ctx context.Context, index string, filters map[string]interface{}) (ids []string, err error) {
+	var queries []elastic.Query
+	for k, v := range filters {
+		queries = append(queries, elastic.NewMatchQuery(k, v))
+	}
+	result, err := s.client.Search().
+		Index(index).
+		Query(elastic.NewBoolQuery().Must(queries...)).
+		FetchSource(false).
+		Do(ctx)
+	if err != nil {
+		return
+	}
+	for _, hit := range result.Hits.Hits {
+		ids = append(ids, hit.Id)
+	}
+	return
+}
+
+// Upsert implements sink
+func (s *elasticsearchSink) Upsert(ctx context.Context, index, id string, document map[string]interface{}, version int64) error {
+	service := s.client.Index().Index(index).Id(id).BodyJson(document)
+	if version > 0 {
+		service.VersionType(versionType).Version(version)
+	}
+	_, err := service.Do(ctx)
+	return err
+}
+
+// Delete implements sink
+func (s *elasticsearchSink) Delete(ctx context.Context, index, id string, version int64) error {
+	service := s.client.Delete().Index(index).Id(id)
+	if version > 0 {
+		service.VersionType(versionType).Version(version)
+	}
+	_, err := service.Do(ctx)
+	return err
+}
+
+// Bulk implements sink
+func (s *elasticsearchSink) Bulk(ctx context.Context, requests []sinkRequest, refresh bool) (results []sinkResult, err error) {
+	service := s.client.Bulk()
+	if refresh {
+		service.Refresh("wait_for")
+	}
+	for _, r := range requests {
+		switch r.Action {
+		case sinkActionIndex:
+			request := elastic.NewBulkIndexRequest().Index(r.Index).Id(r.Id).Doc(r.Document)
+			if r.Version > 0 {
+				request.VersionType(versionType).Version(r.Version)
+			}
+			service.Add(request)
+		case sinkActionUpdate:
+			service.Add(elastic.NewBulkUpdateRequest().Index(r.Index).Id(r.Id).Doc(r.Document))
+		case sinkActionDelete:
+			request := elastic.NewBulkDeleteRequest().Index(r.Index).Id(r.Id)
+			if r.Version > 0 {
+				request.VersionType(versionType).Version(r.Version)
+			}
+			service.Add(request)
+		default:
+			return nil, fmt.Errorf("Bulk action %s is not supported", r.Action)
+		}
+	}
+
+	response, err := service.Do(ctx)
+	if err != nil {
+		return
+	}
+	for _, item := range response.Items {
+		for action, result := range item {
+			z := sinkResult{
+				Action: action,
+				Index:  result.Index,
+				Id:     result.Id,
+				Status: result.Status,
+			}
+			if result.Error != nil {
+				z.ErrorType = result.Error.Type
+				z.ErrorReason = result.Error.Reason
+			}
+			results = append(results, z)
+		}
+	}
+	return
+}
+
+// AliasIndexes implements sink
+func (s *elasticsearchSink) AliasIndexes(ctx context.Context, alias string) ([]string, error) {
+	list, err := s.client.Aliases().Alias(alias).Do(ctx)
+	if err != nil {
+		if elastic.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	z := list.IndicesByAlias(alias)
+	sort.Strings(z)
+	return z, nil
+}
+
+// UpdateAliases implements sink
+func (s *elasticsearchSink) UpdateAliases(ctx context.Context, actions []aliasAction) error {
+	service := s.client.Alias()
+	for _, action := range actions {
+		if action.Remove {
+			service.Action(elastic.NewAliasRemoveAction(action.Alias).Index(action.Index))
+			continue
+		}
+		add := elastic.NewAliasAddAction(action.Alias).Index(action.Index)
+		if action.WriteIndex {
+			add.IsWriteIndex(true)
+		}
+		service.Action(add)
+	}
+	resp, err := service.Do(ctx)
+	if err != nil {
+		return err
+	}
+	if !resp.Acknowledged {
+		return fmt.Errorf("Fail to get alias update acknowledgement")
+	}
+	return nil
+}
+
+// Count implements sink
+func (s *elasticsearchSink) Count(ctx context.Context, index string) (int64, error) {
+	if _, err := s.client.Refresh(index).Do(ctx); err != nil {
+		return 0, err
+	}
+	return s.client.Count(index).Do(ctx)
+}
+
+// DeleteIndex implements sink
+func (s *elasticsearchSink) DeleteIndex(ctx context.Context, index string) error {
+	resp, err := s.client.DeleteIndex(index).Do(ctx)
+	if err != nil {
+		return err
+	}
+	if !resp.Acknowledged {
+		return fmt.Errorf("Fail to get index deletion acknowledgement")
+	}
+	return nil
+}
+
+// Mapping implements sink
+func (s *elasticsearchSink) Mapping(ctx context.Context, index string) (map[string]interface{}, error) {
+	result, err := s.client.GetMapping().Index(index).Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return indexMappings(result, index)
+}
+
+// PutMapping implements sink
+func (s *elasticsearchSink) PutMapping(ctx context.Context, index string, mappings map[string]interface{}) error {
+	resp, err := s.client.PutMapping().Index(index).BodyJson(mappings).Do(ctx)
+	if err != nil {
+		return err
+	}
+	if !resp.Acknowledged {
+		return fmt.Errorf("Fail to get mapping update acknowledgement")
+	}
+	return nil
+}
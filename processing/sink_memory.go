@@ -0,0 +1,371 @@
+// Package processing provide all requirements to process change data capture
+package processing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/nqd/flat"
+)
+
+// memorySink keep documents in memory. It is used by tests
+// and reproduces the external version checks of elasticsearch
+type memorySink struct {
+	mu      sync.Mutex
+	indexes map[string]*memoryIndex
+	aliases map[string][]string
+}
+
+// memoryIndex is an index of the memory sink
+type memoryIndex struct {
+	mapping   map[string]interface{}
+	documents map[string]map[string]interface{}
+	// versions of documents, kept after deletion like elasticsearch tombstones
+	versions map[string]int64
+}
+
+// newMemorySink return an empty memory sink
+func newMemorySink() *memorySink {
+	return &memorySink{
+		indexes: make(map[string]*memoryIndex),
+		aliases: make(map[string][]string),
+	}
+}
+
+// resolve return the indexes targeted by the index or alias name
+func (s *memorySink) resolve(name string) (z []string) {
+	if _, ok := s.indexes[name]; ok {
+		return []string{name}
+	}
+	return s.aliases[name]
+}
+
+// index return the index receiving writes on the index or alias name.
+// It is created on the fly like elasticsearch does
+func (s *memorySink) index(name string) *memoryIndex {
+	indexes := s.resolve(name)
+	if len(indexes) > 0 {
+		name = indexes[0]
+	}
+	if _, ok := s.indexes[name]; !ok {
+		s.indexes[name] = &memoryIndex{
+			documents: make(map[string]map[string]interface{}),
+			versions:  make(map[string]int64),
+		}
+	}
+	return s.indexes[name]
+}
+
+// Document return the document with the provided id
+func (s *memorySink) Document(index, id string) (map[string]interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, name := range s.resolve(index) {
+		if z, ok := s.indexes[name].documents[id]; ok {
+			return z, true
+		}
+	}
+	return nil, false
+}
+
+// Ping implements sink
+func (s *memorySink) Ping(ctx context.Context) error {
+	return nil
+}
+
+// IndexExists implements sink
+func (s *memorySink) IndexExists(ctx context.Context, index string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.resolve(index)) > 0, nil
+}
+
+// EnsureIndex implements sink
+func (s *memorySink) EnsureIndex(ctx context.Context, index string, mapping map[string]interface{}) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.resolve(index)) > 0 {
+		return false, nil
+	}
+	s.index(index).mapping = mapping
+	return true, nil
+}
+
+// Aliases implements sink
+func (s *memorySink) Aliases(ctx context.Context, index string) (z []string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for alias, indexes := range s.aliases {
+		for _, name := range indexes {
+			if name == index {
+				z = append(z, alias)
+			}
+		}
+	}
+	sort.Strings(z)
+	return
+}
+
+// AddAlias implements sink
+func (s *memorySink) AddAlias(ctx context.Context, index, alias string, writeIndex bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.indexes[index]; !ok {
+		return fmt.Errorf("index_not_found_exception: no such index [%s]", index)
+	}
+	for _, name := range s.aliases[alias] {
+		if name == index {
+			return nil
+		}
+	}
+	// the write index is the first index of the alias
+	if writeIndex {
+		s.aliases[alias] = append([]string{index}, s.aliases[alias]...)
+	} else {
+		s.aliases[alias] = append(s.aliases[alias], index)
+	}
+	return nil
+}
+
+// Search implements sink
+func (s *memorySink) Search(ctx context.Context, index string, filters map[string]interface{}) (ids []string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, name := range s.resolve(index) {
+		for id, document := range s.indexes[name].documents {
+			flatten, err := flat.Flatten(document, nil)
+			if err != nil {
+				return nil, err
+			}
+			match := true
+			for k, v := range filters {
+				if z, ok := flatten[k]; !ok || fmt.Sprint(z) != fmt.Sprint(v) {
+					match = false
+					break
+				}
+			}
+			if match {
+				ids = append(ids, id)
+			}
+		}
+	}
+	sort.Strings(ids)
+	return
+}
+
+// write apply the request on the index and return its result
+func (s *memorySink) write(r sinkRequest) sinkResult {
+	index := s.index(r.Index)
+	z := sinkResult{
+		Action: r.Action,
+		Index:  r.Index,
+		Id:     r.Id,
+		Status: http.StatusOK,
+	}
+	if r.Version > 0 && r.Action != sinkActionUpdate {
+		if current, ok := index.versions[r.Id]; ok && r.Version < current {
+			z.Status = http.StatusConflict
+			z.ErrorType = versionConflict
+			z.ErrorReason = fmt.Sprintf("[%s]: version conflict, current version [%d] is higher than the one provided [%d]", r.Id, current, r.Version)
+			return z
+		}
+	}
+
+	switch r.Action {
+	case sinkActionIndex:
+		index.documents[r.Id] = r.Document
+	case sinkActionUpdate:
+		document, ok := index.documents[r.Id]
+		if !ok {
+			z.Status = http.StatusNotFound
+			z.ErrorType = "document_missing_exception"
+			z.ErrorReason = fmt.Sprintf("[%s]: document missing", r.Id)
+			return z
+		}
+		merged := make(map[string]interface{}, len(document)+len(r.Document))
+		for k, v := range document {
+			merged[k] = v
+		}
+		for k, v := range r.Document {
+			merged[k] = v
+		}
+		index.documents[r.Id] = merged
+	case sinkActionDelete:
+		if _, ok := index.documents[r.Id]; !ok {
+			z.Status = http.StatusNotFound
+		}
+		delete(index.documents, r.Id)
+	default:
+		z.Status = http.StatusBadRequest
+		z.ErrorType = "illegal_argument_exception"
+		z.ErrorReason = fmt.Sprintf("Bulk action %s is not supported", r.Action)
+		return z
+	}
+	if r.Version > 0 {
+		index.versions[r.Id] = r.Version
+	}
+	return z
+}
+
+// Upsert implements sink
+func (s *memorySink) Upsert(ctx context.Context, index, id string, document map[string]interface{}, version int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	z := s.write(sinkRequest{Action: sinkActionIndex, Index: index, Id: id, Document: document, Version: version})
+	if z.failed() {
+		return fmt.Errorf("%s: %s", z.ErrorType, z.ErrorReason)
+	}
+	return nil
+}
+
+// Delete implements sink
+func (s *memorySink) Delete(ctx context.Context, index, id string, version int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	z := s.write(sinkRequest{Action: sinkActionDelete, Index: index, Id: id, Version: version})
+	if z.failed() {
+		return fmt.Errorf("%s: %s", z.ErrorType, z.ErrorReason)
+	}
+	return nil
+}
+
+// Bulk implements sink
+func (s *memorySink) Bulk(ctx context.Context, requests []sinkRequest, refresh bool) (results []sinkResult, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range requests {
+		results = append(results, s.write(r))
+	}
+	return
+}
+
+// AliasIndexes implements sink
+func (s *memorySink) AliasIndexes(ctx context.Context, alias string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	z := append([]string{}, s.aliases[alias]...)
+	sort.Strings(z)
+	if len(z) == 0 {
+		return nil, nil
+	}
+	return z, nil
+}
+
+// UpdateAliases implements sink
+func (s *memorySink) UpdateAliases(ctx context.Context, actions []aliasAction) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, action := range actions {
+		if _, ok := s.indexes[action.Index]; !ok {
+			return fmt.Errorf("index_not_found_exception: no such index [%s]", action.Index)
+		}
+	}
+	for _, action := range actions {
+		var indexes []string
+		for _, name := range s.aliases[action.Alias] {
+			if name != action.Index {
+				indexes = append(indexes, name)
+			}
+		}
+		if !action.Remove {
+			// the write index is the first index of the alias
+			if action.WriteIndex {
+				indexes = append([]string{action.Index}, indexes...)
+			} else {
+				indexes = append(indexes, action.Index)
+			}
+		}
+		if len(indexes) == 0 {
+			delete(s.aliases, action.Alias)
+			continue
+		}
+		s.aliases[action.Alias] = indexes
+	}
+	return nil
+}
+
+// Count implements sink
+func (s *memorySink) Count(ctx context.Context, index string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var z int64
+	for _, name := range s.resolve(index) {
+		z += int64(len(s.indexes[name].documents))
+	}
+	return z, nil
+}
+
+// DeleteIndex implements sink
+func (s *memorySink) DeleteIndex(ctx context.Context, index string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.indexes[index]; !ok {
+		return fmt.Errorf("index_not_found_exception: no such index [%s]", index)
+	}
+	delete(s.indexes, index)
+	for alias, indexes := range s.aliases {
+		var z []string
+		for _, name := range indexes {
+			if name != index {
+				z = append(z, name)
+			}
+		}
+		if len(z) == 0 {
+			delete(s.aliases, alias)
+		} else {
+			s.aliases[alias] = z
+		}
+	}
+	return nil
+}
+
+// Mapping implements sink
+func (s *memorySink) Mapping(ctx context.Context, index string) (map[string]interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	indexes := s.resolve(index)
+	if len(indexes) == 0 {
+		return nil, fmt.Errorf("index_not_found_exception: no such index [%s]", index)
+	}
+	z, _ := s.indexes[indexes[0]].mapping["mappings"].(map[string]interface{})
+	return z, nil
+}
+
+// PutMapping implements sink
+func (s *memorySink) PutMapping(ctx context.Context, index string, mappings map[string]interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	indexes := s.resolve(index)
+	if len(indexes) == 0 {
+		return fmt.Errorf("index_not_found_exception: no such index [%s]", index)
+	}
+	i := s.indexes[indexes[0]]
+	if i.mapping == nil {
+		i.mapping = make(map[string]interface{})
+	}
+	current, _ := i.mapping["mappings"].(map[string]interface{})
+	i.mapping["mappings"] = mergeMappings(current, mappings)
+	return nil
+}
+
+// mergeMappings return the current mappings with the provided ones added
+// like elasticsearch does when mappings are updated
+func mergeMappings(current, mappings map[string]interface{}) map[string]interface{} {
+	z := make(map[string]interface{})
+	for k, v := range current {
+		z[k] = v
+	}
+	for k, v := range mappings {
+		existing, ok := z[k].(map[string]interface{})
+		update, isMap := v.(map[string]interface{})
+		if ok && isMap {
+			z[k] = mergeMappings(existing, update)
+			continue
+		}
+		z[k] = v
+	}
+	return z
+}
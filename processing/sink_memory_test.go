@@ -0,0 +1,112 @@
+// Package processing provide all requirements to process change data capture
+package processing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemorySink(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	var s sink = newMemorySink()
+	assert.Nil(s.Ping(ctx))
+
+	created, err := s.EnsureIndex(ctx, "users", map[string]interface{}{})
+	assert.Nil(err)
+	assert.Equal(true, created)
+	created, err = s.EnsureIndex(ctx, "users", map[string]interface{}{})
+	assert.Nil(err)
+	assert.Equal(false, created)
+
+	assert.Nil(s.AddAlias(ctx, "users", "users_alias", true))
+	assert.Error(s.AddAlias(ctx, "fake", "users_alias", true))
+	aliases, err := s.Aliases(ctx, "users")
+	assert.Nil(err)
+	assert.Equal([]string{"users_alias"}, aliases)
+
+	exist, err := s.IndexExists(ctx, "users_alias")
+	assert.Nil(err)
+	assert.Equal(true, exist)
+
+	assert.Nil(s.Upsert(ctx, "users_alias", "1", map[string]interface{}{"name": "a", "address": map[string]interface{}{"city": "paris"}}, 10))
+	// older versions are rejected while equal ones are accepted
+	assert.Error(s.Upsert(ctx, "users_alias", "1", map[string]interface{}{"name": "b"}, 5))
+	assert.Nil(s.Upsert(ctx, "users_alias", "1", map[string]interface{}{"name": "a", "address": map[string]interface{}{"city": "paris"}}, 10))
+
+	ids, err := s.Search(ctx, "users_alias", map[string]interface{}{"address.city": "paris"})
+	assert.Nil(err)
+	assert.Equal([]string{"1"}, ids)
+
+	results, err := s.Bulk(ctx, []sinkRequest{
+		{Action: sinkActionUpdate, Index: "users_alias", Id: "1", Document: map[string]interface{}{"name": "c"}},
+		{Action: sinkActionDelete, Index: "users_alias", Id: "1", Version: 9},
+		{Action: sinkActionIndex, Index: "users_alias", Id: "2", Document: map[string]interface{}{"name": "d"}},
+		{Action: sinkActionUpdate, Index: "users_alias", Id: "3", Document: map[string]interface{}{"name": "e"}},
+	}, true)
+	assert.Nil(err)
+	assert.Equal(4, len(results))
+	assert.Equal(false, results[0].failed())
+	assert.Equal(versionConflict, results[1].ErrorType)
+	assert.Equal(false, results[2].failed())
+	assert.Equal("document_missing_exception", results[3].ErrorType)
+
+	document, ok := s.(*memorySink).Document("users", "1")
+	assert.Equal(true, ok)
+	assert.Equal("c", document["name"])
+	count, err := s.Count(ctx, "users_alias")
+	assert.Nil(err)
+	assert.Equal(int64(2), count)
+
+	// deleted documents keep their version
+	assert.Nil(s.Delete(ctx, "users", "1", 11))
+	assert.Error(s.Upsert(ctx, "users", "1", map[string]interface{}{"name": "f"}, 10))
+	count, err = s.Count(ctx, "users")
+	assert.Nil(err)
+	assert.Equal(int64(1), count)
+}
+
+func TestBulkBatch_addRequest(t *testing.T) {
+	assert := assert.New(t)
+
+	settings := bulkSettings{actions: 2, size: defaultBulkSize}
+	batch := newBulkBatch(false)
+	batch.addRequest(sinkRequest{Action: sinkActionIndex, Index: "users", Id: "1", Document: map[string]interface{}{"name": "a"}})
+	assert.Equal(false, batch.full(settings))
+	assert.Greater(batch.size, 0)
+
+	batch.addRequest(sinkRequest{Action: sinkActionDelete, Index: "users", Id: "2"})
+	assert.Equal(true, batch.full(settings))
+
+	settings = bulkSettings{actions: 10, size: 10}
+	assert.Equal(true, batch.full(settings))
+
+	batch.reset()
+	assert.Equal(0, len(batch.requests))
+	assert.Equal(0, batch.size)
+}
+
+func TestSink(t *testing.T) {
+	assert := assert.New(t)
+
+	var c Validate
+	c.validatedSchemas.Schemas = []configSchema{
+		{},
+		{Elasticsearch: elasticsearchSchema{Sink: sinkOpensearch}},
+		{Elasticsearch: elasticsearchSchema{Sink: "fake"}},
+	}
+	assert.Equal(sinkElasticsearch, c.sinkKind(0))
+	assert.Equal(sinkOpensearch, c.sinkKind(1))
+
+	memory := newMemorySink()
+	c.clients.sinks = map[string]sink{sinkElasticsearch: memory}
+	z, err := c.sink(0)
+	assert.Nil(err)
+	assert.Equal(memory, z)
+
+	_, err = c.sink(2)
+	assert.Error(err)
+}
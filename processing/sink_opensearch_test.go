@@ -0,0 +1,145 @@
+// Package processing provide all requirements to process change data capture
+package processing
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpensearchSink(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	var bulk []map[string]interface{}
+	var aliases map[string][]map[string]map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, _ := r.BasicAuth()
+		if username != "admin" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/":
+			fmt.Fprint(w, `{"version":{"distribution":"opensearch"}}`)
+		case r.Method == http.MethodHead && r.URL.Path == "/users":
+			w.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodHead:
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == http.MethodPut && r.URL.Path == "/rides":
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":{"type":"resource_already_exists_exception","reason":"index [rides] already exists"}}`)
+		case r.Method == http.MethodGet && r.URL.Path == "/users_alias/_mapping":
+			fmt.Fprint(w, `{"users-v2":{"mappings":{"properties":{"code":{"type":"keyword"}}}}}`)
+		case r.Method == http.MethodPut:
+			fmt.Fprint(w, `{"acknowledged":true}`)
+		case r.Method == http.MethodGet && r.URL.Path == "/users/_alias":
+			fmt.Fprint(w, `{"users":{"aliases":{"users_alias":{}}}}`)
+		case r.Method == http.MethodPost && r.URL.Path == "/_aliases":
+			assert.Nil(json.NewDecoder(r.Body).Decode(&aliases))
+			fmt.Fprint(w, `{"acknowledged":true}`)
+		case r.Method == http.MethodGet && r.URL.Path == "/_alias/users_alias":
+			fmt.Fprint(w, `{"users-v2":{"aliases":{"users_alias":{}}},"users":{"aliases":{"users_alias":{}}}}`)
+		case r.Method == http.MethodPost && r.URL.Path == "/users/_refresh":
+			fmt.Fprint(w, `{"_shards":{"total":1}}`)
+		case r.Method == http.MethodGet && r.URL.Path == "/users/_count":
+			fmt.Fprint(w, `{"count":42}`)
+		case r.Method == http.MethodDelete && r.URL.Path == "/users":
+			fmt.Fprint(w, `{"acknowledged":true}`)
+		case r.Method == http.MethodPost && r.URL.Path == "/users/_search":
+			fmt.Fprint(w, `{"hits":{"hits":[{"_id":"1"}]}}`)
+		case r.Method == http.MethodPost && r.URL.Path == "/_bulk":
+			assert.Equal("wait_for", r.URL.Query().Get("refresh"))
+			scanner := bufio.NewScanner(r.Body)
+			for scanner.Scan() {
+				var line map[string]interface{}
+				assert.Nil(json.Unmarshal(scanner.Bytes(), &line))
+				bulk = append(bulk, line)
+			}
+			fmt.Fprint(w, `{"errors":true,"items":[{"index":{"_index":"users","_id":"1","status":201}},{"delete":{"_index":"users","_id":"2","status":409,"error":{"type":"version_conflict_engine_exception","reason":"conflict"}}}]}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	_, err := newOpensearchSinkFromURI("fake")
+	assert.Error(err)
+
+	s, err := newOpensearchSinkFromURI(strings.Replace(server.URL, "http://", "http://admin:secret@", 1))
+	assert.Nil(err)
+	assert.Equal(server.URL, s.uri)
+	assert.Nil(s.Ping(ctx))
+
+	exist, err := s.IndexExists(ctx, "users")
+	assert.Nil(err)
+	assert.Equal(true, exist)
+
+	created, err := s.EnsureIndex(ctx, "vehicles", map[string]interface{}{})
+	assert.Nil(err)
+	assert.Equal(true, created)
+
+	created, err = s.EnsureIndex(ctx, "rides", map[string]interface{}{})
+	assert.Nil(err)
+	assert.Equal(false, created)
+
+	names, err := s.Aliases(ctx, "users")
+	assert.Nil(err)
+	assert.Equal([]string{"users_alias"}, names)
+	assert.Nil(s.AddAlias(ctx, "users", "users_alias", true))
+
+	indexes, err := s.AliasIndexes(ctx, "users_alias")
+	assert.Nil(err)
+	assert.Equal([]string{"users", "users-v2"}, indexes)
+	indexes, err = s.AliasIndexes(ctx, "missing")
+	assert.Nil(err)
+	assert.Equal(0, len(indexes))
+
+	assert.Nil(s.UpdateAliases(ctx, []aliasAction{
+		{Remove: true, Index: "users", Alias: "users_alias"},
+		{Index: "users-v2", Alias: "users_alias", WriteIndex: true},
+	}))
+	assert.Equal(2, len(aliases["actions"]))
+	assert.Equal("users", aliases["actions"][0]["remove"]["index"])
+	assert.Equal(true, aliases["actions"][1]["add"]["is_write_index"])
+
+	mappings, err := s.Mapping(ctx, "users_alias")
+	assert.Nil(err)
+	assert.Equal(map[string]interface{}{"type": "keyword"}, mappingProperties(mappings)["code"])
+	assert.Nil(s.PutMapping(ctx, "users", mappings))
+
+	count, err := s.Count(ctx, "users")
+	assert.Nil(err)
+	assert.Equal(int64(42), count)
+	assert.Nil(s.DeleteIndex(ctx, "users"))
+	assert.Error(s.DeleteIndex(ctx, "missing"))
+
+	ids, err := s.Search(ctx, "users", map[string]interface{}{"name": "a"})
+	assert.Nil(err)
+	assert.Equal([]string{"1"}, ids)
+
+	results, err := s.Bulk(ctx, []sinkRequest{
+		{Action: sinkActionIndex, Index: "users", Id: "1", Document: map[string]interface{}{"name": "a"}, Version: 10},
+		{Action: sinkActionDelete, Index: "users", Id: "2", Version: 5},
+	}, true)
+	assert.Nil(err)
+	assert.Equal(2, len(results))
+	assert.Equal(false, results[0].failed())
+	assert.Equal(versionConflict, results[1].ErrorType)
+
+	// index action and its document followed by the delete action
+	assert.Equal(3, len(bulk))
+	assert.Equal(versionType, bulk[0]["index"].(map[string]interface{})["version_type"])
+	assert.Equal("a", bulk[1]["name"])
+	assert.Equal("2", bulk[2]["delete"].(map[string]interface{})["_id"])
+
+	s.password = "fake"
+	assert.Error(s.Ping(ctx))
+}
@@ -0,0 +1,170 @@
+// Package processing provide all requirements to process change data capture
+package processing
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Lord-Y/synker/commons"
+)
+
+// consumerState hold the supervision state of a schema consumer
+type consumerState struct {
+	// Schema name
+	Schema string `json:"schema"`
+	// Topic consumed
+	Topic string `json:"topic"`
+	// Restarts is the number of times the consumer has been restarted
+	Restarts int `json:"restarts"`
+	// ConsecutiveFailures is the number of failures since the last successful run
+	ConsecutiveFailures int `json:"consecutiveFailures"`
+	// LastError is the last error returned by the consumer
+	LastError string `json:"lastError,omitempty"`
+	// LastErrorTime is the time of the last error returned by the consumer
+	LastErrorTime *time.Time `json:"lastErrorTime,omitempty"`
+	// Healthy is false once the consumer failed too many times in a row
+	Healthy bool `json:"healthy"`
+}
+
+// supervisor permit to restart failed consumers and keep track of their state
+type supervisor struct {
+	mu          sync.RWMutex
+	consumers   map[string]*consumerState
+	maxFailures int
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+}
+
+// newSupervisor return a supervisor configured from OS env variables
+func newSupervisor() *supervisor {
+	return &supervisor{
+		consumers:   make(map[string]*consumerState),
+		maxFailures: commons.GetConsumerMaxFailures(),
+		minBackoff:  commons.GetConsumerMinBackoff(),
+		maxBackoff:  commons.GetConsumerMaxBackoff(),
+	}
+}
+
+// register permit to keep track of the consumer of the schema
+func (s *supervisor) register(schema, topic string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.consumers[schema] = &consumerState{
+		Schema:  schema,
+		Topic:   topic,
+		Healthy: true,
+	}
+}
+
+// failed permit to record the error returned by the consumer of the schema
+// and return its number of consecutive failures
+func (s *supervisor) failed(schema string, err error) (failures int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, ok := s.consumers[schema]
+	if !ok {
+		return
+	}
+	now := time.Now().UTC()
+	state.Restarts++
+	state.ConsecutiveFailures++
+	state.LastError = err.Error()
+	state.LastErrorTime = &now
+	state.Healthy = state.ConsecutiveFailures < s.maxFailures
+	return state.ConsecutiveFailures
+}
+
+// succeeded permit to reset the number of consecutive failures
+// of the consumer of the schema
+func (s *supervisor) succeeded(schema string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, ok := s.consumers[schema]
+	if !ok || state.ConsecutiveFailures == 0 {
+		return
+	}
+	state.ConsecutiveFailures = 0
+	state.Healthy = true
+}
+
+// healthy return false when at least one consumer is unhealthy
+func (s *supervisor) healthy() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, state := range s.consumers {
+		if !state.Healthy {
+			return false
+		}
+	}
+	return true
+}
+
+// states return a copy of the state of all consumers sorted by schema name
+func (s *supervisor) states() (z []consumerState) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, state := range s.consumers {
+		z = append(z, *state)
+	}
+	sort.Slice(z, func(i, j int) bool {
+		return z[i].Schema < z[j].Schema
+	})
+	return
+}
+
+// backoff return the time to wait before restarting a consumer
+// using exponential backoff with jitter
+func (s *supervisor) backoff(failures int) time.Duration {
+	delay := s.minBackoff
+	for i := 1; i < failures && delay < s.maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > s.maxBackoff {
+		delay = s.maxBackoff
+	}
+	half := int64(delay / 2)
+	if half <= 0 {
+		return delay
+	}
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+// supervise permit to run the consumer of the schema
+// and restart it with exponential backoff when it fails
+// until the provided context is cancelled
+func (c *Validate) supervise(ctx context.Context, index int) {
+	schema := c.validatedSchemas.Schemas[index].Name
+	topic := c.validatedSchemas.Schemas[index].Topic.Name
+
+	for {
+		start := time.Now()
+		err := c.consume(ctx, index, topic)
+		if err == nil || ctx.Err() != nil {
+			return
+		}
+
+		// a consumer that ran longer than the maximum backoff is considered
+		// as recovered from its previous failures
+		if time.Since(start) > c.supervisor.maxBackoff {
+			c.supervisor.succeeded(schema)
+		}
+		failures := c.supervisor.failed(schema, err)
+		c.increaseMetrics("consumer", topic, "restart")
+
+		delay := c.supervisor.backoff(failures)
+		if failures >= c.supervisor.maxFailures {
+			c.Logger.Error().Err(err).Msgf("Consumer of schema %s failed %d times in a row and is now unhealthy, restarting in %s", schema, failures, delay)
+		} else {
+			c.Logger.Error().Err(err).Msgf("Consumer of schema %s failed %d times in a row, restarting in %s", schema, failures, delay)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
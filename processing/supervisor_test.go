@@ -0,0 +1,95 @@
+// Package processing provide all requirements to process change data capture
+package processing
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Lord-Y/synker/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSupervisor(t *testing.T) {
+	assert := assert.New(t)
+
+	s := newSupervisor()
+	s.maxFailures = 2
+	s.register("promo_codes", "movr.public.promo_codes")
+	s.register("users", "movr.public.users")
+	assert.Equal(true, s.healthy())
+
+	assert.Equal(1, s.failed("users", fmt.Errorf("connection refused")))
+	assert.Equal(true, s.healthy())
+	assert.Equal(2, s.failed("users", fmt.Errorf("connection refused")))
+	assert.Equal(false, s.healthy())
+
+	states := s.states()
+	assert.Equal(2, len(states))
+	assert.Equal("users", states[1].Schema)
+	assert.Equal(2, states[1].Restarts)
+	assert.Equal("connection refused", states[1].LastError)
+	assert.Equal(false, states[1].Healthy)
+
+	s.succeeded("users")
+	assert.Equal(true, s.healthy())
+	assert.Equal(2, s.states()[1].Restarts)
+	assert.Equal(0, s.states()[1].ConsecutiveFailures)
+}
+
+func TestSupervisor_backoff(t *testing.T) {
+	assert := assert.New(t)
+
+	s := newSupervisor()
+	s.minBackoff = time.Second
+	s.maxBackoff = 10 * time.Second
+
+	tests := []struct {
+		failures int
+		max      time.Duration
+	}{
+		{
+			failures: 1,
+			max:      time.Second,
+		},
+		{
+			failures: 3,
+			max:      4 * time.Second,
+		},
+		{
+			failures: 20,
+			max:      10 * time.Second,
+		},
+	}
+
+	for _, tc := range tests {
+		delay := s.backoff(tc.failures)
+		assert.GreaterOrEqual(delay, tc.max/2)
+		assert.LessOrEqual(delay, tc.max)
+	}
+}
+
+func TestSupervise_cancelled(t *testing.T) {
+	assert := assert.New(t)
+	var c Validate
+	c.Logger = logger.NewLogger()
+	c.ConfigDir = "examples/schemas"
+	c.ParseAndValidateConfig()
+	c.supervisor = newSupervisor()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.supervise(ctx, 0)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(30 * time.Second):
+		assert.Fail("Supervisor did not stop once the context has been cancelled")
+	}
+}
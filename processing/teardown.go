@@ -0,0 +1,336 @@
+// Package processing provide all requirements to process change data capture
+package processing
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Lord-Y/synker/tools"
+)
+
+const (
+	// defaultTeardownTimeout is the maximum time to wait for changefeed jobs to stop
+	defaultTeardownTimeout time.Duration = time.Minute
+	// teardownPollInterval is the time between two checks of the status of changefeed jobs
+	teardownPollInterval time.Duration = time.Second
+)
+
+// TeardownOptions hold the requirements to remove the resources of a schema
+type TeardownOptions struct {
+	// Schema name to tear down
+	Schema string
+	// DryRun only print the resources that would be removed
+	DryRun bool
+	// Detach only remove the indexes from the alias of the schema instead of deleting them
+	Detach bool
+	// Yes skip the confirmation
+	Yes bool
+	// Timeout is the maximum time to wait for changefeed jobs to stop. Default to 1m
+	Timeout time.Duration
+}
+
+// teardownPlan is the list of resources of a schema to remove
+type teardownPlan struct {
+	// Schema name
+	Schema string
+	// Jobs are the changefeed jobs to cancel
+	Jobs []changeFeedJob
+	// Groups are the kafka consumer groups to delete
+	Groups []string
+	// Topics are the kafka topics to delete
+	Topics []string
+	// Indexes are the indexes to delete
+	Indexes []string
+	// Aliases are the alias actions detaching the indexes
+	Aliases []aliasAction
+	// Kept hold the resources shared with other schemas
+	Kept []string
+	// index of the schema
+	index int
+}
+
+// Teardown permit to remove the changefeed, consumer groups, topics and indexes of a schema.
+// A summary is printed and must be confirmed unless options.Yes is set
+func (c *Validate) Teardown(options TeardownOptions) {
+	defer c.closeClients()
+
+	index := -1
+	for k, v := range c.validatedSchemas.Schemas {
+		if v.Name == options.Schema {
+			index = k
+			break
+		}
+	}
+	if index == -1 {
+		c.Logger.Fatal().Err(fmt.Errorf("Schema %s not found", options.Schema)).Msgf("Fail to tear down schema %s", options.Schema)
+		return
+	}
+
+	ctx := context.Background()
+	plan, err := c.teardownPlan(ctx, index, options)
+	if err != nil {
+		c.Logger.Fatal().Err(err).Msgf("Fail to list resources of schema %s", options.Schema)
+		return
+	}
+	if err = writeTeardown(os.Stdout, plan); err != nil {
+		c.Logger.Fatal().Err(err).Msg("Fail to print teardown summary")
+		return
+	}
+	if options.DryRun {
+		return
+	}
+	if !options.Yes {
+		confirmed, err := confirmTeardown(os.Stdin, os.Stdout, plan.Schema)
+		if err != nil {
+			c.Logger.Fatal().Err(err).Msg("Fail to read confirmation")
+			return
+		}
+		if !confirmed {
+			c.Logger.Info().Msgf("Teardown of schema %s aborted", plan.Schema)
+			return
+		}
+	}
+
+	if err = c.teardown(ctx, plan, options); err != nil {
+		c.Logger.Fatal().Err(err).Msgf("Fail to tear down schema %s", options.Schema)
+		return
+	}
+	c.Logger.Info().Msgf("Teardown of schema %s completed", options.Schema)
+}
+
+// teardownPlan permit to list the resources of the schema to remove.
+// Changefeeds and topics also used by other schemas are kept
+func (c *Validate) teardownPlan(ctx context.Context, index int, options TeardownOptions) (plan teardownPlan, err error) {
+	v := c.validatedSchemas.Schemas[index]
+	plan.Schema = v.Name
+	plan.index = index
+
+	var (
+		tables []string
+		topics []string
+	)
+	for k, s := range c.validatedSchemas.Schemas {
+		if k != index {
+			tables = append(tables, s.ChangeFeed.FullTableName)
+			topics = append(topics, s.Topic.Name, c.deadLetterTopic(k))
+		}
+	}
+
+	if cockroachFormat(c.messageFormat(index)) {
+		if tools.InSlice(v.ChangeFeed.FullTableName, tables) {
+			plan.Kept = append(plan.Kept, fmt.Sprintf("changefeed of table %s used by other schemas", v.ChangeFeed.FullTableName))
+		} else if plan.Jobs, err = c.changeFeedJobs(ctx, v.ChangeFeed); err != nil {
+			return plan, fmt.Errorf("Fail to retrieve changefeed jobs of table %s: %w", v.ChangeFeed.FullTableName, err)
+		}
+	}
+
+	plan.Groups = []string{consumerGroup(v.Name), replayGroup(v.Name)}
+
+	existing, err := c.listTopics()
+	if err != nil {
+		return
+	}
+	for _, topic := range []string{v.Topic.Name, c.deadLetterTopic(index)} {
+		switch {
+		case !tools.InSlice(topic, existing):
+			continue
+		case tools.InSlice(topic, topics):
+			plan.Kept = append(plan.Kept, fmt.Sprintf("topic %s used by other schemas", topic))
+		default:
+			plan.Topics = append(plan.Topics, topic)
+		}
+	}
+
+	err = c.teardownIndexes(ctx, index, options.Detach, &plan)
+	return
+}
+
+// teardownIndexes permit to list the indexes of the schema
+// or the alias actions detaching them when detach is true.
+// Indexes and aliases also used by other schemas are kept
+func (c *Validate) teardownIndexes(ctx context.Context, index int, detach bool, plan *teardownPlan) (err error) {
+	es := c.validatedSchemas.Schemas[index].Elasticsearch
+	alias := strings.TrimSpace(es.Index.Alias)
+	sink, err := c.sink(index)
+	if err != nil {
+		return
+	}
+
+	var shared []string
+	for k, s := range c.validatedSchemas.Schemas {
+		if k == index {
+			continue
+		}
+		for _, name := range []string{s.Elasticsearch.Index.Name, s.Elasticsearch.Index.Alias} {
+			if name = strings.TrimSpace(name); name != "" {
+				shared = append(shared, name)
+			}
+		}
+	}
+
+	if alias == "" {
+		if detach {
+			return fmt.Errorf("Index %s cannot be detached as the schema has no alias", es.Index.Name)
+		}
+		name := strings.TrimSpace(es.Index.Name)
+		exist, err := sink.IndexExists(ctx, name)
+		if err != nil || !exist {
+			return err
+		}
+		if tools.InSlice(name, shared) {
+			plan.Kept = append(plan.Kept, fmt.Sprintf("index %s used by other schemas", name))
+			return nil
+		}
+		plan.Indexes = append(plan.Indexes, name)
+		return nil
+	}
+	if tools.InSlice(alias, shared) {
+		plan.Kept = append(plan.Kept, fmt.Sprintf("alias %s used by other schemas", alias))
+		return
+	}
+
+	// indexes being rebuilt by a reindex belong to the schema too
+	for _, name := range []string{alias, reindexAlias(alias)} {
+		indexes, err := sink.AliasIndexes(ctx, name)
+		if err != nil {
+			return err
+		}
+		for _, z := range indexes {
+			// detaching the index from the alias of the schema does not affect the other schemas
+			if !detach && tools.InSlice(z, shared) {
+				if kept := fmt.Sprintf("index %s used by other schemas", z); !slices.Contains(plan.Kept, kept) {
+					plan.Kept = append(plan.Kept, kept)
+				}
+				continue
+			}
+			if detach {
+				plan.Aliases = append(plan.Aliases, aliasAction{Remove: true, Index: z, Alias: name})
+			} else if !slices.Contains(plan.Indexes, z) {
+				plan.Indexes = append(plan.Indexes, z)
+			}
+		}
+	}
+	return
+}
+
+// writeTeardown permit to print the summary of the resources to remove
+func writeTeardown(w io.Writer, plan teardownPlan) (err error) {
+	lines := []string{fmt.Sprintf("Teardown of schema %s", plan.Schema)}
+	for _, job := range plan.Jobs {
+		lines = append(lines, fmt.Sprintf("  - cancel changefeed job %d with status %s", job.Id, job.Status))
+	}
+	lines = append(lines, fmt.Sprintf("  - delete consumer groups %s", strings.Join(plan.Groups, ", ")))
+	for _, topic := range plan.Topics {
+		lines = append(lines, fmt.Sprintf("  - delete topic %s", topic))
+	}
+	for _, index := range plan.Indexes {
+		lines = append(lines, fmt.Sprintf("  - delete index %s", index))
+	}
+	for _, action := range plan.Aliases {
+		lines = append(lines, fmt.Sprintf("  - detach index %s from alias %s", action.Index, action.Alias))
+	}
+	for _, kept := range plan.Kept {
+		lines = append(lines, fmt.Sprintf("  = keep %s", kept))
+	}
+	_, err = fmt.Fprintln(w, strings.Join(lines, "\n"))
+	return
+}
+
+// confirmTeardown permit to ask the schema name to confirm the teardown
+func confirmTeardown(in io.Reader, out io.Writer, schema string) (bool, error) {
+	if _, err := fmt.Fprintf(out, "Type the schema name %s to confirm: ", schema); err != nil {
+		return false, err
+	}
+	line, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	return strings.TrimSpace(line) == schema, nil
+}
+
+// teardown permit to remove the resources of the plan in order.
+// Changefeed jobs are stopped first so nothing is written into the topics anymore
+func (c *Validate) teardown(ctx context.Context, plan teardownPlan, options TeardownOptions) (err error) {
+	timeout := options.Timeout
+	if timeout <= 0 {
+		timeout = defaultTeardownTimeout
+	}
+	for _, job := range plan.Jobs {
+		if err = c.cancelChangeFeed(ctx, job.Id); err != nil {
+			return fmt.Errorf("Fail to cancel changefeed job %d: %w", job.Id, err)
+		}
+		if err = c.waitJob(ctx, job.Id, timeout); err != nil {
+			return
+		}
+		c.Logger.Info().Msgf("Changefeed job %d cancelled", job.Id)
+	}
+
+	client, err := c.kAdmin()
+	if err != nil {
+		return
+	}
+	if err = c.deleteGroups(ctx, client, plan.Groups); err != nil {
+		return fmt.Errorf("%w, consumers of schema %s must be stopped first", err, plan.Schema)
+	}
+	c.Logger.Info().Msgf("Consumer groups %s deleted", strings.Join(plan.Groups, ", "))
+
+	if len(plan.Topics) > 0 {
+		if err = c.deleteTopics(plan.Topics); err != nil {
+			return fmt.Errorf("Fail to delete topics %s: %w", strings.Join(plan.Topics, ", "), err)
+		}
+		c.Logger.Info().Msgf("Topics %s deleted", strings.Join(plan.Topics, ", "))
+	}
+
+	return c.teardownSink(ctx, plan)
+}
+
+// teardownSink permit to delete or detach the indexes of the plan
+func (c *Validate) teardownSink(ctx context.Context, plan teardownPlan) (err error) {
+	if len(plan.Indexes) == 0 && len(plan.Aliases) == 0 {
+		return
+	}
+	sink, err := c.sink(plan.index)
+	if err != nil {
+		return
+	}
+	if len(plan.Aliases) > 0 {
+		if err = sink.UpdateAliases(ctx, plan.Aliases); err != nil {
+			return fmt.Errorf("Fail to detach indexes: %w", err)
+		}
+		c.Logger.Info().Msgf("Indexes of schema %s detached", plan.Schema)
+	}
+	for _, name := range plan.Indexes {
+		if err = sink.DeleteIndex(ctx, name); err != nil {
+			return fmt.Errorf("Fail to delete index %s: %w", name, err)
+		}
+		c.Logger.Info().Msgf("Index %s deleted", name)
+	}
+	return
+}
+
+// waitJob permit to wait until the job is no longer running
+func (c *Validate) waitJob(ctx context.Context, id int64, timeout time.Duration) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for {
+		status, err := c.jobStatus(ctx, id)
+		if err != nil {
+			return fmt.Errorf("Fail to retrieve status of job %d: %w", id, err)
+		}
+		switch status {
+		case "canceled", "failed", "succeeded":
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("Job %d still has status %s after %s", id, status, timeout)
+		case <-time.After(teardownPollInterval):
+		}
+	}
+}
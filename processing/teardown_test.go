@@ -0,0 +1,148 @@
+// Package processing provide all requirements to process change data capture
+package processing
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/Lord-Y/synker/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTeardownIndexes(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	var c Validate
+	c.Logger = logger.NewLogger()
+	c.validatedSchemas.Schemas = []configSchema{
+		{
+			Name: "users",
+			Elasticsearch: elasticsearchSchema{
+				Index: elasticsearchIndex{Name: "users", Alias: "users_alias"},
+			},
+		},
+		{
+			Name: "rides",
+			Elasticsearch: elasticsearchSchema{
+				Index: elasticsearchIndex{Name: "rides"},
+			},
+		},
+	}
+	memory := newMemorySink()
+	c.clients.sinks = map[string]sink{sinkElasticsearch: memory}
+	for _, name := range []string{"users-v2", "users-v3", "rides"} {
+		_, err := memory.EnsureIndex(ctx, name, nil)
+		assert.Nil(err)
+	}
+	assert.Nil(memory.AddAlias(ctx, "users-v2", "users_alias", true))
+	assert.Nil(memory.AddAlias(ctx, "users-v3", reindexAlias("users_alias"), false))
+
+	plan := teardownPlan{Schema: "users", index: 0}
+	assert.Nil(c.teardownIndexes(ctx, 0, true, &plan))
+	assert.Equal(0, len(plan.Indexes))
+	assert.Equal([]aliasAction{
+		{Remove: true, Index: "users-v2", Alias: "users_alias"},
+		{Remove: true, Index: "users-v3", Alias: reindexAlias("users_alias")},
+	}, plan.Aliases)
+	assert.Nil(c.teardownSink(ctx, plan))
+	indexes, err := memory.AliasIndexes(ctx, "users_alias")
+	assert.Nil(err)
+	assert.Equal(0, len(indexes))
+	exist, err := memory.IndexExists(ctx, "users-v2")
+	assert.Nil(err)
+	assert.Equal(true, exist)
+
+	assert.Nil(memory.AddAlias(ctx, "users-v2", "users_alias", true))
+	plan = teardownPlan{Schema: "users", index: 0}
+	assert.Nil(c.teardownIndexes(ctx, 0, false, &plan))
+	assert.Equal([]string{"users-v2"}, plan.Indexes)
+	assert.Nil(c.teardownSink(ctx, plan))
+	exist, err = memory.IndexExists(ctx, "users-v2")
+	assert.Nil(err)
+	assert.Equal(false, exist)
+
+	// indexes without alias can only be deleted
+	plan = teardownPlan{Schema: "rides", index: 1}
+	assert.Error(c.teardownIndexes(ctx, 1, true, &plan))
+	assert.Nil(c.teardownIndexes(ctx, 1, false, &plan))
+	assert.Equal([]string{"rides"}, plan.Indexes)
+
+	// indexes and aliases also used by other schemas are kept
+	c.validatedSchemas.Schemas = append(c.validatedSchemas.Schemas,
+		configSchema{
+			Name: "rides_archive",
+			Elasticsearch: elasticsearchSchema{
+				Index: elasticsearchIndex{Name: "rides"},
+			},
+		},
+		configSchema{
+			Name: "users_copy",
+			Elasticsearch: elasticsearchSchema{
+				Index: elasticsearchIndex{Name: "users-v3"},
+			},
+		},
+	)
+	plan = teardownPlan{Schema: "rides", index: 1}
+	assert.Nil(c.teardownIndexes(ctx, 1, false, &plan))
+	assert.Equal(0, len(plan.Indexes))
+	assert.Equal([]string{"index rides used by other schemas"}, plan.Kept)
+
+	assert.Nil(memory.AddAlias(ctx, "users-v3", "users_alias", true))
+	plan = teardownPlan{Schema: "users", index: 0}
+	assert.Nil(c.teardownIndexes(ctx, 0, false, &plan))
+	assert.Equal(0, len(plan.Indexes))
+	assert.Equal([]string{"index users-v3 used by other schemas"}, plan.Kept)
+
+	c.validatedSchemas.Schemas[3].Elasticsearch.Index.Alias = "users_alias"
+	for _, detach := range []bool{true, false} {
+		plan = teardownPlan{Schema: "users", index: 0}
+		assert.Nil(c.teardownIndexes(ctx, 0, detach, &plan))
+		assert.Equal(0, len(plan.Indexes))
+		assert.Equal(0, len(plan.Aliases))
+		assert.Equal([]string{"alias users_alias used by other schemas"}, plan.Kept)
+	}
+}
+
+func TestWriteTeardown(t *testing.T) {
+	assert := assert.New(t)
+
+	var b bytes.Buffer
+	assert.Nil(writeTeardown(&b, teardownPlan{
+		Schema:  "users",
+		Jobs:    []changeFeedJob{{Id: 42, Status: "paused"}},
+		Groups:  []string{consumerGroup("users"), replayGroup("users")},
+		Topics:  []string{"movr.public.users", "movr.public.users_dlq"},
+		Indexes: []string{"users"},
+		Kept:    []string{"topic movr.public.rides used by other schemas"},
+	}))
+	assert.Contains(b.String(), "cancel changefeed job 42 with status paused")
+	assert.Contains(b.String(), "delete consumer groups synker_users, synker_users_dlq_replay")
+	assert.Contains(b.String(), "delete topic movr.public.users_dlq")
+	assert.Contains(b.String(), "delete index users")
+	assert.Contains(b.String(), "keep topic movr.public.rides")
+}
+
+func TestConfirmTeardown(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		input   string
+		confirm bool
+	}{
+		{input: "users\n", confirm: true},
+		{input: "users", confirm: true},
+		{input: "y\n"},
+		{input: ""},
+	}
+
+	for _, tc := range tests {
+		var b bytes.Buffer
+		confirmed, err := confirmTeardown(strings.NewReader(tc.input), &b, "users")
+		assert.Nil(err)
+		assert.Equal(tc.confirm, confirmed)
+		assert.Contains(b.String(), "Type the schema name users")
+	}
+}
@@ -0,0 +1,73 @@
+// Package processing provide all requirements to process change data capture
+package processing
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// templatePlaceholder match placeholders like {column} or {column:2006.01} in templates
+var templatePlaceholder = regexp.MustCompile(`\{([^{}]+)\}`)
+
+// templatePlaceholderParts return the column name of the placeholder
+// and the optional date layout used to format its value
+func templatePlaceholderParts(placeholder string) (name, layout string) {
+	name, layout, _ = strings.Cut(placeholder, ":")
+	return strings.TrimSpace(name), strings.TrimSpace(layout)
+}
+
+// templateFields return the list of columns used in the template
+func templateFields(template string) (fields []string) {
+	for _, match := range templatePlaceholder.FindAllStringSubmatch(template, -1) {
+		name, _ := templatePlaceholderParts(match[1])
+		fields = append(fields, name)
+	}
+	return
+}
+
+// renderTemplate permit to replace all placeholders of the template
+// with the values of the provided fields.
+// Values of placeholders with a layout are formatted as dates
+func renderTemplate(template string, fields map[string]interface{}) (z string, err error) {
+	z = templatePlaceholder.ReplaceAllStringFunc(template, func(placeholder string) string {
+		if err != nil {
+			return placeholder
+		}
+		name, layout := templatePlaceholderParts(placeholder[1 : len(placeholder)-1])
+		v, ok := fields[name]
+		if !ok || v == nil {
+			err = fmt.Errorf("Column %s of template `%s` is missing or null", name, template)
+			return placeholder
+		}
+		if layout != "" {
+			t, e := parseDate(v, "")
+			if e != nil {
+				err = fmt.Errorf("Column %s of template `%s` is not a date: %w", name, template, e)
+				return placeholder
+			}
+			return t.Format(layout)
+		}
+		return templateValue(v)
+	})
+	return
+}
+
+// templateValue return the string representation of the value
+// without exponent on numbers decoded from json
+func templateValue(v interface{}) string {
+	switch z := v.(type) {
+	case json.Number:
+		if x, ok := numberValue(z).(float64); ok {
+			return strconv.FormatFloat(x, 'f', -1, 64)
+		}
+		return z.String()
+	case float64:
+		return strconv.FormatFloat(z, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(z), 'f', -1, 32)
+	}
+	return fmt.Sprint(v)
+}
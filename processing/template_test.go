@@ -0,0 +1,84 @@
+// Package processing provide all requirements to process change data capture
+package processing
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplateFields(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal([]string{"city", "id"}, templateFields("{city}-{ id }"))
+	assert.Equal([]string{"timestamp"}, templateFields("vlh-{timestamp:2006.01}"))
+	assert.Nil(templateFields("static"))
+}
+
+func TestRenderTemplate(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		template string
+		fields   map[string]interface{}
+		expected string
+		fail     bool
+	}{
+		{
+			template: "{city}/{id}",
+			fields: map[string]interface{}{
+				"city": "paris",
+				"id":   float64(1234567890),
+			},
+			expected: "paris/1234567890",
+		},
+		{
+			template: "{city}/{id}",
+			fields: map[string]interface{}{
+				"city": "paris",
+				"id":   json.Number("946271346521194497"),
+			},
+			expected: "paris/946271346521194497",
+		},
+		{
+			template: "{city}/{id}",
+			fields: map[string]interface{}{
+				"city": "paris",
+			},
+			fail: true,
+		},
+		{
+			template: "{city}-{creation_time:2006.01.02}",
+			fields: map[string]interface{}{
+				"city":          "paris",
+				"creation_time": "2024-03-01T10:20:30.123456",
+			},
+			expected: "paris-2024.03.01",
+		},
+		{
+			template: "{city:2006}",
+			fields: map[string]interface{}{
+				"city": "paris",
+			},
+			fail: true,
+		},
+		{
+			template: "{city}",
+			fields: map[string]interface{}{
+				"city": nil,
+			},
+			fail: true,
+		},
+	}
+
+	for _, tc := range tests {
+		z, err := renderTemplate(tc.template, tc.fields)
+		if tc.fail {
+			assert.Error(err)
+		} else {
+			assert.Nil(err)
+			assert.Equal(tc.expected, z)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+// Package processing provide all requirements to process change data capture
+package processing
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// transformCastString cast fields into string
+	transformCastString string = "string"
+	// transformCastLong cast fields into integer
+	transformCastLong string = "long"
+	// transformCastDouble cast fields into float
+	transformCastDouble string = "double"
+	// transformCastBoolean cast fields into boolean
+	transformCastBoolean string = "boolean"
+	// transformCastDate cast fields into RFC3339 date
+	transformCastDate string = "date"
+)
+
+// transformDateLayouts are the layouts used to parse dates
+// when no layout is provided. Cockroach timestamps have no time zone
+var transformDateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02",
+}
+
+// operations return the name of the operations set in the step
+func (t transformStep) operations() (z []string) {
+	if t.Rename != nil {
+		z = append(z, "rename")
+	}
+	if t.Drop != nil {
+		z = append(z, "drop")
+	}
+	if t.Include != nil {
+		z = append(z, "include")
+	}
+	if t.Cast != nil {
+		z = append(z, "cast")
+	}
+	if t.Set != nil {
+		z = append(z, "set")
+	}
+	if t.Concat != nil {
+		z = append(z, "concat")
+	}
+	if t.Copy != nil {
+		z = append(z, "copy")
+	}
+	return
+}
+
+// validateTransform permit to check transform requirements
+func validateTransform(steps []transformStep) (err error) {
+	for k, step := range steps {
+		operations := step.operations()
+		if len(operations) != 1 {
+			return fmt.Errorf("Transform step %d must contain exactly one operation, got %d", k+1, len(operations))
+		}
+		switch {
+		case step.Rename != nil:
+			if strings.TrimSpace(step.Rename.From) == "" || strings.TrimSpace(step.Rename.To) == "" {
+				return fmt.Errorf("Transform step %d rename requires from and to", k+1)
+			}
+		case step.Drop != nil:
+			if len(step.Drop) == 0 {
+				return fmt.Errorf("Transform step %d drop requires at least one field", k+1)
+			}
+		case step.Include != nil:
+			if len(step.Include) == 0 {
+				return fmt.Errorf("Transform step %d include requires at least one field", k+1)
+			}
+		case step.Cast != nil:
+			if strings.TrimSpace(step.Cast.Field) == "" {
+				return fmt.Errorf("Transform step %d cast requires field", k+1)
+			}
+			switch step.Cast.Type {
+			case transformCastString, transformCastLong, transformCastDouble, transformCastBoolean:
+				if step.Cast.Layout != "" {
+					return fmt.Errorf("Transform step %d cast layout can only be used with type %s", k+1, transformCastDate)
+				}
+			case transformCastDate:
+			default:
+				return fmt.Errorf("Transform step %d cast type %s is not one of string, long, double, boolean or date", k+1, step.Cast.Type)
+			}
+		case step.Set != nil:
+			if strings.TrimSpace(step.Set.Field) == "" {
+				return fmt.Errorf("Transform step %d set requires field", k+1)
+			}
+		case step.Concat != nil:
+			if len(step.Concat.Fields) == 0 || strings.TrimSpace(step.Concat.To) == "" {
+				return fmt.Errorf("Transform step %d concat requires fields and to", k+1)
+			}
+		case step.Copy != nil:
+			if len(step.Copy.Fields) == 0 || strings.TrimSpace(step.Copy.To) == "" {
+				return fmt.Errorf("Transform step %d copy requires fields and to", k+1)
+			}
+		}
+	}
+	return
+}
+
+// transform permit to apply the transform steps of the schema on the document
+func (c *Validate) transform(index int, document map[string]interface{}) (map[string]interface{}, error) {
+	return applyTransform(c.validatedSchemas.Schemas[index].Transform, document)
+}
+
+// applyTransform permit to apply the transform steps in order on a copy of the document.
+// Missing fields are ignored
+func applyTransform(steps []transformStep, document map[string]interface{}) (z map[string]interface{}, err error) {
+	if len(steps) == 0 || document == nil {
+		return document, nil
+	}
+
+	z = make(map[string]interface{}, len(document))
+	for k, v := range document {
+		z[k] = v
+	}
+
+	for k, step := range steps {
+		switch {
+		case step.Rename != nil:
+			if v, ok := z[step.Rename.From]; ok {
+				delete(z, step.Rename.From)
+				z[step.Rename.To] = v
+			}
+		case step.Drop != nil:
+			for _, field := range step.Drop {
+				delete(z, field)
+			}
+		case step.Include != nil:
+			include := make(map[string]interface{}, len(step.Include))
+			for _, field := range step.Include {
+				if v, ok := z[field]; ok {
+					include[field] = v
+				}
+			}
+			z = include
+		case step.Cast != nil:
+			v, ok := z[step.Cast.Field]
+			if !ok || v == nil {
+				continue
+			}
+			z[step.Cast.Field], err = castValue(v, step.Cast)
+			if err != nil {
+				return nil, fmt.Errorf("Transform step %d fail to cast field %s: %w", k+1, step.Cast.Field, err)
+			}
+		case step.Set != nil:
+			z[step.Set.Field] = step.Set.Value
+		case step.Concat != nil:
+			var values []string
+			for _, field := range step.Concat.Fields {
+				if v, ok := z[field]; ok && v != nil {
+					values = append(values, templateValue(v))
+				}
+			}
+			z[step.Concat.To] = strings.Join(values, step.Concat.Separator)
+		case step.Copy != nil:
+			sub, ok := z[step.Copy.To].(map[string]interface{})
+			if !ok {
+				sub = make(map[string]interface{}, len(step.Copy.Fields))
+			}
+			for _, field := range step.Copy.Fields {
+				if v, ok := z[field]; ok {
+					sub[field] = v
+				}
+			}
+			z[step.Copy.To] = sub
+		}
+	}
+	return
+}
+
+// castValue permit to cast the value into the provided type
+func castValue(v interface{}, cast *transformCast) (z interface{}, err error) {
+	if n, ok := v.(json.Number); ok && cast.Type != transformCastString {
+		v = numberValue(n)
+	}
+	switch cast.Type {
+	case transformCastString:
+		switch x := v.(type) {
+		case map[string]interface{}, []interface{}:
+			b, err := json.Marshal(x)
+			return string(b), err
+		case time.Time:
+			return x.Format(time.RFC3339Nano), nil
+		}
+		return templateValue(v), nil
+	case transformCastLong:
+		switch x := v.(type) {
+		case float64:
+			return int64(math.Trunc(x)), nil
+		case float32:
+			return int64(math.Trunc(float64(x))), nil
+		case int, int8, int16, int32, int64:
+			return x, nil
+		case bool:
+			if x {
+				return int64(1), nil
+			}
+			return int64(0), nil
+		case string:
+			i, err := strconv.ParseInt(strings.TrimSpace(x), 10, 64)
+			if err == nil {
+				return i, nil
+			}
+			f, err := strconv.ParseFloat(strings.TrimSpace(x), 64)
+			if err != nil {
+				return nil, err
+			}
+			return int64(math.Trunc(f)), nil
+		}
+	case transformCastDouble:
+		switch x := v.(type) {
+		case float64, float32:
+			return x, nil
+		case int:
+			return float64(x), nil
+		case int8:
+			return float64(x), nil
+		case int16:
+			return float64(x), nil
+		case int32:
+			return float64(x), nil
+		case int64:
+			return float64(x), nil
+		case string:
+			return strconv.ParseFloat(strings.TrimSpace(x), 64)
+		}
+	case transformCastBoolean:
+		switch x := v.(type) {
+		case bool:
+			return x, nil
+		case float64:
+			return x != 0, nil
+		case int64:
+			return x != 0, nil
+		case string:
+			return strconv.ParseBool(strings.TrimSpace(x))
+		}
+	case transformCastDate:
+		t, err := parseDate(v, cast.Layout)
+		if err != nil {
+			return nil, err
+		}
+		return t.Format(time.RFC3339Nano), nil
+	}
+	return nil, fmt.Errorf("Value %v of type %T cannot be cast into %s", v, v, cast.Type)
+}
+
+// parseDate return the UTC time of the value.
+// Numbers are unix timestamps in seconds and strings are parsed
+// with the provided layout or the default ones
+func parseDate(v interface{}, layout string) (time.Time, error) {
+	switch x := v.(type) {
+	case time.Time:
+		return x.UTC(), nil
+	case json.Number:
+		return parseDate(numberValue(x), layout)
+	case float64:
+		sec, frac := math.Modf(x)
+		return time.Unix(int64(sec), int64(frac*1e9)).UTC(), nil
+	case int64:
+		return time.Unix(x, 0).UTC(), nil
+	case string:
+		layouts := transformDateLayouts
+		if layout != "" {
+			layouts = []string{layout}
+		}
+		for _, layout := range layouts {
+			t, err := time.Parse(layout, strings.TrimSpace(x))
+			if err == nil {
+				return t.UTC(), nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("Date %s does not match any layout", x)
+	}
+	return time.Time{}, fmt.Errorf("Value %v of type %T cannot be cast into %s", v, v, transformCastDate)
+}
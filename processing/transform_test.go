@@ -0,0 +1,160 @@
+// Package processing provide all requirements to process change data capture
+package processing
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateTransform(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		steps []transformStep
+		fail  bool
+	}{
+		{},
+		{
+			steps: []transformStep{
+				{Rename: &transformRename{From: "a", To: "b"}},
+				{Drop: []string{"c"}},
+				{Include: []string{"b"}},
+				{Cast: &transformCast{Field: "b", Type: "date", Layout: "2006-01-02"}},
+				{Set: &transformSet{Field: "source", Value: "cockroach"}},
+				{Concat: &transformConcat{Fields: []string{"a", "b"}, To: "c"}},
+				{Copy: &transformCopy{Fields: []string{"a"}, To: "sub"}},
+			},
+		},
+		{
+			steps: []transformStep{{}},
+			fail:  true,
+		},
+		{
+			steps: []transformStep{
+				{Rename: &transformRename{From: "a", To: "b"}, Drop: []string{"c"}},
+			},
+			fail: true,
+		},
+		{
+			steps: []transformStep{
+				{Rename: &transformRename{From: "a"}},
+			},
+			fail: true,
+		},
+		{
+			steps: []transformStep{
+				{Cast: &transformCast{Field: "a", Type: "integer"}},
+			},
+			fail: true,
+		},
+		{
+			steps: []transformStep{
+				{Cast: &transformCast{Field: "a", Type: "long", Layout: "2006"}},
+			},
+			fail: true,
+		},
+		{
+			steps: []transformStep{
+				{Drop: []string{}},
+			},
+			fail: true,
+		},
+	}
+
+	for _, tc := range tests {
+		err := validateTransform(tc.steps)
+		if tc.fail {
+			assert.Error(err)
+		} else {
+			assert.Nil(err)
+		}
+	}
+}
+
+func TestApplyTransform(t *testing.T) {
+	assert := assert.New(t)
+
+	document := map[string]interface{}{
+		"id":            "1",
+		"first_name":    "jane",
+		"last_name":     "doe",
+		"usage_count":   "12",
+		"creation_time": "2024-03-01T10:20:30.123456",
+		"secret":        "x",
+		"city":          "paris",
+	}
+	steps := []transformStep{
+		{Rename: &transformRename{From: "usage_count", To: "usage"}},
+		{Cast: &transformCast{Field: "usage", Type: "long"}},
+		{Cast: &transformCast{Field: "creation_time", Type: "date"}},
+		{Concat: &transformConcat{Fields: []string{"first_name", "last_name"}, Separator: " ", To: "name"}},
+		{Copy: &transformCopy{Fields: []string{"city"}, To: "location"}},
+		{Drop: []string{"secret", "missing"}},
+		{Set: &transformSet{Field: "source", Value: "cockroach"}},
+		{Include: []string{"id", "name", "usage", "creation_time", "location", "source"}},
+	}
+
+	z, err := applyTransform(steps, document)
+	assert.Nil(err)
+	assert.Equal(
+		map[string]interface{}{
+			"id":            "1",
+			"name":          "jane doe",
+			"usage":         int64(12),
+			"creation_time": "2024-03-01T10:20:30.123456Z",
+			"location":      map[string]interface{}{"city": "paris"},
+			"source":        "cockroach",
+		},
+		z,
+	)
+	// the original document is left untouched
+	assert.Equal("12", document["usage_count"])
+
+	_, err = applyTransform(
+		[]transformStep{
+			{Cast: &transformCast{Field: "city", Type: "double"}},
+		},
+		document,
+	)
+	assert.Error(err)
+}
+
+func TestCastValue(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		value    interface{}
+		cast     transformCast
+		expected interface{}
+		fail     bool
+	}{
+		{value: float64(3.7), cast: transformCast{Type: "long"}, expected: int64(3)},
+		{value: "3.5", cast: transformCast{Type: "double"}, expected: 3.5},
+		{value: float64(42), cast: transformCast{Type: "string"}, expected: "42"},
+		{value: map[string]interface{}{"a": true}, cast: transformCast{Type: "string"}, expected: `{"a":true}`},
+		{value: "true", cast: transformCast{Type: "boolean"}, expected: true},
+		{value: float64(0), cast: transformCast{Type: "boolean"}, expected: false},
+		{value: "01/03/2024", cast: transformCast{Type: "date", Layout: "02/01/2006"}, expected: "2024-03-01T00:00:00Z"},
+		{value: float64(0), cast: transformCast{Type: "date"}, expected: "1970-01-01T00:00:00Z"},
+		{value: "fake", cast: transformCast{Type: "date"}, fail: true},
+		{value: "fake", cast: transformCast{Type: "long"}, fail: true},
+		{value: json.Number("946271346521194497"), cast: transformCast{Type: "long"}, expected: int64(946271346521194497)},
+		{value: json.Number("946271346521194497"), cast: transformCast{Type: "string"}, expected: "946271346521194497"},
+		{value: json.Number("2.5"), cast: transformCast{Type: "double"}, expected: 2.5},
+		{value: json.Number("1"), cast: transformCast{Type: "boolean"}, expected: true},
+		{value: json.Number("0"), cast: transformCast{Type: "date"}, expected: "1970-01-01T00:00:00Z"},
+	}
+
+	for _, tc := range tests {
+		cast := tc.cast
+		z, err := castValue(tc.value, &cast)
+		if tc.fail {
+			assert.Error(err)
+		} else {
+			assert.Nil(err)
+			assert.Equal(tc.expected, z)
+		}
+	}
+}
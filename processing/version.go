@@ -0,0 +1,48 @@
+// Package processing provide all requirements to process change data capture
+package processing
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// versionType is the elasticsearch version type of documents.
+	// Versions equal to the current one are accepted as changes committed
+	// at the same wall time only differ by their logical clock
+	versionType string = "external_gte"
+	// versionConflict is the elasticsearch error returned on stale writes
+	versionConflict string = "version_conflict_engine_exception"
+)
+
+// changeVersion return the elasticsearch external version built from
+// the `updated` hybrid logical clock timestamp of the changefeed like
+// `1532377312562986715.0000000001`.
+// The version is the wall time in nanoseconds. The logical clock cannot fit
+// into the version so changes of a row committed at the same wall time
+// get the same version and are applied in the order of the topic with external_gte.
+// ok is false when the changefeed does not provide the `updated` option
+func changeVersion(updated interface{}) (version int64, ok bool, err error) {
+	if updated == nil {
+		return
+	}
+	s, isString := updated.(string)
+	if !isString || strings.TrimSpace(s) == "" {
+		return 0, false, fmt.Errorf("Updated timestamp %v is not a valid string", updated)
+	}
+
+	wall, logical, _ := strings.Cut(strings.TrimSpace(s), ".")
+	nanos, err := strconv.ParseInt(wall, 10, 64)
+	if err != nil || nanos < 0 {
+		return 0, false, fmt.Errorf("Fail to parse wall time of updated timestamp %s", s)
+	}
+
+	if logical != "" {
+		ticks, err := strconv.ParseInt(logical, 10, 64)
+		if err != nil || ticks < 0 {
+			return 0, false, fmt.Errorf("Fail to parse logical clock of updated timestamp %s", s)
+		}
+	}
+	return nanos, true, nil
+}
@@ -0,0 +1,82 @@
+// Package processing provide all requirements to process change data capture
+package processing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChangeVersion(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		updated  interface{}
+		expected int64
+		ok       bool
+		fail     bool
+	}{
+		{
+			updated: nil,
+		},
+		{
+			updated:  "1532377312562986715.0000000000",
+			expected: 1532377312562986715,
+			ok:       true,
+		},
+		{
+			updated:  "1532377312562986715.0000000002",
+			expected: 1532377312562986715,
+			ok:       true,
+		},
+		{
+			updated:  "1532377312562986715.0000005000",
+			expected: 1532377312562986715,
+			ok:       true,
+		},
+		{
+			updated:  "1532377312562986715",
+			expected: 1532377312562986715,
+			ok:       true,
+		},
+		{
+			updated: "fake",
+			fail:    true,
+		},
+		{
+			updated: float64(1),
+			fail:    true,
+		},
+	}
+
+	for _, tc := range tests {
+		version, ok, err := changeVersion(tc.updated)
+		if tc.fail {
+			assert.Error(err)
+		} else {
+			assert.Nil(err)
+			assert.Equal(tc.ok, ok)
+			assert.Equal(tc.expected, version)
+		}
+	}
+
+	older, _, _ := changeVersion("1532377312562986715.0000000001")
+	newer, _, _ := changeVersion("1532377312562987715.0000000000")
+	assert.Less(older, newer)
+
+	// commits in the same microsecond keep their order
+	older, _, _ = changeVersion("1532377312562986100.0000000000")
+	newer, _, _ = changeVersion("1532377312562986900.0000000000")
+	assert.Less(older, newer)
+
+	// a newer commit at the same wall time is not stale
+	s := newMemorySink()
+	older, _, _ = changeVersion("1532377312562986715.0000000000")
+	newer, _, _ = changeVersion("1532377312562986715.0000000001")
+	ctx := context.Background()
+	assert.Nil(s.Upsert(ctx, "users", "1", map[string]interface{}{"name": "a"}, older))
+	assert.Nil(s.Upsert(ctx, "users", "1", map[string]interface{}{"name": "b"}, newer))
+	document, _ := s.Document("users", "1")
+	assert.Equal("b", document["name"])
+}